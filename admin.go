@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// ADMIN_USERS lists who may act on the saver from the web UI, as
+// comma-separated user:password pairs checked with HTTP basic auth. Without
+// any, the admin actions are off.
+var ADMIN_USERS = map[string]string{}
+
+func init() {
+	for _, item := range strings.Split(os.Getenv("ADMIN_USERS"), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		user, password, ok := strings.Cut(item, ":")
+		if user == "" || !ok || password == "" {
+			slog.Warn("ignoring admin user without a password", "user", user)
+			continue
+		}
+		ADMIN_USERS[user] = password
+	}
+}
+
+// adminUser returns who made r, if they are an admin.
+func adminUser(r *http.Request) (string, bool) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", false
+	}
+	want, known := ADMIN_USERS[user]
+	if subtle.ConstantTimeCompare([]byte(password), []byte(want)) != 1 || !known {
+		return "", false
+	}
+	return user, true
+}
+
+// sameOrigin tells whether r was sent from one of our pages: browsers attach
+// basic auth credentials to form posts from other sites too.
+func sameOrigin(r *http.Request) bool {
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin", "none":
+	default:
+		return false
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		return err == nil && u.Host == r.Host
+	}
+	return true
+}
+
+// requireAdmin lets only admins through to h, which gets who they are.
+func requireAdmin(h func(w http.ResponseWriter, r *http.Request, actor string)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(ADMIN_USERS) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		actor, ok := adminUser(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="fdroidswh admin", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if r.Method != http.MethodGet && !sameOrigin(r) {
+			http.Error(w, "cross-site request refused", http.StatusForbidden)
+			return
+		}
+		h(w, r, actor)
+	}
+}
+
+// recordAdminAction keeps who did what, pkg is empty for bulk actions.
+func recordAdminAction(ctx context.Context, actor, action, pkg, filter string, affected int64, now time.Time) {
+	slog.Info("admin action", "actor", actor, "action", action, "package", pkg, "filter", filter, "affected", affected)
+	if err := dbWriteSqlc.CreateAdminAction(ctx, db.CreateAdminActionParams{
+		Actor:     actor,
+		Action:    action,
+		Package:   sql.NullString{String: pkg, Valid: pkg != ""},
+		Filter:    filter,
+		Affected:  affected,
+		CreatedAt: now.UnixMilli(),
+	}); err != nil {
+		slog.Error("CreateAdminAction", "actor", actor, "action", action, "err", err)
+	}
+}
+
+// saveNow queues pkg right away, ahead of the scheduler's ranking but
+// through the same queue, with a fresh retry budget even if its saves were
+// given up on. It returns how many queue items it added, none if the origin
+// is queued already.
+func saveNow(ctx context.Context, archivers []Archiver, pkg string, now time.Time) (int64, error) {
+	app, err := dbWriteSqlc.GetApp(ctx, pkg)
+	if err != nil {
+		return 0, err
+	}
+	if err := dbWriteSqlc.ResetSaveFailures(ctx, pkg); err != nil {
+		return 0, err
+	}
+	return int64(enqueueSave(ctx, archivers, db.AppsOrdered(app), now)), nil
+}
+
+// resetApp forgets pkg's failures and saves, source tarballs included, so the
+// scheduler treats it as never saved, and drops its queued work.
+func resetApp(ctx context.Context, pkg string) error {
+	if _, err := dbWriteSqlc.GetApp(ctx, pkg); err != nil {
+		return err
+	}
+	if _, err := dbWriteSqlc.CancelAppQueue(ctx, pkg); err != nil {
+		return err
+	}
+	if err := dbWriteSqlc.ResetVersionSaveRetries(ctx, pkg); err != nil {
+		return err
+	}
+	return dbWriteSqlc.ResetApp(ctx, pkg)
+}
+
+// requeueFailed makes failing apps due again with a fresh retry budget, the
+// scheduler picks them up by priority. class and host limit it to apps whose
+// last failure was of that class and whose source code is on that host.
+func requeueFailed(ctx context.Context, class, host string, now time.Time) (int64, error) {
+	apps, err := dbWriteSqlc.GetFailedApps(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, app := range apps {
+		if class != "" && app.LastErrorClass.String != class {
+			continue
+		}
+		if host != "" {
+			if u, err := url.Parse(app.MetaSourceCode); err != nil || u.Hostname() != host {
+				continue
+			}
+		}
+		if err := dbWriteSqlc.RequeueApp(ctx, db.RequeueAppParams{
+			NextAttemptAt: now.UnixMilli(),
+			Package:       app.Package,
+		}); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// FailureGroup counts failing apps by the class of their last failure.
+type FailureGroup struct {
+	Class string
+	Apps  int
+}
+
+func adminRoutes(ctx context.Context, mux *http.ServeMux, archivers []Archiver) {
+	appAction := func(action string, do func(pkg string) (int64, error)) http.HandlerFunc {
+		return requireAdmin(func(w http.ResponseWriter, r *http.Request, actor string) {
+			pkg := r.PathValue("package")
+			n, err := do(pkg)
+			if errors.Is(err, sql.ErrNoRows) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			recordAdminAction(ctx, actor, action, pkg, "", n, time.Now())
+			http.Redirect(w, r, "/app/"+url.PathEscape(pkg), http.StatusSeeOther)
+		})
+	}
+	mux.HandleFunc("POST /admin/apps/{package}/save", appAction("save", func(pkg string) (int64, error) {
+		return saveNow(ctx, archivers, pkg, time.Now())
+	}))
+	mux.HandleFunc("POST /admin/apps/{package}/reset", appAction("reset", func(pkg string) (int64, error) {
+		defer wakeScheduler()
+		return 1, resetApp(ctx, pkg)
+	}))
+	mux.HandleFunc("POST /admin/apps/{package}/cancel", appAction("cancel", func(pkg string) (int64, error) {
+		return dbWriteSqlc.CancelAppQueue(ctx, pkg)
+	}))
+
+	mux.HandleFunc("POST /admin/requeue", requireAdmin(func(w http.ResponseWriter, r *http.Request, actor string) {
+		class := strings.TrimSpace(r.FormValue("class"))
+		host := strings.TrimSpace(r.FormValue("host"))
+		n, err := requeueFailed(ctx, class, host, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var filter []string
+		if class != "" {
+			filter = append(filter, "class="+class)
+		}
+		if host != "" {
+			filter = append(filter, "host="+host)
+		}
+		recordAdminAction(ctx, actor, "requeue", "", strings.Join(filter, " "), n, time.Now())
+		wakeScheduler()
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}))
+	mux.HandleFunc("POST /admin/cancel", requireAdmin(func(w http.ResponseWriter, r *http.Request, actor string) {
+		n, err := dbWriteSqlc.CancelQueue(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		recordAdminAction(ctx, actor, "cancel_all", "", "", n, time.Now())
+		http.Redirect(w, r, "/admin", http.StatusSeeOther)
+	}))
+
+	pauseAction := func(action string, do func(component, actor, reason string) (bool, error)) http.HandlerFunc {
+		return requireAdmin(func(w http.ResponseWriter, r *http.Request, actor string) {
+			component := r.PathValue("component")
+			changed, err := do(component, actor, strings.TrimSpace(r.FormValue("reason")))
+			if errors.Is(err, unknownComponent) {
+				http.NotFound(w, r)
+				return
+			} else if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			var n int64
+			if changed {
+				n = 1
+			}
+			recordAdminAction(ctx, actor, action, "", component, n, time.Now())
+			http.Redirect(w, r, "/admin", http.StatusSeeOther)
+		})
+	}
+	mux.HandleFunc("POST /admin/pause/{component}", pauseAction("pause", func(component, actor, reason string) (bool, error) {
+		return pauseComponent(ctx, component, actor, reason, time.Now())
+	}))
+	mux.HandleFunc("POST /admin/resume/{component}", pauseAction("resume", func(component, _, _ string) (bool, error) {
+		return resumeComponent(ctx, component)
+	}))
+
+	mux.HandleFunc("GET /admin", requireAdmin(func(w http.ResponseWriter, r *http.Request, actor string) {
+		failed, err := dbWriteSqlc.GetFailedApps(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var groups []FailureGroup
+		for _, app := range failed {
+			i := slices.IndexFunc(groups, func(g FailureGroup) bool { return g.Class == app.LastErrorClass.String })
+			if i < 0 {
+				groups = append(groups, FailureGroup{Class: app.LastErrorClass.String})
+				i = len(groups) - 1
+			}
+			groups[i].Apps++
+		}
+		slices.SortFunc(groups, func(a, b FailureGroup) int { return b.Apps - a.Apps })
+
+		queue, err := dbWriteSqlc.CountQueue(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pauses, err := pausesOf(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type componentView struct {
+			Name   string
+			Paused *PauseView
+		}
+		var components []componentView
+		for _, name := range pausable {
+			c := componentView{Name: name}
+			for _, p := range pauses {
+				if p.Component == name {
+					c.Paused = &p
+				}
+			}
+			components = append(components, c)
+		}
+
+		actions, err := dbWriteSqlc.GetAdminActions(ctx, 100)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		type actionView struct {
+			db.AdminAction
+			At string
+		}
+		var log []actionView
+		for _, a := range actions {
+			log = append(log, actionView{a, formatTime(time.UnixMilli(a.CreatedAt))})
+		}
+
+		tmpl := `
+        <!DOCTYPE html>
+        <html>
+        <head>
+            <title>F-Droid Archive Status - Admin</title>
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-QWTKZyjpPEjISv5WaRU9O52fxxpTacIQykVvG9vrhcFDFCmGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
+        </head>
+        <body>
+            <div class="container">
+                <h1>Admin</h1>
+                <p><a href="/">Back to all apps</a> | logged in as {{.Actor}}</p>
+                <h2>Components</h2>
+                <table class="table">
+                    <tbody>
+                        {{range .Components}}
+                        <tr>
+                            <td>{{.Name}}</td>
+                            {{with .Paused}}
+                            <td><span class="badge bg-warning text-dark">paused</span> since {{.Since}} by {{.Actor}}{{if .Reason}}: {{.Reason}}{{end}}</td>
+                            <td><form method="post" action="/admin/resume/{{.Component}}"><button class="btn btn-sm btn-success" type="submit">Resume</button></form></td>
+                            {{else}}
+                            <td><span class="badge bg-success">running</span></td>
+                            <td>
+                                <form method="post" action="/admin/pause/{{.Name}}" class="row g-2">
+                                    <div class="col-auto"><input class="form-control form-control-sm" name="reason" placeholder="reason"></div>
+                                    <div class="col-auto"><button class="btn btn-sm btn-warning" type="submit">Pause</button></div>
+                                </form>
+                            </td>
+                            {{end}}
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                <h2>Failed apps</h2>
+                <p>
+                    {{range .Failures}}{{.Class}} {{.Apps}}; {{else}}none{{end}}
+                </p>
+                <form method="post" action="/admin/requeue" class="row g-2 mb-3">
+                    <div class="col-auto"><input class="form-control" name="class" placeholder="error class, e.g. timeout"></div>
+                    <div class="col-auto"><input class="form-control" name="host" placeholder="host, e.g. github.com"></div>
+                    <div class="col-auto"><button class="btn btn-primary" type="submit">Re-queue failed apps</button></div>
+                </form>
+                <h2>Queue</h2>
+                <p>
+                    {{range .Queue}}{{.Stage}} {{.Count}} {{else}}empty{{end}}
+                </p>
+                <form method="post" action="/admin/cancel" class="mb-3">
+                    <button class="btn btn-danger" type="submit">Cancel all waiting work</button>
+                </form>
+                <h2>Recent actions</h2>
+                <table class="table">
+                    <thead>
+                        <tr>
+                            <th>At</th>
+                            <th>Who</th>
+                            <th>Action</th>
+                            <th>Package</th>
+                            <th>Filter</th>
+                            <th>Affected</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Actions}}
+                        <tr>
+                            <td>{{.At}}</td>
+                            <td>{{.Actor}}</td>
+                            <td>{{.Action}}</td>
+                            <td>{{if .Package.Valid}}<a href="/app/{{.Package.String}}">{{.Package.String}}</a>{{end}}</td>
+                            <td>{{.Filter}}</td>
+                            <td>{{.Affected}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+            </div>
+        </body>
+        </html>
+        `
+
+		data := struct {
+			Actor      string
+			Components []componentView
+			Failures   []FailureGroup
+			Queue      []db.CountQueueRow
+			Actions    []actionView
+		}{
+			Actor:      actor,
+			Components: components,
+			Failures:   groups,
+			Queue:      queue,
+			Actions:    log,
+		}
+
+		t, err := template.New("admin").Parse(tmpl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = t.Execute(w, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_adminUser(t *testing.T) {
+	saved := ADMIN_USERS
+	defer func() { ADMIN_USERS = saved }()
+	ADMIN_USERS = map[string]string{"alice": "secret"}
+
+	tests := []struct {
+		user, password string
+		ok             bool
+	}{
+		{"alice", "secret", true},
+		{"alice", "wrong", false},
+		{"bob", "secret", false},
+		{"bob", "", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/admin/cancel", nil)
+		r.SetBasicAuth(tt.user, tt.password)
+		if user, ok := adminUser(r); ok != tt.ok || ok && user != tt.user {
+			t.Errorf("adminUser(%s:%s) = %s, %v, want %v", tt.user, tt.password, user, ok, tt.ok)
+		}
+	}
+	if _, ok := adminUser(httptest.NewRequest("POST", "/admin/cancel", nil)); ok {
+		t.Error("adminUser without credentials is ok")
+	}
+}
+
+func Test_sameOrigin(t *testing.T) {
+	tests := []struct {
+		site, origin string
+		want         bool
+	}{
+		{"", "", true},
+		{"same-origin", "http://example.com", true},
+		{"cross-site", "", false},
+		{"", "http://example.com", true},
+		{"", "https://evil.example", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "http://example.com/admin/cancel", nil)
+		if tt.site != "" {
+			r.Header.Set("Sec-Fetch-Site", tt.site)
+		}
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := sameOrigin(r); got != tt.want {
+			t.Errorf("sameOrigin(%q, %q) = %v, want %v", tt.site, tt.origin, got, tt.want)
+		}
+	}
+}
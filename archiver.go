@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// ArchiveStatus is the state of one archival job as reported by a backend.
+type ArchiveStatus struct {
+	// backend-specific job identifier
+	JobID string
+	// what the job archives, as the backend names it
+	Target string
+	// backend-specific status, for logs and display
+	Status string
+	// where the backend can poll the job, if it needs that
+	StatusURL string
+	// identifier of the archived result (e.g. snapshot SWHID), empty until known
+	ResultID string
+	// the job reached a terminal state
+	Done bool
+	// the job was rejected or failed
+	Failed bool
+	// backend-specific response the status was built from
+	Detail any
+}
+
+// Archiver is an archive backend the saver pushes apps to.
+//
+// Each backend keeps its own status tables; the saver only drives the
+// probe -> submit -> poll cycle and never looks at backend specifics.
+type Archiver interface {
+	// Name identifies the backend in logs and in ARCHIVERS.
+	Name() string
+	// Target returns what this backend should archive for app, or "" to skip the app.
+	Target(app db.AppsOrdered) string
+	// Probe checks that target can be archived before anything is submitted.
+	Probe(ctx context.Context, target string) error
+	// Submit asks the backend to archive target.
+	Submit(ctx context.Context, target string) (ArchiveStatus, error)
+	// Poll refreshes the status of a submitted job.
+	Poll(ctx context.Context, st ArchiveStatus) (ArchiveStatus, error)
+	// Record persists st for pkg in the backend's own tables. pkg is empty
+	// for updates from the poller, which only refresh the job itself.
+	Record(ctx context.Context, pkg string, st ArchiveStatus) error
+}
+
+// archiverFactories builds a backend from the profile part of its ARCHIVERS
+// entry ("kind" or "kind:profile").
+var archiverFactories = map[string]func(client *http.Client, profile string) (Archiver, error){
+	"swh": newSWHArchiver,
+}
+
+// archiverDisabled is returned by a factory when the backend is not
+// configured (e.g. no credentials); it is skipped rather than failing startup.
+var archiverDisabled = errors.New("archiver not configured")
+
+// newArchivers builds the backends listed in the comma-separated ARCHIVERS
+// env (default "swh"), e.g. "swh,swh:staging".
+func newArchivers(client *http.Client) ([]Archiver, error) {
+	names := os.Getenv("ARCHIVERS")
+	if names == "" {
+		names = "swh"
+	}
+
+	var archivers []Archiver
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		kind, profile, _ := strings.Cut(name, ":")
+		factory, ok := archiverFactories[kind]
+		if !ok {
+			return nil, fmt.Errorf("unknown archiver %q", name)
+		}
+		a, err := factory(client, profile)
+		if errors.Is(err, archiverDisabled) {
+			slog.Warn("archiver not configured, skipping", "archiver", name)
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("archiver %q: %w", name, err)
+		}
+		archivers = append(archivers, a)
+	}
+	return archivers, nil
+}
+
+// RateLimited is returned by backends when the remote side throttled a call.
+// Backends are expected to wait out their own rate limit before the next
+// call goes out, so callers just retry.
+var RateLimited = errors.New("too many requests")
+
+// budgeter is implemented by backends whose API is rate limited.
+type budgeter interface {
+	Budget() RateBudget
+}
+
+var archiveFailed = errors.New("archive job failed")
+
+// HTTPError is returned by backends for an unexpected HTTP response, so the
+// status can be recorded with the attempt.
+type HTTPError struct {
+	Status int
+	Method string
+	URL    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("%s %s: %d %s", e.Method, e.URL, e.Status, http.StatusText(e.Status))
+}
+
+// statusErr is the error an attempt that got st back is recorded with.
+func statusErr(a Archiver, st ArchiveStatus) error {
+	if st.Failed {
+		return fmt.Errorf("%w: %s %s", archiveFailed, a.Name(), st.Status)
+	}
+	return nil
+}
+
+// submit submits target to a for pkg, retrying failed attempts. Throttled
+// attempts are retried without counting against them, since the backend
+// waits out its own rate limit. Every attempt is recorded.
+func submit(ctx context.Context, a Archiver, pkg, target string) (ArchiveStatus, error) {
+	var st ArchiveStatus
+	var err error
+	rateLimited := 0
+	for i := 0; i < 3; i++ {
+		started := time.Now()
+		st, err = a.Submit(ctx, target)
+		if err != nil {
+			recordAttempt(ctx, a, "submit", pkg, target, "", started, err)
+		} else {
+			recordAttempt(ctx, a, "submit", pkg, target, st.JobID, started, statusErr(a, st))
+		}
+		if err != nil {
+			if errors.Is(err, RateLimited) && rateLimited < 10 {
+				slog.Warn("submit rate limited", "archiver", a.Name(), "target", target, "err", err)
+				rateLimited++
+				i -= 1 // does not count as a failed attempt
+				continue
+			} else if errors.Is(err, context.Canceled) {
+				return st, err
+			}
+			slog.Warn("retrying submit", "archiver", a.Name(), "target", target, "err", err)
+			sleepCtx(ctx, 10*time.Second)
+			continue
+		}
+		break
+	}
+	return st, err
+}
+
+// waitDone polls a job until it is done, for backends the poller does not
+// follow.
+func waitDone(ctx context.Context, a Archiver, pkg string, st ArchiveStatus) (ArchiveStatus, error) {
+	for !st.Done {
+		sleepCtx(ctx, 10*time.Second)
+		started := time.Now()
+		newSt, err := a.Poll(ctx, st)
+		if err != nil {
+			recordAttempt(ctx, a, "poll", pkg, st.Target, st.JobID, started, err)
+			if errors.Is(err, RateLimited) {
+				slog.Warn("poll rate limited", "archiver", a.Name(), "job", st.JobID, "err", err)
+				continue
+			} else if errors.Is(err, context.Canceled) {
+				return st, err
+			}
+
+			slog.Warn("retrying poll", "archiver", a.Name(), "job", st.JobID, "err", err)
+			sleepCtx(ctx, 20*time.Second)
+			continue
+		}
+
+		st = newSt
+		recordAttempt(ctx, a, "poll", pkg, st.Target, st.JobID, started, statusErr(a, st))
+		slog.Info("poll ok", "archiver", a.Name(), "job", st.JobID, "status", st.Status)
+		if err := a.Record(ctx, pkg, st); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
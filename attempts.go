@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// ATTEMPTS_KEEP_DAYS is how long attempts are kept, 0 keeps them forever.
+var ATTEMPTS_KEEP_DAYS = 90
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("ATTEMPTS_KEEP_DAYS")); err == nil && n >= 0 {
+		ATTEMPTS_KEEP_DAYS = n
+	}
+}
+
+// classifyError sorts the error of a failed attempt into a class and returns
+// the HTTP status behind it, 0 if there was none. The class is one of
+// host_down, timeout, rate_limited, no_token, not_found, auth, client_error,
+// server_error, archive_failed, network, invalid_repo or other.
+func classifyError(err error) (string, int) {
+	status := 0
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Status
+	}
+
+	var netErr net.Error
+	var hostErr *HostUnavailableError
+	switch {
+	case errors.As(err, &hostErr):
+		return "host_down", status
+	case errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout", status
+	case errors.Is(err, RateLimited):
+		return "rate_limited", http.StatusTooManyRequests
+	case errors.Is(err, noUsableToken):
+		return "no_token", status
+	case errors.Is(err, swhNotFound) || status == http.StatusNotFound:
+		return "not_found", status
+	case status == http.StatusUnauthorized:
+		return "auth", status
+	case status >= 500:
+		return "server_error", status
+	case status >= 400:
+		return "client_error", status
+	case errors.Is(err, archiveFailed):
+		return "archive_failed", status
+	case errors.As(err, &netErr):
+		// checked after the HTTP statuses: url.Error is a net.Error too
+		return "network", status
+	case errors.Is(err, notValidGitUrl):
+		return "invalid_repo", status
+	}
+	return "other", status
+}
+
+// recordAttempt stores one probe, submit or poll of a and how it went. pkg
+// is empty for polls. Attempts cut short by shutdown are not recorded, they
+// are made again on the next run.
+func recordAttempt(ctx context.Context, a Archiver, stage, pkg, target, jobID string, started time.Time, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	params := db.CreateAttemptParams{
+		Package:    sql.NullString{String: pkg, Valid: pkg != ""},
+		Archiver:   a.Name(),
+		Stage:      stage,
+		Target:     target,
+		JobID:      sql.NullString{String: jobID, Valid: jobID != ""},
+		StartedAt:  started.UnixMilli(),
+		FinishedAt: time.Now().UnixMilli(),
+		Outcome:    "ok",
+	}
+	if err != nil {
+		class, status := classifyError(err)
+		params.Outcome = "failed"
+		params.ErrorClass = sql.NullString{String: class, Valid: true}
+		params.HttpStatus = sql.NullInt64{Int64: int64(status), Valid: status != 0}
+		params.Message = sql.NullString{String: err.Error(), Valid: true}
+	}
+	if err := dbWriteSqlc.CreateAttempt(ctx, params); err != nil {
+		slog.Error("CreateAttempt", "archiver", a.Name(), "stage", stage, "target", target, "err", err)
+	}
+}
+
+// pruneAttempts drops attempts older than ATTEMPTS_KEEP_DAYS.
+func pruneAttempts(ctx context.Context, now time.Time) {
+	if ATTEMPTS_KEEP_DAYS == 0 {
+		return
+	}
+	n, err := dbWriteSqlc.DeleteAttemptsBefore(ctx, now.AddDate(0, 0, -ATTEMPTS_KEEP_DAYS).UnixMilli())
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.Error("DeleteAttemptsBefore", "err", err)
+		}
+		return
+	}
+	if n > 0 {
+		slog.Info("old attempts pruned", "count", n)
+	}
+}
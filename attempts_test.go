@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+)
+
+func Test_classifyError(t *testing.T) {
+	httpErr := func(status int) error {
+		return fmt.Errorf("%w: %w", swhRequestFailed, &HTTPError{Status: status, Method: "POST", URL: "https://example.org/"})
+	}
+	tests := []struct {
+		err    error
+		class  string
+		status int
+	}{
+		{fmt.Errorf("%w: %w: %w", swhRequestFailed, swhNotFound, &HTTPError{Status: 404}), "not_found", 404},
+		{httpErr(401), "auth", 401},
+		{httpErr(403), "client_error", 403},
+		{httpErr(400), "client_error", 400},
+		{httpErr(502), "server_error", 502},
+		{RateLimited, "rate_limited", 429},
+		{noUsableToken, "no_token", 0},
+		{fmt.Errorf("%w: swh failed", archiveFailed), "archive_failed", 0},
+		{&url.Error{Op: "Get", URL: "https://example.org/", Err: context.DeadlineExceeded}, "timeout", 0},
+		{errors.Join(&url.Error{Op: "Get", URL: "https://example.org/", Err: errors.New("connection refused")}, notValidGitUrl), "network", 0},
+		{errors.Join(errors.New("Content-Type is not x-git-upload-pack-advertisement"), notValidGitUrl), "invalid_repo", 0},
+		{&url.Error{Op: "Get", URL: "https://example.org/", Err: &HostUnavailableError{Host: "example.org"}}, "host_down", 0},
+		{errors.New("boom"), "other", 0},
+	}
+	for _, tt := range tests {
+		class, status := classifyError(tt.err)
+		if class != tt.class || status != tt.status {
+			t.Errorf("classifyError(%v) = %s, %d, want %s, %d", tt.err, class, status, tt.class, tt.status)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// backfiller is implemented by archivers that can import what was archived
+// before this database existed.
+type backfiller interface {
+	// Backfill records the archive's history of origin for the apps built
+	// from it and returns when it was last archived or submitted, zero if
+	// never.
+	Backfill(ctx context.Context, origin string, packages []string) (time.Time, error)
+}
+
+type visitResp struct {
+	Date   string `json:"date"`
+	Status string `json:"status"`
+}
+
+// Backfill imports the save requests SWH has for origin, whoever made them,
+// and looks up its latest visit: SWH's own listers visit most forges without
+// anyone asking. The older Python tool pushed origins without the trailing
+// slash, so both spellings are looked up, and recorded as swhOriginURL has
+// it.
+func (a *swhArchiver) Backfill(ctx context.Context, origin string, packages []string) (time.Time, error) {
+	origins := []string{origin, strings.TrimSuffix(origin, "/")}
+
+	var last time.Time
+	var lastTaskID int64
+	for _, origin := range origins {
+		var reqs []TaskResp
+		err := a.swh.call(ctx, "GET", a.swh.api+"origin/save/git/url/"+origin, nil, &reqs)
+		if err != nil && !errors.Is(err, swhNotFound) {
+			return time.Time{}, err
+		}
+		for _, req := range reqs {
+			params := taskRespParams(a.instance, req, time.Now())
+			params.OriginUrl = swhOriginURL(req.OriginUrl)
+			requested, err := time.Parse(time.RFC3339Nano, req.SaveRequestDate)
+			if err == nil {
+				// not when we saw it finish, which we don't know
+				params.CreatedAt = requested.UnixMilli()
+				params.FinishedAt = sql.NullInt64{}
+			}
+			if err := dbWriteSqlc.CreateOrUpdateTask(ctx, params); err != nil {
+				return time.Time{}, err
+			}
+			lastTaskID = max(lastTaskID, int64(req.ID))
+			if req.SaveRequestStatus == "accepted" && req.SaveTaskStatus != "failed" && requested.After(last) {
+				last = requested
+			}
+		}
+
+		var visit visitResp
+		err = a.swh.call(ctx, "GET", a.swh.api+"origin/"+origin+"/visit/latest/?require_snapshot=true", nil, &visit)
+		if errors.Is(err, swhNotFound) {
+			continue
+		}
+		if err != nil {
+			return time.Time{}, err
+		}
+		visited, err := time.Parse(time.RFC3339Nano, visit.Date)
+		if err == nil && (visit.Status == "full" || visit.Status == "partial") && visited.After(last) {
+			last = visited
+		}
+	}
+
+	if lastTaskID == 0 {
+		return last, nil
+	}
+	for _, pkg := range packages {
+		current, err := dbWriteSqlc.GetAppTask(ctx, db.GetAppTaskParams{
+			Package:  pkg,
+			Instance: a.instance,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return last, err
+		}
+		if err == nil && current.ID >= lastTaskID {
+			continue
+		}
+		if err := dbWriteSqlc.UpdateLastTaskId(ctx, db.UpdateLastTaskIdParams{
+			Package:    pkg,
+			Instance:   a.instance,
+			LastTaskID: lastTaskID,
+		}); err != nil {
+			return last, err
+		}
+	}
+	return last, nil
+}
+
+// backfill imports the archive history of the origins of the given packages,
+// or of every app, once per origin, and moves last_save_triggered of the
+// apps built from it up to the latest save or visit found so the scheduler
+// does not push what is already archived. Origins that fail are logged and
+// skipped; running it again picks them up.
+func backfill(ctx context.Context, archivers []Archiver, packages []string) error {
+	const pageSize = 500
+
+	var backfillers []backfiller
+	for _, a := range archivers {
+		if b, ok := a.(backfiller); ok {
+			backfillers = append(backfillers, b)
+		}
+	}
+	if len(backfillers) == 0 {
+		return errors.New("no archiver can backfill")
+	}
+
+	done, failed := 0, 0
+	seen := map[string]bool{}
+	backfillApp := func(app db.AppsOrdered) error {
+		if !app.OriginUrl.Valid || seen[app.OriginUrl.String] {
+			return nil
+		}
+		origin := app.OriginUrl.String
+		seen[origin] = true
+		pkgs, err := dbWriteSqlc.GetOriginPackages(ctx, app.Package)
+		if err != nil {
+			return err
+		}
+
+		var last time.Time
+		for _, b := range backfillers {
+			t, err := b.Backfill(ctx, origin, pkgs)
+			if errors.Is(err, context.Canceled) {
+				return err
+			}
+			if err != nil {
+				slog.Warn("backfill failed", "origin", origin, "err", err)
+				failed++
+				return nil
+			}
+			if t.After(last) {
+				last = t
+			}
+		}
+		if !last.IsZero() {
+			for _, pkg := range pkgs {
+				if err := dbWriteSqlc.BackfillLastSaveTriggered(ctx, db.BackfillLastSaveTriggeredParams{
+					LastSaveTriggered: last.UnixMilli(),
+					Package:           pkg,
+				}); err != nil {
+					return err
+				}
+			}
+		}
+		done++
+		if done%100 == 0 {
+			slog.Info("backfill progress", "origins", done, "failed", failed)
+		}
+		return nil
+	}
+
+	if len(packages) > 0 {
+		for _, pkg := range packages {
+			app, err := dbWriteSqlc.GetApp(ctx, pkg)
+			if err != nil {
+				return err
+			}
+			if err := backfillApp(db.AppsOrdered(app)); err != nil {
+				return err
+			}
+		}
+	} else {
+		for offset := int64(0); ; offset += pageSize {
+			apps, err := dbWriteSqlc.GetAllApps(ctx, db.GetAllAppsParams{
+				Package: "%",
+				Limit:   pageSize,
+				Offset:  offset,
+			})
+			if err != nil {
+				return err
+			}
+			for _, app := range apps {
+				if err := backfillApp(app); err != nil {
+					return err
+				}
+			}
+			if len(apps) < pageSize {
+				break
+			}
+		}
+	}
+	slog.Info("backfill done", "origins", done, "failed", failed)
+	return nil
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// REARCHIVE_DAYS is how old an app's last snapshot may get before the app is
+// saved again, whether or not F-Droid updated it; 0 turns re-archiving off.
+var REARCHIVE_DAYS = 90
+
+// REARCHIVE_HOSTS, REARCHIVE_CATEGORIES and REARCHIVE_APPS override
+// REARCHIVE_DAYS per source code host, F-Droid category and package, as
+// comma-separated lists of name=days, e.g. "github.com=30,codeberg.org=60".
+// A package's own cadence wins; otherwise the shortest of its host's and
+// categories' applies.
+var (
+	REARCHIVE_HOSTS      = map[string]int{}
+	REARCHIVE_CATEGORIES = map[string]int{}
+	REARCHIVE_APPS       = map[string]int{}
+)
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("REARCHIVE_DAYS")); err == nil && n >= 0 {
+		REARCHIVE_DAYS = n
+	}
+	REARCHIVE_HOSTS = parseDays("REARCHIVE_HOSTS")
+	REARCHIVE_CATEGORIES = parseDays("REARCHIVE_CATEGORIES")
+	REARCHIVE_APPS = parseDays("REARCHIVE_APPS")
+}
+
+// parseDays reads a name=days list from env.
+func parseDays(env string) map[string]int {
+	days := map[string]int{}
+	for _, item := range strings.Split(os.Getenv(env), ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(item, "=")
+		n, err := strconv.Atoi(value)
+		if name == "" || err != nil || n < 0 {
+			slog.Warn("ignoring re-archiving cadence", "env", env, "item", item)
+			continue
+		}
+		days[name] = n
+	}
+	return days
+}
+
+// cadenceOf returns how often an app is re-archived, 0 for never, and
+// which setting that comes from.
+func cadenceOf(pkg, sourceCode, categories string) (time.Duration, string) {
+	if n, ok := REARCHIVE_APPS[pkg]; ok {
+		return time.Duration(n) * 24 * time.Hour, "app"
+	}
+	best, from := -1, ""
+	if u, err := url.Parse(sourceCode); err == nil {
+		if n, ok := REARCHIVE_HOSTS[u.Hostname()]; ok {
+			best, from = n, "host "+u.Hostname()
+		}
+	}
+	for _, c := range strings.Split(categories, ",") {
+		if n, ok := REARCHIVE_CATEGORIES[c]; ok && (best < 0 || n < best) {
+			best, from = n, "category "+c
+		}
+	}
+	if best < 0 {
+		return time.Duration(REARCHIVE_DAYS) * 24 * time.Hour, "default"
+	}
+	return time.Duration(best) * 24 * time.Hour, from
+}
+
+// minCadence is the shortest cadence any app can have, 0 if none is
+// re-archived.
+func minCadence() time.Duration {
+	shortest := REARCHIVE_DAYS
+	for _, m := range []map[string]int{REARCHIVE_HOSTS, REARCHIVE_CATEGORIES, REARCHIVE_APPS} {
+		for _, n := range m {
+			if n > 0 && (shortest == 0 || n < shortest) {
+				shortest = n
+			}
+		}
+	}
+	return time.Duration(shortest) * 24 * time.Hour
+}
+
+// saveDue tells whether an app needs a save now: F-Droid updated it since
+// the last one, a failed save is due for a retry, or its last snapshot and
+// save are older than its cadence.
+func saveDue(app db.GetSaveCandidatesRow, now time.Time) bool {
+	nowMs := now.UnixMilli()
+	if app.NextAttemptAt > nowMs {
+		return false
+	}
+	if app.MetaLastUpdated > app.LastSaveTriggered || app.NextAttemptAt > 0 && !app.GaveUpAt.Valid {
+		return true
+	}
+	if app.GaveUpAt.Valid {
+		return false
+	}
+	cadence, _ := cadenceOf(app.Package, app.MetaSourceCode, app.Categories)
+	if cadence == 0 {
+		return false
+	}
+	cutoff := now.Add(-cadence).UnixMilli()
+	lastSnapshot := max(app.LastSnapshotAt, app.LastVisitAt*1000)
+	return lastSnapshot < cutoff && app.LastSaveTriggered < cutoff
+}
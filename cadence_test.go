@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+func Test_saveDue(t *testing.T) {
+	REARCHIVE_DAYS = 90
+	REARCHIVE_HOSTS = map[string]int{"github.com": 30}
+	REARCHIVE_CATEGORIES = map[string]int{"Internet": 14, "Games": 60}
+	REARCHIVE_APPS = map[string]int{"org.frozen": 0}
+	defer func() {
+		REARCHIVE_HOSTS, REARCHIVE_CATEGORIES, REARCHIVE_APPS = map[string]int{}, map[string]int{}, map[string]int{}
+	}()
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	daysAgo := func(n int) int64 { return now.AddDate(0, 0, -n).UnixMilli() }
+	app := func(pkg, source, categories string, snapshotDays int) db.GetSaveCandidatesRow {
+		return db.GetSaveCandidatesRow{
+			Package:           pkg,
+			MetaSourceCode:    source,
+			Categories:        categories,
+			MetaLastUpdated:   daysAgo(400),
+			LastSaveTriggered: daysAgo(snapshotDays),
+			LastSnapshotAt:    daysAgo(snapshotDays),
+		}
+	}
+
+	tests := []struct {
+		name string
+		app  db.GetSaveCandidatesRow
+		want bool
+	}{
+		{"default fresh", app("a", "https://codeberg.org/a/a", "", 60), false},
+		{"default stale", app("a", "https://codeberg.org/a/a", "", 100), true},
+		{"host", app("a", "https://github.com/a/a", "", 40), true},
+		{"shortest category", app("a", "https://github.com/a/a", "Games,Internet", 20), true},
+		{"off for the app", app("org.frozen", "https://github.com/a/a", "Internet", 400), false},
+	}
+	for _, tt := range tests {
+		if got := saveDue(tt.app, now); got != tt.want {
+			t.Errorf("%s: saveDue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+
+	saved := app("a", "https://codeberg.org/a/a", "", 100)
+	saved.LastSaveTriggered = daysAgo(1)
+	if saveDue(saved, now) {
+		t.Error("saved yesterday: due again")
+	}
+	gaveUp := app("a", "https://codeberg.org/a/a", "", 100)
+	gaveUp.GaveUpAt = sql.NullInt64{Int64: daysAgo(100), Valid: true}
+	if saveDue(gaveUp, now) {
+		t.Error("gave up: due")
+	}
+	updated := app("a", "https://codeberg.org/a/a", "", 10)
+	updated.MetaLastUpdated = daysAgo(1)
+	if !saveDue(updated, now) {
+		t.Error("updated: not due")
+	}
+
+	if got := minCadence(); got != 14*24*time.Hour {
+		t.Errorf("minCadence = %v", got)
+	}
+}
@@ -8,13 +8,36 @@ import (
 	"database/sql"
 )
 
+type AdminAction struct {
+	ID        int64
+	Actor     string
+	Action    string
+	Package   sql.NullString
+	Filter    string
+	Affected  int64
+	CreatedAt int64
+}
+
 type App struct {
 	Package           string
 	MetaAdded         int64
 	MetaLastUpdated   int64
 	MetaSourceCode    string
 	LastSaveTriggered int64
-	LastTaskID        sql.NullInt64
+	SaveFailures      int64
+	NextAttemptAt     int64
+	LastErrorClass    sql.NullString
+	GaveUpAt          sql.NullInt64
+	ListedAt          int64
+	DelistedAt        sql.NullInt64
+	Categories        string
+	OriginUrl         sql.NullString
+}
+
+type AppTask struct {
+	Package    string
+	Instance   string
+	LastTaskID int64
 }
 
 type AppsOrdered struct {
@@ -23,12 +46,202 @@ type AppsOrdered struct {
 	MetaLastUpdated   int64
 	MetaSourceCode    string
 	LastSaveTriggered int64
-	LastTaskID        sql.NullInt64
+	SaveFailures      int64
+	NextAttemptAt     int64
+	LastErrorClass    sql.NullString
+	GaveUpAt          sql.NullInt64
+	ListedAt          int64
+	DelistedAt        sql.NullInt64
+	Categories        string
+	OriginUrl         sql.NullString
+}
+
+type Attempt struct {
+	ID         int64
+	Package    sql.NullString
+	Archiver   string
+	Stage      string
+	Target     string
+	JobID      sql.NullString
+	StartedAt  int64
+	FinishedAt int64
+	Outcome    string
+	ErrorClass sql.NullString
+	HttpStatus sql.NullInt64
+	Message    sql.NullString
+}
+
+type KnownSwhid struct {
+	Instance  string
+	Swhid     string
+	Known     bool
+	CheckedAt int64
+}
+
+type MirrorBlob struct {
+	Sha256     string
+	Size       int64
+	StoredAt   int64
+	VerifiedAt int64
+}
+
+type Origin struct {
+	Url           string
+	FirstSeenAt   int64
+	RefsCheckedAt int64
+}
+
+type OriginVisit struct {
+	Instance      string
+	OriginUrl     string
+	Visit         int64
+	Date          string
+	Type          string
+	Status        string
+	SnapshotSwhid sql.NullString
+}
+
+type Pause struct {
+	Component string
+	Actor     string
+	Reason    string
+	PausedAt  int64
+}
+
+type Queue struct {
+	Package    string
+	Archiver   string
+	Stage      string
+	Target     string
+	EnqueuedAt int64
+	ClaimedAt  int64
+}
+
+type RefAlert struct {
+	ID         int64
+	Package    string
+	OriginUrl  string
+	Kind       string
+	Ref        string
+	Before     string
+	After      sql.NullString
+	Release    sql.NullString
+	DetectedAt int64
+}
+
+type RefMove struct {
+	OriginUrl string
+	Ref       string
+	Before    string
+	After     string
+	SeenAt    int64
+}
+
+type RestoreTest struct {
+	ID          int64
+	Instance    string
+	Package     string
+	Swhid       string
+	Status      string
+	RequestedAt int64
+	CheckedAt   sql.NullInt64
+	Bytes       sql.NullInt64
+	Error       sql.NullString
+}
+
+type SnapshotCheck struct {
+	Instance      string
+	TaskID        int64
+	OriginUrl     string
+	SnapshotSwhid string
+	HeadSwhid     sql.NullString
+	Branches      int64
+	Tags          int64
+	Missing       string
+	Complete      bool
+	Requeues      int64
+	CheckedAt     int64
 }
 
 type Task struct {
+	Instance          string
 	ID                int64
 	SaveRequestStatus string
 	SaveTaskStatus    string
 	SnapshotSwhid     sql.NullString
+	OriginUrl         string
+	VisitType         string
+	SaveRequestDate   string
+	VisitDate         sql.NullString
+	VisitStatus       sql.NullString
+	LoadingTaskID     sql.NullInt64
+	Note              sql.NullString
+	RequestUrl        string
+	Raw               string
+	CreatedAt         int64
+	UpdatedAt         int64
+	FinishedAt        sql.NullInt64
+	NextPollAt        int64
+	PollFailures      int64
+	AbandonedAt       sql.NullInt64
+	AbandonReason     sql.NullString
+}
+
+type TaskRef struct {
+	Instance string
+	TaskID   int64
+	Name     string
+	Target   string
+}
+
+type UpstreamRef struct {
+	OriginUrl string
+	Name      string
+	Target    string
+	SeenAt    int64
+}
+
+type Version struct {
+	Package       string
+	VersionCode   int64
+	VersionName   string
+	Added         int64
+	FileName      string
+	FileSha256    string
+	SrcName       sql.NullString
+	SrcSha256     sql.NullString
+	Tag           sql.NullString
+	RevisionSwhid sql.NullString
+	MappedAt      int64
+	SrcCntSwhid   sql.NullString
+	SrcDirSwhid   sql.NullString
+	SrcHashedAt   int64
+	SrcError      sql.NullString
+	ApkMirrored   bool
+	SrcMirrored   bool
+	MirroredAt    int64
+	MirrorError   sql.NullString
+}
+
+type VersionSaveRetry struct {
+	Package        string
+	VersionCode    int64
+	Instance       string
+	Failures       int64
+	NextAttemptAt  int64
+	LastErrorClass sql.NullString
+	GaveUpAt       sql.NullInt64
+}
+
+type VersionTask struct {
+	Package     string
+	VersionCode int64
+	Instance    string
+	LastTaskID  int64
+}
+
+type VisitFetch struct {
+	Instance  string
+	OriginUrl string
+	FetchedAt int64
 }
@@ -10,6 +10,271 @@ import (
 	"database/sql"
 )
 
+const abandonTask = `-- name: AbandonTask :exec
+UPDATE tasks SET abandoned_at = ?, abandon_reason = ?, poll_failures = ?
+WHERE instance = ? AND id = ?
+`
+
+type AbandonTaskParams struct {
+	AbandonedAt   sql.NullInt64
+	AbandonReason sql.NullString
+	PollFailures  int64
+	Instance      string
+	ID            int64
+}
+
+func (q *Queries) AbandonTask(ctx context.Context, arg AbandonTaskParams) error {
+	_, err := q.db.ExecContext(ctx, abandonTask,
+		arg.AbandonedAt,
+		arg.AbandonReason,
+		arg.PollFailures,
+		arg.Instance,
+		arg.ID,
+	)
+	return err
+}
+
+const advanceQueueItem = `-- name: AdvanceQueueItem :exec
+UPDATE queue SET stage = ?, claimed_at = 0
+WHERE package = ? AND archiver = ?
+`
+
+type AdvanceQueueItemParams struct {
+	Stage    string
+	Package  string
+	Archiver string
+}
+
+func (q *Queries) AdvanceQueueItem(ctx context.Context, arg AdvanceQueueItemParams) error {
+	_, err := q.db.ExecContext(ctx, advanceQueueItem, arg.Stage, arg.Package, arg.Archiver)
+	return err
+}
+
+const backfillLastSaveTriggered = `-- name: BackfillLastSaveTriggered :exec
+UPDATE apps SET last_save_triggered = MAX(last_save_triggered, ?)
+WHERE package = ?
+`
+
+type BackfillLastSaveTriggeredParams struct {
+	LastSaveTriggered interface{}
+	Package           string
+}
+
+func (q *Queries) BackfillLastSaveTriggered(ctx context.Context, arg BackfillLastSaveTriggeredParams) error {
+	_, err := q.db.ExecContext(ctx, backfillLastSaveTriggered, arg.LastSaveTriggered, arg.Package)
+	return err
+}
+
+const cancelAppQueue = `-- name: CancelAppQueue :execrows
+DELETE FROM queue
+WHERE package = ? AND claimed_at = 0
+`
+
+func (q *Queries) CancelAppQueue(ctx context.Context, package_ string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelAppQueue, package_)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const cancelQueue = `-- name: CancelQueue :execrows
+DELETE FROM queue
+WHERE claimed_at = 0
+`
+
+func (q *Queries) CancelQueue(ctx context.Context) (int64, error) {
+	result, err := q.db.ExecContext(ctx, cancelQueue)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const claimQueueItem = `-- name: ClaimQueueItem :one
+UPDATE queue SET claimed_at = ?
+WHERE rowid = (
+    SELECT rowid FROM queue
+    WHERE stage = ? AND claimed_at = 0
+    ORDER BY enqueued_at LIMIT 1
+)
+RETURNING package, archiver, stage, target, enqueued_at, claimed_at
+`
+
+type ClaimQueueItemParams struct {
+	ClaimedAt int64
+	Stage     string
+}
+
+func (q *Queries) ClaimQueueItem(ctx context.Context, arg ClaimQueueItemParams) (Queue, error) {
+	row := q.db.QueryRowContext(ctx, claimQueueItem, arg.ClaimedAt, arg.Stage)
+	var i Queue
+	err := row.Scan(
+		&i.Package,
+		&i.Archiver,
+		&i.Stage,
+		&i.Target,
+		&i.EnqueuedAt,
+		&i.ClaimedAt,
+	)
+	return i, err
+}
+
+const countFailedAttempts = `-- name: CountFailedAttempts :many
+SELECT stage, error_class, COUNT(*) AS count FROM attempts
+WHERE outcome = 'failed' AND started_at > ?
+GROUP BY stage, error_class
+ORDER BY count DESC
+`
+
+type CountFailedAttemptsRow struct {
+	Stage      string
+	ErrorClass sql.NullString
+	Count      int64
+}
+
+func (q *Queries) CountFailedAttempts(ctx context.Context, startedAt int64) ([]CountFailedAttemptsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countFailedAttempts, startedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountFailedAttemptsRow
+	for rows.Next() {
+		var i CountFailedAttemptsRow
+		if err := rows.Scan(
+			&i.Stage,
+			&i.ErrorClass,
+			&i.Count,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countQueue = `-- name: CountQueue :many
+SELECT stage, COUNT(*) AS count FROM queue
+GROUP BY stage
+`
+
+type CountQueueRow struct {
+	Stage string
+	Count int64
+}
+
+func (q *Queries) CountQueue(ctx context.Context) ([]CountQueueRow, error) {
+	rows, err := q.db.QueryContext(ctx, countQueue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountQueueRow
+	for rows.Next() {
+		var i CountQueueRow
+		if err := rows.Scan(
+			&i.Stage,
+			&i.Count,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countRefAlerts = `-- name: CountRefAlerts :many
+SELECT package, COUNT(*) AS alerts FROM ref_alerts
+GROUP BY package
+`
+
+type CountRefAlertsRow struct {
+	Package string
+	Alerts  int64
+}
+
+func (q *Queries) CountRefAlerts(ctx context.Context) ([]CountRefAlertsRow, error) {
+	rows, err := q.db.QueryContext(ctx, countRefAlerts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CountRefAlertsRow
+	for rows.Next() {
+		var i CountRefAlertsRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.Alerts,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const countRestoreTestsSince = `-- name: CountRestoreTestsSince :one
+SELECT COUNT(*) FROM restore_tests
+WHERE instance = ? AND requested_at > ?
+`
+
+type CountRestoreTestsSinceParams struct {
+	Instance    string
+	RequestedAt int64
+}
+
+func (q *Queries) CountRestoreTestsSince(ctx context.Context, arg CountRestoreTestsSinceParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countRestoreTestsSince, arg.Instance, arg.RequestedAt)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const createAdminAction = `-- name: CreateAdminAction :exec
+INSERT INTO admin_actions (actor, action, package, filter, affected, created_at)
+VALUES (?, ?, ?, ?, ?, ?)
+`
+
+type CreateAdminActionParams struct {
+	Actor     string
+	Action    string
+	Package   sql.NullString
+	Filter    string
+	Affected  int64
+	CreatedAt int64
+}
+
+func (q *Queries) CreateAdminAction(ctx context.Context, arg CreateAdminActionParams) error {
+	_, err := q.db.ExecContext(ctx, createAdminAction,
+		arg.Actor,
+		arg.Action,
+		arg.Package,
+		arg.Filter,
+		arg.Affected,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const createApp = `-- name: CreateApp :exec
 INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code) VALUES (?, ?, ?, ?)
 `
@@ -31,13 +296,78 @@ func (q *Queries) CreateApp(ctx context.Context, arg CreateAppParams) error {
 	return err
 }
 
+const createAttempt = `-- name: CreateAttempt :exec
+INSERT INTO attempts (package, archiver, stage, target, job_id, started_at, finished_at, outcome, error_class, http_status, message)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateAttemptParams struct {
+	Package    sql.NullString
+	Archiver   string
+	Stage      string
+	Target     string
+	JobID      sql.NullString
+	StartedAt  int64
+	FinishedAt int64
+	Outcome    string
+	ErrorClass sql.NullString
+	HttpStatus sql.NullInt64
+	Message    sql.NullString
+}
+
+func (q *Queries) CreateAttempt(ctx context.Context, arg CreateAttemptParams) error {
+	_, err := q.db.ExecContext(ctx, createAttempt,
+		arg.Package,
+		arg.Archiver,
+		arg.Stage,
+		arg.Target,
+		arg.JobID,
+		arg.StartedAt,
+		arg.FinishedAt,
+		arg.Outcome,
+		arg.ErrorClass,
+		arg.HttpStatus,
+		arg.Message,
+	)
+	return err
+}
+
+const createMirrorBlob = `-- name: CreateMirrorBlob :exec
+INSERT INTO mirror_blobs (sha256, size, stored_at, verified_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (sha256) DO UPDATE SET size = excluded.size, verified_at = excluded.verified_at
+`
+
+type CreateMirrorBlobParams struct {
+	Sha256     string
+	Size       int64
+	StoredAt   int64
+	VerifiedAt int64
+}
+
+func (q *Queries) CreateMirrorBlob(ctx context.Context, arg CreateMirrorBlobParams) error {
+	_, err := q.db.ExecContext(ctx, createMirrorBlob,
+		arg.Sha256,
+		arg.Size,
+		arg.StoredAt,
+		arg.VerifiedAt,
+	)
+	return err
+}
+
 const createOrUpdateApp = `-- name: CreateOrUpdateApp :exec
-INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code)
-VALUES (?, ?, ?, ?)
+INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code, categories, listed_at, origin_url)
+VALUES (?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(package) DO UPDATE SET
     meta_added = excluded.meta_added,
     meta_last_updated = excluded.meta_last_updated,
-    meta_source_code = excluded.meta_source_code
+    meta_source_code = excluded.meta_source_code,
+    categories = excluded.categories,
+    origin_url = excluded.origin_url,
+    listed_at = excluded.listed_at,
+    delisted_at = NULL,
+    -- an update may have fixed what kept failing
+    save_failures = CASE WHEN excluded.meta_last_updated > apps.meta_last_updated THEN 0 ELSE apps.save_failures END,
+    gave_up_at = CASE WHEN excluded.meta_last_updated > apps.meta_last_updated THEN NULL ELSE apps.gave_up_at END
 `
 
 type CreateOrUpdateAppParams struct {
@@ -45,6 +375,9 @@ type CreateOrUpdateAppParams struct {
 	MetaAdded       int64
 	MetaLastUpdated int64
 	MetaSourceCode  string
+	Categories      string
+	ListedAt        int64
+	OriginUrl       sql.NullString
 }
 
 func (q *Queries) CreateOrUpdateApp(ctx context.Context, arg CreateOrUpdateAppParams) error {
@@ -53,74 +386,2604 @@ func (q *Queries) CreateOrUpdateApp(ctx context.Context, arg CreateOrUpdateAppPa
 		arg.MetaAdded,
 		arg.MetaLastUpdated,
 		arg.MetaSourceCode,
+		arg.Categories,
+		arg.ListedAt,
+		arg.OriginUrl,
 	)
 	return err
 }
 
-const createOrUpdateTask = `-- name: CreateOrUpdateTask :exec
-INSERT INTO tasks (id, save_request_status, save_task_status, snapshot_swhid)
+const createOrUpdateKnownSwhid = `-- name: CreateOrUpdateKnownSwhid :exec
+INSERT INTO known_swhids (instance, swhid, known, checked_at)
 VALUES (?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+ON CONFLICT(instance, swhid) DO UPDATE SET
+    known = excluded.known,
+    checked_at = excluded.checked_at
+`
+
+type CreateOrUpdateKnownSwhidParams struct {
+	Instance  string
+	Swhid     string
+	Known     bool
+	CheckedAt int64
+}
+
+func (q *Queries) CreateOrUpdateKnownSwhid(ctx context.Context, arg CreateOrUpdateKnownSwhidParams) error {
+	_, err := q.db.ExecContext(ctx, createOrUpdateKnownSwhid,
+		arg.Instance,
+		arg.Swhid,
+		arg.Known,
+		arg.CheckedAt,
+	)
+	return err
+}
+
+const createOrUpdateOriginVisit = `-- name: CreateOrUpdateOriginVisit :exec
+INSERT INTO origin_visits (instance, origin_url, visit, date, type, status, snapshot_swhid)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (instance, origin_url, visit) DO UPDATE SET
+    date = excluded.date,
+    type = excluded.type,
+    status = excluded.status,
+    snapshot_swhid = excluded.snapshot_swhid
+`
+
+type CreateOrUpdateOriginVisitParams struct {
+	Instance      string
+	OriginUrl     string
+	Visit         int64
+	Date          string
+	Type          string
+	Status        string
+	SnapshotSwhid sql.NullString
+}
+
+func (q *Queries) CreateOrUpdateOriginVisit(ctx context.Context, arg CreateOrUpdateOriginVisitParams) error {
+	_, err := q.db.ExecContext(ctx, createOrUpdateOriginVisit,
+		arg.Instance,
+		arg.OriginUrl,
+		arg.Visit,
+		arg.Date,
+		arg.Type,
+		arg.Status,
+		arg.SnapshotSwhid,
+	)
+	return err
+}
+
+const createOrUpdateRefMove = `-- name: CreateOrUpdateRefMove :exec
+INSERT INTO ref_moves (origin_url, ref, before, after, seen_at) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (origin_url, ref) DO UPDATE SET after = excluded.after, seen_at = excluded.seen_at
+`
+
+type CreateOrUpdateRefMoveParams struct {
+	OriginUrl string
+	Ref       string
+	Before    string
+	After     string
+	SeenAt    int64
+}
+
+func (q *Queries) CreateOrUpdateRefMove(ctx context.Context, arg CreateOrUpdateRefMoveParams) error {
+	_, err := q.db.ExecContext(ctx, createOrUpdateRefMove,
+		arg.OriginUrl,
+		arg.Ref,
+		arg.Before,
+		arg.After,
+		arg.SeenAt,
+	)
+	return err
+}
+
+const createOrUpdateTask = `-- name: CreateOrUpdateTask :exec
+INSERT INTO tasks (
+    instance, id, save_request_status, save_task_status, snapshot_swhid,
+    origin_url, visit_type, save_request_date, visit_date, visit_status,
+    loading_task_id, note, request_url, raw, created_at, updated_at, finished_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(instance, id) DO UPDATE SET
     save_request_status = excluded.save_request_status,
     save_task_status = excluded.save_task_status,
-    snapshot_swhid = excluded.snapshot_swhid
+    snapshot_swhid = excluded.snapshot_swhid,
+    origin_url = excluded.origin_url,
+    visit_type = excluded.visit_type,
+    save_request_date = excluded.save_request_date,
+    visit_date = excluded.visit_date,
+    visit_status = excluded.visit_status,
+    loading_task_id = excluded.loading_task_id,
+    note = excluded.note,
+    request_url = excluded.request_url,
+    raw = excluded.raw,
+    updated_at = excluded.updated_at,
+    finished_at = COALESCE(tasks.finished_at, excluded.finished_at)
+`
+
+type CreateOrUpdateTaskParams struct {
+	Instance          string
+	ID                int64
+	SaveRequestStatus string
+	SaveTaskStatus    string
+	SnapshotSwhid     sql.NullString
+	OriginUrl         string
+	VisitType         string
+	SaveRequestDate   string
+	VisitDate         sql.NullString
+	VisitStatus       sql.NullString
+	LoadingTaskID     sql.NullInt64
+	Note              sql.NullString
+	RequestUrl        string
+	Raw               string
+	CreatedAt         int64
+	UpdatedAt         int64
+	FinishedAt        sql.NullInt64
+}
+
+func (q *Queries) CreateOrUpdateTask(ctx context.Context, arg CreateOrUpdateTaskParams) error {
+	_, err := q.db.ExecContext(ctx, createOrUpdateTask,
+		arg.Instance,
+		arg.ID,
+		arg.SaveRequestStatus,
+		arg.SaveTaskStatus,
+		arg.SnapshotSwhid,
+		arg.OriginUrl,
+		arg.VisitType,
+		arg.SaveRequestDate,
+		arg.VisitDate,
+		arg.VisitStatus,
+		arg.LoadingTaskID,
+		arg.Note,
+		arg.RequestUrl,
+		arg.Raw,
+		arg.CreatedAt,
+		arg.UpdatedAt,
+		arg.FinishedAt,
+	)
+	return err
+}
+
+const createOrUpdateVersion = `-- name: CreateOrUpdateVersion :exec
+INSERT INTO versions (package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(package, version_code) DO UPDATE SET
+    version_name = excluded.version_name,
+    added = excluded.added,
+    file_name = excluded.file_name,
+    file_sha256 = excluded.file_sha256,
+    src_name = excluded.src_name,
+    src_sha256 = excluded.src_sha256
+`
+
+type CreateOrUpdateVersionParams struct {
+	Package     string
+	VersionCode int64
+	VersionName string
+	Added       int64
+	FileName    string
+	FileSha256  string
+	SrcName     sql.NullString
+	SrcSha256   sql.NullString
+}
+
+func (q *Queries) CreateOrUpdateVersion(ctx context.Context, arg CreateOrUpdateVersionParams) error {
+	_, err := q.db.ExecContext(ctx, createOrUpdateVersion,
+		arg.Package,
+		arg.VersionCode,
+		arg.VersionName,
+		arg.Added,
+		arg.FileName,
+		arg.FileSha256,
+		arg.SrcName,
+		arg.SrcSha256,
+	)
+	return err
+}
+
+const createOrigin = `-- name: CreateOrigin :exec
+INSERT INTO origins (url, first_seen_at) VALUES (?, ?)
+ON CONFLICT(url) DO NOTHING
+`
+
+type CreateOriginParams struct {
+	Url         string
+	FirstSeenAt int64
+}
+
+func (q *Queries) CreateOrigin(ctx context.Context, arg CreateOriginParams) error {
+	_, err := q.db.ExecContext(ctx, createOrigin, arg.Url, arg.FirstSeenAt)
+	return err
+}
+
+const createRefAlert = `-- name: CreateRefAlert :exec
+INSERT INTO ref_alerts (package, origin_url, kind, ref, before, after, release, detected_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateRefAlertParams struct {
+	Package    string
+	OriginUrl  string
+	Kind       string
+	Ref        string
+	Before     string
+	After      sql.NullString
+	Release    sql.NullString
+	DetectedAt int64
+}
+
+func (q *Queries) CreateRefAlert(ctx context.Context, arg CreateRefAlertParams) error {
+	_, err := q.db.ExecContext(ctx, createRefAlert,
+		arg.Package,
+		arg.OriginUrl,
+		arg.Kind,
+		arg.Ref,
+		arg.Before,
+		arg.After,
+		arg.Release,
+		arg.DetectedAt,
+	)
+	return err
+}
+
+const createRestoreTest = `-- name: CreateRestoreTest :exec
+INSERT INTO restore_tests (instance, package, swhid, status, requested_at)
+VALUES (?, ?, ?, 'pending', ?)
+`
+
+type CreateRestoreTestParams struct {
+	Instance    string
+	Package     string
+	Swhid       string
+	RequestedAt int64
+}
+
+func (q *Queries) CreateRestoreTest(ctx context.Context, arg CreateRestoreTestParams) error {
+	_, err := q.db.ExecContext(ctx, createRestoreTest,
+		arg.Instance,
+		arg.Package,
+		arg.Swhid,
+		arg.RequestedAt,
+	)
+	return err
+}
+
+const createSnapshotCheck = `-- name: CreateSnapshotCheck :exec
+INSERT INTO snapshot_checks (
+    instance, task_id, origin_url, snapshot_swhid, head_swhid,
+    branches, tags, missing, complete, requeues, checked_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+`
+
+type CreateSnapshotCheckParams struct {
+	Instance      string
+	TaskID        int64
+	OriginUrl     string
+	SnapshotSwhid string
+	HeadSwhid     sql.NullString
+	Branches      int64
+	Tags          int64
+	Missing       string
+	Complete      bool
+	Requeues      int64
+	CheckedAt     int64
+}
+
+func (q *Queries) CreateSnapshotCheck(ctx context.Context, arg CreateSnapshotCheckParams) error {
+	_, err := q.db.ExecContext(ctx, createSnapshotCheck,
+		arg.Instance,
+		arg.TaskID,
+		arg.OriginUrl,
+		arg.SnapshotSwhid,
+		arg.HeadSwhid,
+		arg.Branches,
+		arg.Tags,
+		arg.Missing,
+		arg.Complete,
+		arg.Requeues,
+		arg.CheckedAt,
+	)
+	return err
+}
+
+const createTaskRefs = `-- name: CreateTaskRefs :exec
+INSERT INTO task_refs (instance, task_id, name, target)
+SELECT tasks.instance, tasks.id, upstream_refs.name, upstream_refs.target FROM tasks
+JOIN upstream_refs ON upstream_refs.origin_url = ?
+WHERE tasks.instance = ? AND tasks.id = ?
+ON CONFLICT DO NOTHING
+`
+
+type CreateTaskRefsParams struct {
+	OriginUrl string
+	Instance  string
+	ID        int64
+}
+
+func (q *Queries) CreateTaskRefs(ctx context.Context, arg CreateTaskRefsParams) error {
+	_, err := q.db.ExecContext(ctx, createTaskRefs, arg.OriginUrl, arg.Instance, arg.ID)
+	return err
+}
+
+const createUpstreamRef = `-- name: CreateUpstreamRef :exec
+INSERT INTO upstream_refs (origin_url, name, target, seen_at)
+VALUES (?, ?, ?, ?)
+`
+
+type CreateUpstreamRefParams struct {
+	OriginUrl string
+	Name      string
+	Target    string
+	SeenAt    int64
+}
+
+func (q *Queries) CreateUpstreamRef(ctx context.Context, arg CreateUpstreamRefParams) error {
+	_, err := q.db.ExecContext(ctx, createUpstreamRef,
+		arg.OriginUrl,
+		arg.Name,
+		arg.Target,
+		arg.SeenAt,
+	)
+	return err
+}
+
+const deferSave = `-- name: DeferSave :exec
+UPDATE apps SET next_attempt_at = ?
+WHERE package = ?
+`
+
+type DeferSaveParams struct {
+	NextAttemptAt int64
+	Package       string
+}
+
+func (q *Queries) DeferSave(ctx context.Context, arg DeferSaveParams) error {
+	_, err := q.db.ExecContext(ctx, deferSave, arg.NextAttemptAt, arg.Package)
+	return err
+}
+
+const deleteAttemptsBefore = `-- name: DeleteAttemptsBefore :execrows
+DELETE FROM attempts WHERE started_at < ?
+`
+
+func (q *Queries) DeleteAttemptsBefore(ctx context.Context, startedAt int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAttemptsBefore, startedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteMirrorBlob = `-- name: DeleteMirrorBlob :exec
+DELETE FROM mirror_blobs WHERE sha256 = ?
+`
+
+func (q *Queries) DeleteMirrorBlob(ctx context.Context, sha256 string) error {
+	_, err := q.db.ExecContext(ctx, deleteMirrorBlob, sha256)
+	return err
+}
+
+const deleteQueueItem = `-- name: DeleteQueueItem :exec
+DELETE FROM queue
+WHERE package = ? AND archiver = ?
+`
+
+type DeleteQueueItemParams struct {
+	Package  string
+	Archiver string
+}
+
+func (q *Queries) DeleteQueueItem(ctx context.Context, arg DeleteQueueItemParams) error {
+	_, err := q.db.ExecContext(ctx, deleteQueueItem, arg.Package, arg.Archiver)
+	return err
+}
+
+const deleteRefMove = `-- name: DeleteRefMove :exec
+DELETE FROM ref_moves WHERE origin_url = ? AND ref = ?
+`
+
+type DeleteRefMoveParams struct {
+	OriginUrl string
+	Ref       string
+}
+
+func (q *Queries) DeleteRefMove(ctx context.Context, arg DeleteRefMoveParams) error {
+	_, err := q.db.ExecContext(ctx, deleteRefMove, arg.OriginUrl, arg.Ref)
+	return err
+}
+
+const deleteTaskRefs = `-- name: DeleteTaskRefs :exec
+DELETE FROM task_refs
+WHERE instance = ? AND task_id = ?
+`
+
+type DeleteTaskRefsParams struct {
+	Instance string
+	TaskID   int64
+}
+
+func (q *Queries) DeleteTaskRefs(ctx context.Context, arg DeleteTaskRefsParams) error {
+	_, err := q.db.ExecContext(ctx, deleteTaskRefs, arg.Instance, arg.TaskID)
+	return err
+}
+
+const deleteUnverifiableTaskRefs = `-- name: DeleteUnverifiableTaskRefs :execrows
+DELETE FROM task_refs
+WHERE instance = ? AND task_id IN (
+    SELECT id FROM tasks
+    WHERE tasks.instance = task_refs.instance
+        AND (save_request_status = 'rejected' OR save_task_status = 'failed' OR abandoned_at IS NOT NULL)
+)
+`
+
+func (q *Queries) DeleteUnverifiableTaskRefs(ctx context.Context, instance string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteUnverifiableTaskRefs, instance)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteUpstreamRefs = `-- name: DeleteUpstreamRefs :exec
+DELETE FROM upstream_refs
+WHERE origin_url = ?
+`
+
+func (q *Queries) DeleteUpstreamRefs(ctx context.Context, originUrl string) error {
+	_, err := q.db.ExecContext(ctx, deleteUpstreamRefs, originUrl)
+	return err
+}
+
+const deleteVersionSaveRetry = `-- name: DeleteVersionSaveRetry :exec
+DELETE FROM version_save_retries
+WHERE package = ? AND version_code = ? AND instance = ?
+`
+
+type DeleteVersionSaveRetryParams struct {
+	Package     string
+	VersionCode int64
+	Instance    string
+}
+
+func (q *Queries) DeleteVersionSaveRetry(ctx context.Context, arg DeleteVersionSaveRetryParams) error {
+	_, err := q.db.ExecContext(ctx, deleteVersionSaveRetry, arg.Package, arg.VersionCode, arg.Instance)
+	return err
+}
+
+const enqueueApp = `-- name: EnqueueApp :exec
+INSERT INTO queue (package, archiver, stage, target, enqueued_at)
+VALUES (?, ?, 'probe', ?, ?)
+ON CONFLICT(package, archiver) DO NOTHING
+`
+
+type EnqueueAppParams struct {
+	Package    string
+	Archiver   string
+	Target     string
+	EnqueuedAt int64
+}
+
+func (q *Queries) EnqueueApp(ctx context.Context, arg EnqueueAppParams) error {
+	_, err := q.db.ExecContext(ctx, enqueueApp,
+		arg.Package,
+		arg.Archiver,
+		arg.Target,
+		arg.EnqueuedAt,
+	)
+	return err
+}
+
+const existApp = `-- name: ExistApp :one
+SELECT EXISTS(SELECT 1 FROM apps WHERE package = ?)
+`
+
+func (q *Queries) ExistApp(ctx context.Context, package_ string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, existApp, package_)
+	var column_1 int64
+	err := row.Scan(&column_1)
+	return column_1, err
+}
+
+const expireMirroredVersions = `-- name: ExpireMirroredVersions :execrows
+UPDATE versions SET apk_mirrored = FALSE, src_mirrored = FALSE
+WHERE (apk_mirrored OR src_mirrored)
+AND (SELECT COUNT(*) FROM versions AS newer WHERE newer.package = versions.package AND newer.version_code > versions.version_code) >= ?
+`
+
+func (q *Queries) ExpireMirroredVersions(ctx context.Context, keep interface{}) (int64, error) {
+	result, err := q.db.ExecContext(ctx, expireMirroredVersions, keep)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const getAdminActions = `-- name: GetAdminActions :many
+SELECT id, actor, action, package, filter, affected, created_at FROM admin_actions
+ORDER BY id DESC
+LIMIT ?
+`
+
+func (q *Queries) GetAdminActions(ctx context.Context, limit int64) ([]AdminAction, error) {
+	rows, err := q.db.QueryContext(ctx, getAdminActions, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AdminAction
+	for rows.Next() {
+		var i AdminAction
+		if err := rows.Scan(
+			&i.ID,
+			&i.Actor,
+			&i.Action,
+			&i.Package,
+			&i.Filter,
+			&i.Affected,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAllApps = `-- name: GetAllApps :many
+SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, save_failures, next_attempt_at, last_error_class, gave_up_at, listed_at, delisted_at, categories, origin_url FROM apps_ordered
+WHERE package LIKE ? LIMIT ? OFFSET ?
+`
+
+type GetAllAppsParams struct {
+	Package string
+	Limit   int64
+	Offset  int64
+}
+
+func (q *Queries) GetAllApps(ctx context.Context, arg GetAllAppsParams) ([]AppsOrdered, error) {
+	rows, err := q.db.QueryContext(ctx, getAllApps, arg.Package, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AppsOrdered
+	for rows.Next() {
+		var i AppsOrdered
+		if err := rows.Scan(
+			&i.Package,
+			&i.MetaAdded,
+			&i.MetaLastUpdated,
+			&i.MetaSourceCode,
+			&i.LastSaveTriggered,
+			&i.SaveFailures,
+			&i.NextAttemptAt,
+			&i.LastErrorClass,
+			&i.GaveUpAt,
+			&i.ListedAt,
+			&i.DelistedAt,
+			&i.Categories,
+			&i.OriginUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getApp = `-- name: GetApp :one
+SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, save_failures, next_attempt_at, last_error_class, gave_up_at, listed_at, delisted_at, categories, origin_url FROM apps
+WHERE package = ? LIMIT 1
+`
+
+func (q *Queries) GetApp(ctx context.Context, package_ string) (App, error) {
+	row := q.db.QueryRowContext(ctx, getApp, package_)
+	var i App
+	err := row.Scan(
+		&i.Package,
+		&i.MetaAdded,
+		&i.MetaLastUpdated,
+		&i.MetaSourceCode,
+		&i.LastSaveTriggered,
+		&i.SaveFailures,
+		&i.NextAttemptAt,
+		&i.LastErrorClass,
+		&i.GaveUpAt,
+		&i.ListedAt,
+		&i.DelistedAt,
+		&i.Categories,
+		&i.OriginUrl,
+	)
+	return i, err
+}
+
+const getAppAttempts = `-- name: GetAppAttempts :many
+SELECT id, package, archiver, stage, target, job_id, started_at, finished_at, outcome, error_class, http_status, message FROM attempts
+WHERE package = ? OR target = ? OR target = ?
+ORDER BY started_at DESC LIMIT ?
+`
+
+type GetAppAttemptsParams struct {
+	Package  sql.NullString
+	Target   string
+	Target_2 string
+	Limit    int64
+}
+
+func (q *Queries) GetAppAttempts(ctx context.Context, arg GetAppAttemptsParams) ([]Attempt, error) {
+	rows, err := q.db.QueryContext(ctx, getAppAttempts,
+		arg.Package,
+		arg.Target,
+		arg.Target_2,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attempt
+	for rows.Next() {
+		var i Attempt
+		if err := rows.Scan(
+			&i.ID,
+			&i.Package,
+			&i.Archiver,
+			&i.Stage,
+			&i.Target,
+			&i.JobID,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.Outcome,
+			&i.ErrorClass,
+			&i.HttpStatus,
+			&i.Message,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppPriorities = `-- name: GetAppPriorities :many
+SELECT apps.package, apps.meta_source_code, apps.categories, apps.meta_last_updated, apps.last_save_triggered, apps.save_failures, apps.next_attempt_at, apps.gave_up_at, apps.delisted_at, CAST(COALESCE((SELECT MAX(COALESCE(tasks.finished_at, tasks.created_at)) FROM tasks
+        WHERE tasks.instance = ? AND tasks.origin_url = apps.origin_url AND tasks.snapshot_swhid IS NOT NULL), 0) AS INTEGER) AS last_snapshot_at, CAST(COALESCE((SELECT MAX(CAST(strftime('%s', origin_visits.date) AS INTEGER)) FROM origin_visits
+        WHERE origin_visits.instance = ? AND origin_visits.origin_url = apps.origin_url AND origin_visits.status IN ('full', 'partial')), 0) AS INTEGER) AS last_visit_at, CAST((SELECT COUNT(*) FROM ref_alerts WHERE ref_alerts.package = apps.package AND ref_alerts.detected_at > ?) AS INTEGER) AS alerts, CAST((SELECT COUNT(*) FROM ref_moves WHERE ref_moves.origin_url = apps.origin_url) AS INTEGER) AS ref_moves FROM apps
+WHERE apps.package LIKE ?
+`
+
+type GetAppPrioritiesParams struct {
+	Instance   string
+	Instance_2 string
+	DetectedAt int64
+	Package    string
+}
+
+type GetAppPrioritiesRow struct {
+	Package           string
+	MetaSourceCode    string
+	Categories        string
+	MetaLastUpdated   int64
+	LastSaveTriggered int64
+	SaveFailures      int64
+	NextAttemptAt     int64
+	GaveUpAt          sql.NullInt64
+	DelistedAt        sql.NullInt64
+	LastSnapshotAt    int64
+	LastVisitAt       int64
+	Alerts            int64
+	RefMoves          int64
+}
+
+func (q *Queries) GetAppPriorities(ctx context.Context, arg GetAppPrioritiesParams) ([]GetAppPrioritiesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAppPriorities,
+		arg.Instance,
+		arg.Instance_2,
+		arg.DetectedAt,
+		arg.Package,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAppPrioritiesRow
+	for rows.Next() {
+		var i GetAppPrioritiesRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.MetaSourceCode,
+			&i.Categories,
+			&i.MetaLastUpdated,
+			&i.LastSaveTriggered,
+			&i.SaveFailures,
+			&i.NextAttemptAt,
+			&i.GaveUpAt,
+			&i.DelistedAt,
+			&i.LastSnapshotAt,
+			&i.LastVisitAt,
+			&i.Alerts,
+			&i.RefMoves,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppRefAlerts = `-- name: GetAppRefAlerts :many
+SELECT id, package, origin_url, kind, ref, before, after, release, detected_at FROM ref_alerts WHERE package = ?
+ORDER BY detected_at DESC, id DESC LIMIT ?
+`
+
+type GetAppRefAlertsParams struct {
+	Package string
+	Limit   int64
+}
+
+func (q *Queries) GetAppRefAlerts(ctx context.Context, arg GetAppRefAlertsParams) ([]RefAlert, error) {
+	rows, err := q.db.QueryContext(ctx, getAppRefAlerts, arg.Package, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefAlert
+	for rows.Next() {
+		var i RefAlert
+		if err := rows.Scan(
+			&i.ID,
+			&i.Package,
+			&i.OriginUrl,
+			&i.Kind,
+			&i.Ref,
+			&i.Before,
+			&i.After,
+			&i.Release,
+			&i.DetectedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppReleases = `-- name: GetAppReleases :many
+SELECT versions.package, versions.version_code, versions.version_name, versions.added, versions.file_name, versions.file_sha256, versions.src_name, versions.src_sha256, versions.tag, versions.revision_swhid, versions.mapped_at, versions.src_cnt_swhid, versions.src_dir_swhid, versions.src_hashed_at, versions.src_error, versions.apk_mirrored, versions.src_mirrored, versions.mirrored_at, versions.mirror_error, known_swhids.known FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid
+WHERE versions.package = ?
+ORDER BY versions.version_code DESC
+`
+
+type GetAppReleasesParams struct {
+	Instance string
+	Package  string
+}
+
+type GetAppReleasesRow struct {
+	Package       string
+	VersionCode   int64
+	VersionName   string
+	Added         int64
+	FileName      string
+	FileSha256    string
+	SrcName       sql.NullString
+	SrcSha256     sql.NullString
+	Tag           sql.NullString
+	RevisionSwhid sql.NullString
+	MappedAt      int64
+	SrcCntSwhid   sql.NullString
+	SrcDirSwhid   sql.NullString
+	SrcHashedAt   int64
+	SrcError      sql.NullString
+	ApkMirrored   bool
+	SrcMirrored   bool
+	MirroredAt    int64
+	MirrorError   sql.NullString
+	Known         sql.NullBool
+}
+
+func (q *Queries) GetAppReleases(ctx context.Context, arg GetAppReleasesParams) ([]GetAppReleasesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAppReleases, arg.Instance, arg.Package)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAppReleasesRow
+	for rows.Next() {
+		var i GetAppReleasesRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+			&i.Known,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppTask = `-- name: GetAppTask :one
+SELECT tasks.instance, tasks.id, tasks.save_request_status, tasks.save_task_status, tasks.snapshot_swhid, tasks.origin_url, tasks.visit_type, tasks.save_request_date, tasks.visit_date, tasks.visit_status, tasks.loading_task_id, tasks.note, tasks.request_url, tasks.raw, tasks.created_at, tasks.updated_at, tasks.finished_at, tasks.next_poll_at, tasks.poll_failures, tasks.abandoned_at, tasks.abandon_reason FROM app_tasks
+JOIN tasks ON tasks.instance = app_tasks.instance AND tasks.id = app_tasks.last_task_id
+WHERE app_tasks.package = ? AND app_tasks.instance = ? LIMIT 1
+`
+
+type GetAppTaskParams struct {
+	Package  string
+	Instance string
+}
+
+func (q *Queries) GetAppTask(ctx context.Context, arg GetAppTaskParams) (Task, error) {
+	row := q.db.QueryRowContext(ctx, getAppTask, arg.Package, arg.Instance)
+	var i Task
+	err := row.Scan(
+		&i.Instance,
+		&i.ID,
+		&i.SaveRequestStatus,
+		&i.SaveTaskStatus,
+		&i.SnapshotSwhid,
+		&i.OriginUrl,
+		&i.VisitType,
+		&i.SaveRequestDate,
+		&i.VisitDate,
+		&i.VisitStatus,
+		&i.LoadingTaskID,
+		&i.Note,
+		&i.RequestUrl,
+		&i.Raw,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.NextPollAt,
+		&i.PollFailures,
+		&i.AbandonedAt,
+		&i.AbandonReason,
+	)
+	return i, err
+}
+
+const getAppTasks = `-- name: GetAppTasks :many
+SELECT app_tasks.package, tasks.instance, tasks.id, tasks.save_request_status, tasks.save_task_status, tasks.snapshot_swhid, tasks.origin_url, tasks.visit_type, tasks.save_request_date, tasks.visit_date, tasks.visit_status, tasks.loading_task_id, tasks.note, tasks.request_url, tasks.raw, tasks.created_at, tasks.updated_at, tasks.finished_at, tasks.next_poll_at, tasks.poll_failures, tasks.abandoned_at, tasks.abandon_reason FROM app_tasks
+JOIN tasks ON tasks.instance = app_tasks.instance AND tasks.id = app_tasks.last_task_id
+WHERE app_tasks.instance = ?
+`
+
+type GetAppTasksRow struct {
+	Package           string
+	Instance          string
+	ID                int64
+	SaveRequestStatus string
+	SaveTaskStatus    string
+	SnapshotSwhid     sql.NullString
+	OriginUrl         string
+	VisitType         string
+	SaveRequestDate   string
+	VisitDate         sql.NullString
+	VisitStatus       sql.NullString
+	LoadingTaskID     sql.NullInt64
+	Note              sql.NullString
+	RequestUrl        string
+	Raw               string
+	CreatedAt         int64
+	UpdatedAt         int64
+	FinishedAt        sql.NullInt64
+	NextPollAt        int64
+	PollFailures      int64
+	AbandonedAt       sql.NullInt64
+	AbandonReason     sql.NullString
+}
+
+func (q *Queries) GetAppTasks(ctx context.Context, instance string) ([]GetAppTasksRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAppTasks, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAppTasksRow
+	for rows.Next() {
+		var i GetAppTasksRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.Instance,
+			&i.ID,
+			&i.SaveRequestStatus,
+			&i.SaveTaskStatus,
+			&i.SnapshotSwhid,
+			&i.OriginUrl,
+			&i.VisitType,
+			&i.SaveRequestDate,
+			&i.VisitDate,
+			&i.VisitStatus,
+			&i.LoadingTaskID,
+			&i.Note,
+			&i.RequestUrl,
+			&i.Raw,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.FinishedAt,
+			&i.NextPollAt,
+			&i.PollFailures,
+			&i.AbandonedAt,
+			&i.AbandonReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppVersions = `-- name: GetAppVersions :many
+SELECT package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256, tag, revision_swhid, mapped_at, src_cnt_swhid, src_dir_swhid, src_hashed_at, src_error, apk_mirrored, src_mirrored, mirrored_at, mirror_error FROM versions WHERE package = ?
+ORDER BY added DESC LIMIT ?
+`
+
+type GetAppVersionsParams struct {
+	Package string
+	Limit   int64
+}
+
+func (q *Queries) GetAppVersions(ctx context.Context, arg GetAppVersionsParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getAppVersions, arg.Package, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAppsByOrigin = `-- name: GetAppsByOrigin :many
+SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, save_failures, next_attempt_at, last_error_class, gave_up_at, listed_at, delisted_at, categories, origin_url FROM apps_ordered WHERE origin_url = ?
+`
+
+func (q *Queries) GetAppsByOrigin(ctx context.Context, originUrl sql.NullString) ([]AppsOrdered, error) {
+	rows, err := q.db.QueryContext(ctx, getAppsByOrigin, originUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AppsOrdered
+	for rows.Next() {
+		var i AppsOrdered
+		if err := rows.Scan(
+			&i.Package,
+			&i.MetaAdded,
+			&i.MetaLastUpdated,
+			&i.MetaSourceCode,
+			&i.LastSaveTriggered,
+			&i.SaveFailures,
+			&i.NextAttemptAt,
+			&i.LastErrorClass,
+			&i.GaveUpAt,
+			&i.ListedAt,
+			&i.DelistedAt,
+			&i.Categories,
+			&i.OriginUrl,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getFailedApps = `-- name: GetFailedApps :many
+SELECT package, meta_source_code, last_error_class FROM apps
+WHERE save_failures > 0
+ORDER BY package
+`
+
+type GetFailedAppsRow struct {
+	Package        string
+	MetaSourceCode string
+	LastErrorClass sql.NullString
+}
+
+func (q *Queries) GetFailedApps(ctx context.Context) ([]GetFailedAppsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getFailedApps)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetFailedAppsRow
+	for rows.Next() {
+		var i GetFailedAppsRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.MetaSourceCode,
+			&i.LastErrorClass,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getKnownSwhid = `-- name: GetKnownSwhid :one
+SELECT instance, swhid, known, checked_at FROM known_swhids
+WHERE instance = ? AND swhid = ? LIMIT 1
+`
+
+type GetKnownSwhidParams struct {
+	Instance string
+	Swhid    string
+}
+
+func (q *Queries) GetKnownSwhid(ctx context.Context, arg GetKnownSwhidParams) (KnownSwhid, error) {
+	row := q.db.QueryRowContext(ctx, getKnownSwhid, arg.Instance, arg.Swhid)
+	var i KnownSwhid
+	err := row.Scan(
+		&i.Instance,
+		&i.Swhid,
+		&i.Known,
+		&i.CheckedAt,
+	)
+	return i, err
+}
+
+const getLastAttempts = `-- name: GetLastAttempts :many
+SELECT id, package, archiver, stage, target, job_id, started_at, finished_at, outcome, error_class, http_status, message FROM attempts
+WHERE id IN (SELECT MAX(id) FROM attempts WHERE package IS NOT NULL GROUP BY package)
+`
+
+func (q *Queries) GetLastAttempts(ctx context.Context) ([]Attempt, error) {
+	rows, err := q.db.QueryContext(ctx, getLastAttempts)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Attempt
+	for rows.Next() {
+		var i Attempt
+		if err := rows.Scan(
+			&i.ID,
+			&i.Package,
+			&i.Archiver,
+			&i.Stage,
+			&i.Target,
+			&i.JobID,
+			&i.StartedAt,
+			&i.FinishedAt,
+			&i.Outcome,
+			&i.ErrorClass,
+			&i.HttpStatus,
+			&i.Message,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLastFinishedVisit = `-- name: GetLastFinishedVisit :one
+SELECT CAST(COALESCE(MAX(visit), 0) AS INTEGER) AS visit FROM origin_visits
+WHERE instance = ? AND origin_url = ? AND status NOT IN ('created', 'ongoing')
+`
+
+type GetLastFinishedVisitParams struct {
+	Instance  string
+	OriginUrl string
+}
+
+func (q *Queries) GetLastFinishedVisit(ctx context.Context, arg GetLastFinishedVisitParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getLastFinishedVisit, arg.Instance, arg.OriginUrl)
+	var visit int64
+	err := row.Scan(&visit)
+	return visit, err
+}
+
+const getLatestSnapshotCheck = `-- name: GetLatestSnapshotCheck :one
+SELECT instance, task_id, origin_url, snapshot_swhid, head_swhid, branches, tags, missing, complete, requeues, checked_at FROM snapshot_checks
+WHERE instance = ? AND origin_url = ?
+ORDER BY checked_at DESC LIMIT 1
+`
+
+type GetLatestSnapshotCheckParams struct {
+	Instance  string
+	OriginUrl string
+}
+
+func (q *Queries) GetLatestSnapshotCheck(ctx context.Context, arg GetLatestSnapshotCheckParams) (SnapshotCheck, error) {
+	row := q.db.QueryRowContext(ctx, getLatestSnapshotCheck, arg.Instance, arg.OriginUrl)
+	var i SnapshotCheck
+	err := row.Scan(
+		&i.Instance,
+		&i.TaskID,
+		&i.OriginUrl,
+		&i.SnapshotSwhid,
+		&i.HeadSwhid,
+		&i.Branches,
+		&i.Tags,
+		&i.Missing,
+		&i.Complete,
+		&i.Requeues,
+		&i.CheckedAt,
+	)
+	return i, err
+}
+
+const getLatestSnapshotChecks = `-- name: GetLatestSnapshotChecks :many
+SELECT snapshot_checks.instance, snapshot_checks.task_id, snapshot_checks.origin_url, snapshot_checks.snapshot_swhid, snapshot_checks.head_swhid, snapshot_checks.branches, snapshot_checks.tags, snapshot_checks.missing, snapshot_checks.complete, snapshot_checks.requeues, snapshot_checks.checked_at FROM snapshot_checks
+JOIN (SELECT origin_url, MAX(checked_at) AS checked_at FROM snapshot_checks WHERE instance = ? GROUP BY origin_url) AS latest
+    ON latest.origin_url = snapshot_checks.origin_url AND latest.checked_at = snapshot_checks.checked_at
+WHERE snapshot_checks.instance = ?
+`
+
+type GetLatestSnapshotChecksParams struct {
+	Instance   string
+	Instance_2 string
+}
+
+func (q *Queries) GetLatestSnapshotChecks(ctx context.Context, arg GetLatestSnapshotChecksParams) ([]SnapshotCheck, error) {
+	rows, err := q.db.QueryContext(ctx, getLatestSnapshotChecks, arg.Instance, arg.Instance_2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SnapshotCheck
+	for rows.Next() {
+		var i SnapshotCheck
+		if err := rows.Scan(
+			&i.Instance,
+			&i.TaskID,
+			&i.OriginUrl,
+			&i.SnapshotSwhid,
+			&i.HeadSwhid,
+			&i.Branches,
+			&i.Tags,
+			&i.Missing,
+			&i.Complete,
+			&i.Requeues,
+			&i.CheckedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMirrorBlob = `-- name: GetMirrorBlob :one
+SELECT sha256, size, stored_at, verified_at FROM mirror_blobs WHERE sha256 = ?
+`
+
+func (q *Queries) GetMirrorBlob(ctx context.Context, sha256 string) (MirrorBlob, error) {
+	row := q.db.QueryRowContext(ctx, getMirrorBlob, sha256)
+	var i MirrorBlob
+	err := row.Scan(
+		&i.Sha256,
+		&i.Size,
+		&i.StoredAt,
+		&i.VerifiedAt,
+	)
+	return i, err
+}
+
+const getMirrorBlobs = `-- name: GetMirrorBlobs :many
+SELECT sha256, size, stored_at, verified_at FROM mirror_blobs ORDER BY verified_at
+`
+
+func (q *Queries) GetMirrorBlobs(ctx context.Context) ([]MirrorBlob, error) {
+	rows, err := q.db.QueryContext(ctx, getMirrorBlobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MirrorBlob
+	for rows.Next() {
+		var i MirrorBlob
+		if err := rows.Scan(
+			&i.Sha256,
+			&i.Size,
+			&i.StoredAt,
+			&i.VerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getMirrorUsage = `-- name: GetMirrorUsage :one
+SELECT COUNT(*) AS blobs, CAST(COALESCE(SUM(size), 0) AS INTEGER) AS size FROM mirror_blobs
+`
+
+type GetMirrorUsageRow struct {
+	Blobs int64
+	Size  int64
+}
+
+func (q *Queries) GetMirrorUsage(ctx context.Context) (GetMirrorUsageRow, error) {
+	row := q.db.QueryRowContext(ctx, getMirrorUsage)
+	var i GetMirrorUsageRow
+	err := row.Scan(
+		&i.Blobs,
+		&i.Size,
+	)
+	return i, err
+}
+
+const getOriginPackages = `-- name: GetOriginPackages :many
+SELECT package FROM apps
+WHERE origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?)
+ORDER BY package
+`
+
+func (q *Queries) GetOriginPackages(ctx context.Context, package_ string) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getOriginPackages, package_)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var package_ string
+		if err := rows.Scan(&package_); err != nil {
+			return nil, err
+		}
+		items = append(items, package_)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOriginStats = `-- name: GetOriginStats :one
+SELECT COUNT(*) AS origins, CAST(COALESCE(SUM(apps > 1), 0) AS INTEGER) AS shared, CAST(COALESCE(SUM(CASE WHEN apps > 1 THEN apps ELSE 0 END), 0) AS INTEGER) AS sharing_apps FROM (SELECT origin_url, COUNT(*) AS apps FROM apps WHERE origin_url IS NOT NULL GROUP BY origin_url)
+`
+
+type GetOriginStatsRow struct {
+	Origins     int64
+	Shared      int64
+	SharingApps int64
+}
+
+func (q *Queries) GetOriginStats(ctx context.Context) (GetOriginStatsRow, error) {
+	row := q.db.QueryRowContext(ctx, getOriginStats)
+	var i GetOriginStatsRow
+	err := row.Scan(
+		&i.Origins,
+		&i.Shared,
+		&i.SharingApps,
+	)
+	return i, err
+}
+
+const getOriginVisits = `-- name: GetOriginVisits :many
+SELECT instance, origin_url, visit, date, type, status, snapshot_swhid FROM origin_visits
+WHERE instance = ? AND origin_url = ?
+ORDER BY visit DESC LIMIT ?
+`
+
+type GetOriginVisitsParams struct {
+	Instance  string
+	OriginUrl string
+	Limit     int64
+}
+
+func (q *Queries) GetOriginVisits(ctx context.Context, arg GetOriginVisitsParams) ([]OriginVisit, error) {
+	rows, err := q.db.QueryContext(ctx, getOriginVisits, arg.Instance, arg.OriginUrl, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []OriginVisit
+	for rows.Next() {
+		var i OriginVisit
+		if err := rows.Scan(
+			&i.Instance,
+			&i.OriginUrl,
+			&i.Visit,
+			&i.Date,
+			&i.Type,
+			&i.Status,
+			&i.SnapshotSwhid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOriginsToFetchVisits = `-- name: GetOriginsToFetchVisits :many
+SELECT origins.url FROM origins
+LEFT JOIN visit_fetches ON visit_fetches.instance = ? AND visit_fetches.origin_url = origins.url
+WHERE (visit_fetches.fetched_at IS NULL OR visit_fetches.fetched_at < ?)
+    AND EXISTS (SELECT 1 FROM apps WHERE apps.origin_url = origins.url)
+ORDER BY COALESCE(visit_fetches.fetched_at, 0) LIMIT ?
+`
+
+type GetOriginsToFetchVisitsParams struct {
+	Instance  string
+	FetchedAt int64
+	Limit     int64
+}
+
+func (q *Queries) GetOriginsToFetchVisits(ctx context.Context, arg GetOriginsToFetchVisitsParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getOriginsToFetchVisits, arg.Instance, arg.FetchedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		items = append(items, url)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPauses = `-- name: GetPauses :many
+SELECT component, actor, reason, paused_at FROM pauses
+ORDER BY paused_at
+`
+
+func (q *Queries) GetPauses(ctx context.Context) ([]Pause, error) {
+	rows, err := q.db.QueryContext(ctx, getPauses)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Pause
+	for rows.Next() {
+		var i Pause
+		if err := rows.Scan(
+			&i.Component,
+			&i.Actor,
+			&i.Reason,
+			&i.PausedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPendingRestoreTests = `-- name: GetPendingRestoreTests :many
+SELECT id, instance, package, swhid, status, requested_at, checked_at, bytes, error FROM restore_tests
+WHERE instance = ? AND status = 'pending'
+ORDER BY requested_at
+`
+
+func (q *Queries) GetPendingRestoreTests(ctx context.Context, instance string) ([]RestoreTest, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingRestoreTests, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RestoreTest
+	for rows.Next() {
+		var i RestoreTest
+		if err := rows.Scan(
+			&i.ID,
+			&i.Instance,
+			&i.Package,
+			&i.Swhid,
+			&i.Status,
+			&i.RequestedAt,
+			&i.CheckedAt,
+			&i.Bytes,
+			&i.Error,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getPendingTasks = `-- name: GetPendingTasks :many
+SELECT instance, id, save_request_status, save_task_status, snapshot_swhid, origin_url, visit_type, save_request_date, visit_date, visit_status, loading_task_id, note, request_url, raw, created_at, updated_at, finished_at, next_poll_at, poll_failures, abandoned_at, abandon_reason FROM tasks
+WHERE instance = ? AND save_request_status != 'rejected'
+    AND save_task_status NOT IN ('succeeded', 'failed')
+    AND abandoned_at IS NULL AND next_poll_at <= ?
+ORDER BY next_poll_at LIMIT ?
+`
+
+type GetPendingTasksParams struct {
+	Instance   string
+	NextPollAt int64
+	Limit      int64
+}
+
+func (q *Queries) GetPendingTasks(ctx context.Context, arg GetPendingTasksParams) ([]Task, error) {
+	rows, err := q.db.QueryContext(ctx, getPendingTasks, arg.Instance, arg.NextPollAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.Instance,
+			&i.ID,
+			&i.SaveRequestStatus,
+			&i.SaveTaskStatus,
+			&i.SnapshotSwhid,
+			&i.OriginUrl,
+			&i.VisitType,
+			&i.SaveRequestDate,
+			&i.VisitDate,
+			&i.VisitStatus,
+			&i.LoadingTaskID,
+			&i.Note,
+			&i.RequestUrl,
+			&i.Raw,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.FinishedAt,
+			&i.NextPollAt,
+			&i.PollFailures,
+			&i.AbandonedAt,
+			&i.AbandonReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRefMoves = `-- name: GetRefMoves :many
+SELECT origin_url, ref, before, after, seen_at FROM ref_moves WHERE origin_url = ?
+ORDER BY seen_at LIMIT ?
+`
+
+type GetRefMovesParams struct {
+	OriginUrl string
+	Limit     int64
+}
+
+func (q *Queries) GetRefMoves(ctx context.Context, arg GetRefMovesParams) ([]RefMove, error) {
+	rows, err := q.db.QueryContext(ctx, getRefMoves, arg.OriginUrl, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []RefMove
+	for rows.Next() {
+		var i RefMove
+		if err := rows.Scan(
+			&i.OriginUrl,
+			&i.Ref,
+			&i.Before,
+			&i.After,
+			&i.SeenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getReleaseCoverage = `-- name: GetReleaseCoverage :many
+SELECT versions.package, COUNT(*) AS total, COUNT(known_swhids.swhid) AS archived FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid AND known_swhids.known = 1
+GROUP BY versions.package
+`
+
+type GetReleaseCoverageRow struct {
+	Package  string
+	Total    int64
+	Archived int64
+}
+
+func (q *Queries) GetReleaseCoverage(ctx context.Context, instance string) ([]GetReleaseCoverageRow, error) {
+	rows, err := q.db.QueryContext(ctx, getReleaseCoverage, instance)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetReleaseCoverageRow
+	for rows.Next() {
+		var i GetReleaseCoverageRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.Total,
+			&i.Archived,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRestoreCandidates = `-- name: GetRestoreCandidates :many
+SELECT app_tasks.package, snapshot_checks.head_swhid FROM snapshot_checks
+JOIN app_tasks ON app_tasks.instance = snapshot_checks.instance AND app_tasks.last_task_id = snapshot_checks.task_id
+WHERE snapshot_checks.instance = ? AND snapshot_checks.head_swhid IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM restore_tests WHERE restore_tests.instance = snapshot_checks.instance AND restore_tests.package = app_tasks.package AND restore_tests.requested_at > ?)
+ORDER BY RANDOM() LIMIT ?
+`
+
+type GetRestoreCandidatesParams struct {
+	Instance    string
+	RequestedAt int64
+	Limit       int64
+}
+
+type GetRestoreCandidatesRow struct {
+	Package   string
+	HeadSwhid sql.NullString
+}
+
+func (q *Queries) GetRestoreCandidates(ctx context.Context, arg GetRestoreCandidatesParams) ([]GetRestoreCandidatesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRestoreCandidates, arg.Instance, arg.RequestedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRestoreCandidatesRow
+	for rows.Next() {
+		var i GetRestoreCandidatesRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.HeadSwhid,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRestoreTestStats = `-- name: GetRestoreTestStats :many
+SELECT status, COUNT(*) AS count FROM restore_tests
+WHERE instance = ? AND requested_at > ?
+GROUP BY status
+`
+
+type GetRestoreTestStatsParams struct {
+	Instance    string
+	RequestedAt int64
+}
+
+type GetRestoreTestStatsRow struct {
+	Status string
+	Count  int64
+}
+
+func (q *Queries) GetRestoreTestStats(ctx context.Context, arg GetRestoreTestStatsParams) ([]GetRestoreTestStatsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getRestoreTestStats, arg.Instance, arg.RequestedAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetRestoreTestStatsRow
+	for rows.Next() {
+		var i GetRestoreTestStatsRow
+		if err := rows.Scan(
+			&i.Status,
+			&i.Count,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSaveCandidates = `-- name: GetSaveCandidates :many
+SELECT apps.package, apps.meta_source_code, apps.categories, apps.meta_last_updated, apps.last_save_triggered, apps.save_failures, apps.next_attempt_at, apps.gave_up_at, apps.delisted_at, CAST(COALESCE((SELECT MAX(COALESCE(tasks.finished_at, tasks.created_at)) FROM tasks
+        WHERE tasks.instance = ? AND tasks.origin_url = apps.origin_url AND tasks.snapshot_swhid IS NOT NULL), 0) AS INTEGER) AS last_snapshot_at, CAST(COALESCE((SELECT MAX(CAST(strftime('%s', origin_visits.date) AS INTEGER)) FROM origin_visits
+        WHERE origin_visits.instance = ? AND origin_visits.origin_url = apps.origin_url AND origin_visits.status IN ('full', 'partial')), 0) AS INTEGER) AS last_visit_at, CAST((SELECT COUNT(*) FROM ref_alerts WHERE ref_alerts.package = apps.package AND ref_alerts.detected_at > ?) AS INTEGER) AS alerts, CAST((SELECT COUNT(*) FROM ref_moves WHERE ref_moves.origin_url = apps.origin_url) AS INTEGER) AS ref_moves FROM apps
+WHERE (apps.meta_last_updated > apps.last_save_triggered AND apps.next_attempt_at <= ?)
+    OR (apps.gave_up_at IS NULL AND apps.next_attempt_at > 0 AND apps.next_attempt_at <= ?)
+    -- possibly due for re-archiving, checked against each app's cadence
+    OR (apps.gave_up_at IS NULL AND apps.next_attempt_at <= ? AND apps.last_save_triggered < ?)
+LIMIT ?
+`
+
+type GetSaveCandidatesParams struct {
+	Instance          string
+	Instance_2        string
+	DetectedAt        int64
+	NextAttemptAt     int64
+	NextAttemptAt_2   int64
+	NextAttemptAt_3   int64
+	LastSaveTriggered int64
+	Limit             int64
+}
+
+type GetSaveCandidatesRow struct {
+	Package           string
+	MetaSourceCode    string
+	Categories        string
+	MetaLastUpdated   int64
+	LastSaveTriggered int64
+	SaveFailures      int64
+	NextAttemptAt     int64
+	GaveUpAt          sql.NullInt64
+	DelistedAt        sql.NullInt64
+	LastSnapshotAt    int64
+	LastVisitAt       int64
+	Alerts            int64
+	RefMoves          int64
+}
+
+func (q *Queries) GetSaveCandidates(ctx context.Context, arg GetSaveCandidatesParams) ([]GetSaveCandidatesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getSaveCandidates,
+		arg.Instance,
+		arg.Instance_2,
+		arg.DetectedAt,
+		arg.NextAttemptAt,
+		arg.NextAttemptAt_2,
+		arg.NextAttemptAt_3,
+		arg.LastSaveTriggered,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetSaveCandidatesRow
+	for rows.Next() {
+		var i GetSaveCandidatesRow
+		if err := rows.Scan(
+			&i.Package,
+			&i.MetaSourceCode,
+			&i.Categories,
+			&i.MetaLastUpdated,
+			&i.LastSaveTriggered,
+			&i.SaveFailures,
+			&i.NextAttemptAt,
+			&i.GaveUpAt,
+			&i.DelistedAt,
+			&i.LastSnapshotAt,
+			&i.LastVisitAt,
+			&i.Alerts,
+			&i.RefMoves,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSourcesToSave = `-- name: GetSourcesToSave :many
+SELECT versions.package, versions.version_code, versions.version_name, versions.added, versions.file_name, versions.file_sha256, versions.src_name, versions.src_sha256, versions.tag, versions.revision_swhid, versions.mapped_at, versions.src_cnt_swhid, versions.src_dir_swhid, versions.src_hashed_at, versions.src_error, versions.apk_mirrored, versions.src_mirrored, versions.mirrored_at, versions.mirror_error FROM versions
+JOIN known_swhids AS dir_known ON dir_known.instance = ? AND dir_known.swhid = versions.src_dir_swhid AND dir_known.known = 0
+LEFT JOIN known_swhids AS rev_known ON rev_known.instance = ? AND rev_known.swhid = versions.revision_swhid AND rev_known.known = 1
+LEFT JOIN version_tasks ON version_tasks.package = versions.package AND version_tasks.version_code = versions.version_code AND version_tasks.instance = ?
+LEFT JOIN tasks ON tasks.instance = version_tasks.instance AND tasks.id = version_tasks.last_task_id
+LEFT JOIN version_save_retries AS retries ON retries.package = versions.package AND retries.version_code = versions.version_code AND retries.instance = ?
+WHERE rev_known.swhid IS NULL
+    AND (version_tasks.last_task_id IS NULL OR tasks.save_request_status = 'rejected'
+        OR tasks.save_task_status = 'failed' OR tasks.abandoned_at IS NOT NULL)
+    AND retries.gave_up_at IS NULL AND (retries.next_attempt_at IS NULL OR retries.next_attempt_at <= ?)
+ORDER BY versions.added DESC LIMIT ?
+`
+
+type GetSourcesToSaveParams struct {
+	Instance      string
+	Instance_2    string
+	Instance_3    string
+	Instance_4    string
+	NextAttemptAt int64
+	Limit         int64
+}
+
+func (q *Queries) GetSourcesToSave(ctx context.Context, arg GetSourcesToSaveParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getSourcesToSave,
+		arg.Instance,
+		arg.Instance_2,
+		arg.Instance_3,
+		arg.Instance_4,
+		arg.NextAttemptAt,
+		arg.Limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getStaleOrigins = `-- name: GetStaleOrigins :many
+SELECT url FROM origins
+WHERE refs_checked_at < ?
+    AND EXISTS (SELECT 1 FROM upstream_refs WHERE upstream_refs.origin_url = origins.url)
+ORDER BY refs_checked_at LIMIT ?
+`
+
+type GetStaleOriginsParams struct {
+	RefsCheckedAt int64
+	Limit         int64
+}
+
+func (q *Queries) GetStaleOrigins(ctx context.Context, arg GetStaleOriginsParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getStaleOrigins, arg.RefsCheckedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return nil, err
+		}
+		items = append(items, url)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTask = `-- name: GetTask :one
+SELECT instance, id, save_request_status, save_task_status, snapshot_swhid, origin_url, visit_type, save_request_date, visit_date, visit_status, loading_task_id, note, request_url, raw, created_at, updated_at, finished_at, next_poll_at, poll_failures, abandoned_at, abandon_reason FROM tasks
+WHERE instance = ? AND id = ? LIMIT 1
+`
+
+type GetTaskParams struct {
+	Instance string
+	ID       int64
+}
+
+func (q *Queries) GetTask(ctx context.Context, arg GetTaskParams) (Task, error) {
+	row := q.db.QueryRowContext(ctx, getTask, arg.Instance, arg.ID)
+	var i Task
+	err := row.Scan(
+		&i.Instance,
+		&i.ID,
+		&i.SaveRequestStatus,
+		&i.SaveTaskStatus,
+		&i.SnapshotSwhid,
+		&i.OriginUrl,
+		&i.VisitType,
+		&i.SaveRequestDate,
+		&i.VisitDate,
+		&i.VisitStatus,
+		&i.LoadingTaskID,
+		&i.Note,
+		&i.RequestUrl,
+		&i.Raw,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.NextPollAt,
+		&i.PollFailures,
+		&i.AbandonedAt,
+		&i.AbandonReason,
+	)
+	return i, err
+}
+
+const getTaskPackages = `-- name: GetTaskPackages :many
+SELECT package FROM app_tasks
+WHERE instance = ? AND last_task_id = ?
+`
+
+type GetTaskPackagesParams struct {
+	Instance   string
+	LastTaskID int64
+}
+
+func (q *Queries) GetTaskPackages(ctx context.Context, arg GetTaskPackagesParams) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, getTaskPackages, arg.Instance, arg.LastTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var package_ string
+		if err := rows.Scan(&package_); err != nil {
+			return nil, err
+		}
+		items = append(items, package_)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskRefs = `-- name: GetTaskRefs :many
+SELECT instance, task_id, name, target FROM task_refs
+WHERE instance = ? AND task_id = ?
+ORDER BY name
+`
+
+type GetTaskRefsParams struct {
+	Instance string
+	TaskID   int64
+}
+
+func (q *Queries) GetTaskRefs(ctx context.Context, arg GetTaskRefsParams) ([]TaskRef, error) {
+	rows, err := q.db.QueryContext(ctx, getTaskRefs, arg.Instance, arg.TaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TaskRef
+	for rows.Next() {
+		var i TaskRef
+		if err := rows.Scan(
+			&i.Instance,
+			&i.TaskID,
+			&i.Name,
+			&i.Target,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTaskVersions = `-- name: GetTaskVersions :many
+SELECT versions.package, versions.version_code, versions.version_name, versions.added, versions.file_name, versions.file_sha256, versions.src_name, versions.src_sha256, versions.tag, versions.revision_swhid, versions.mapped_at, versions.src_cnt_swhid, versions.src_dir_swhid, versions.src_hashed_at, versions.src_error, versions.apk_mirrored, versions.src_mirrored, versions.mirrored_at, versions.mirror_error FROM version_tasks
+JOIN versions ON versions.package = version_tasks.package AND versions.version_code = version_tasks.version_code
+WHERE version_tasks.instance = ? AND version_tasks.last_task_id = ?
+`
+
+type GetTaskVersionsParams struct {
+	Instance   string
+	LastTaskID int64
+}
+
+func (q *Queries) GetTaskVersions(ctx context.Context, arg GetTaskVersionsParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getTaskVersions, arg.Instance, arg.LastTaskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTasksByOrigin = `-- name: GetTasksByOrigin :many
+SELECT instance, id, save_request_status, save_task_status, snapshot_swhid, origin_url, visit_type, save_request_date, visit_date, visit_status, loading_task_id, note, request_url, raw, created_at, updated_at, finished_at, next_poll_at, poll_failures, abandoned_at, abandon_reason FROM tasks
+WHERE instance = ? AND origin_url = ?
+ORDER BY id DESC LIMIT ?
+`
+
+type GetTasksByOriginParams struct {
+	Instance  string
+	OriginUrl string
+	Limit     int64
+}
+
+func (q *Queries) GetTasksByOrigin(ctx context.Context, arg GetTasksByOriginParams) ([]Task, error) {
+	rows, err := q.db.QueryContext(ctx, getTasksByOrigin, arg.Instance, arg.OriginUrl, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.Instance,
+			&i.ID,
+			&i.SaveRequestStatus,
+			&i.SaveTaskStatus,
+			&i.SnapshotSwhid,
+			&i.OriginUrl,
+			&i.VisitType,
+			&i.SaveRequestDate,
+			&i.VisitDate,
+			&i.VisitStatus,
+			&i.LoadingTaskID,
+			&i.Note,
+			&i.RequestUrl,
+			&i.Raw,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.FinishedAt,
+			&i.NextPollAt,
+			&i.PollFailures,
+			&i.AbandonedAt,
+			&i.AbandonReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUncheckedRevisions = `-- name: GetUncheckedRevisions :many
+SELECT versions.revision_swhid FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid
+WHERE versions.revision_swhid IS NOT NULL
+    AND (known_swhids.swhid IS NULL OR (known_swhids.known = 0 AND known_swhids.checked_at < ?))
+GROUP BY versions.revision_swhid
+LIMIT ?
+`
+
+type GetUncheckedRevisionsParams struct {
+	Instance  string
+	CheckedAt int64
+	Limit     int64
+}
+
+func (q *Queries) GetUncheckedRevisions(ctx context.Context, arg GetUncheckedRevisionsParams) ([]sql.NullString, error) {
+	rows, err := q.db.QueryContext(ctx, getUncheckedRevisions, arg.Instance, arg.CheckedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []sql.NullString
+	for rows.Next() {
+		var revisionSwhid sql.NullString
+		if err := rows.Scan(&revisionSwhid); err != nil {
+			return nil, err
+		}
+		items = append(items, revisionSwhid)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUncheckedSources = `-- name: GetUncheckedSources :many
+SELECT versions.package, versions.version_code, versions.version_name, versions.added, versions.file_name, versions.file_sha256, versions.src_name, versions.src_sha256, versions.tag, versions.revision_swhid, versions.mapped_at, versions.src_cnt_swhid, versions.src_dir_swhid, versions.src_hashed_at, versions.src_error, versions.apk_mirrored, versions.src_mirrored, versions.mirrored_at, versions.mirror_error FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.src_dir_swhid
+WHERE versions.src_dir_swhid IS NOT NULL
+    AND (known_swhids.swhid IS NULL OR (known_swhids.known = 0 AND known_swhids.checked_at < ?))
+LIMIT ?
+`
+
+type GetUncheckedSourcesParams struct {
+	Instance  string
+	CheckedAt int64
+	Limit     int64
+}
+
+func (q *Queries) GetUncheckedSources(ctx context.Context, arg GetUncheckedSourcesParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getUncheckedSources, arg.Instance, arg.CheckedAt, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnreferencedMirrorBlobs = `-- name: GetUnreferencedMirrorBlobs :many
+SELECT sha256, size, stored_at, verified_at FROM mirror_blobs
+WHERE NOT EXISTS (SELECT 1 FROM versions WHERE versions.apk_mirrored AND versions.file_sha256 = mirror_blobs.sha256)
+AND NOT EXISTS (SELECT 1 FROM versions WHERE versions.src_mirrored AND versions.src_sha256 = mirror_blobs.sha256)
+`
+
+func (q *Queries) GetUnreferencedMirrorBlobs(ctx context.Context) ([]MirrorBlob, error) {
+	rows, err := q.db.QueryContext(ctx, getUnreferencedMirrorBlobs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []MirrorBlob
+	for rows.Next() {
+		var i MirrorBlob
+		if err := rows.Scan(
+			&i.Sha256,
+			&i.Size,
+			&i.StoredAt,
+			&i.VerifiedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUnverifiedTasks = `-- name: GetUnverifiedTasks :many
+SELECT tasks.instance, tasks.id, tasks.save_request_status, tasks.save_task_status, tasks.snapshot_swhid, tasks.origin_url, tasks.visit_type, tasks.save_request_date, tasks.visit_date, tasks.visit_status, tasks.loading_task_id, tasks.note, tasks.request_url, tasks.raw, tasks.created_at, tasks.updated_at, tasks.finished_at, tasks.next_poll_at, tasks.poll_failures, tasks.abandoned_at, tasks.abandon_reason FROM tasks
+LEFT JOIN snapshot_checks ON snapshot_checks.instance = tasks.instance AND snapshot_checks.task_id = tasks.id
+WHERE tasks.instance = ? AND tasks.save_task_status = 'succeeded'
+    AND tasks.snapshot_swhid IS NOT NULL AND snapshot_checks.task_id IS NULL
+    AND EXISTS (SELECT 1 FROM task_refs WHERE task_refs.instance = tasks.instance AND task_refs.task_id = tasks.id)
+ORDER BY tasks.finished_at LIMIT ?
+`
+
+type GetUnverifiedTasksParams struct {
+	Instance string
+	Limit    int64
+}
+
+func (q *Queries) GetUnverifiedTasks(ctx context.Context, arg GetUnverifiedTasksParams) ([]Task, error) {
+	rows, err := q.db.QueryContext(ctx, getUnverifiedTasks, arg.Instance, arg.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Task
+	for rows.Next() {
+		var i Task
+		if err := rows.Scan(
+			&i.Instance,
+			&i.ID,
+			&i.SaveRequestStatus,
+			&i.SaveTaskStatus,
+			&i.SnapshotSwhid,
+			&i.OriginUrl,
+			&i.VisitType,
+			&i.SaveRequestDate,
+			&i.VisitDate,
+			&i.VisitStatus,
+			&i.LoadingTaskID,
+			&i.Note,
+			&i.RequestUrl,
+			&i.Raw,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.FinishedAt,
+			&i.NextPollAt,
+			&i.PollFailures,
+			&i.AbandonedAt,
+			&i.AbandonReason,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getUpstreamRefs = `-- name: GetUpstreamRefs :many
+SELECT origin_url, name, target, seen_at FROM upstream_refs
+WHERE origin_url = ?
+ORDER BY name
+`
+
+func (q *Queries) GetUpstreamRefs(ctx context.Context, originUrl string) ([]UpstreamRef, error) {
+	rows, err := q.db.QueryContext(ctx, getUpstreamRefs, originUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []UpstreamRef
+	for rows.Next() {
+		var i UpstreamRef
+		if err := rows.Scan(
+			&i.OriginUrl,
+			&i.Name,
+			&i.Target,
+			&i.SeenAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getVersionByTag = `-- name: GetVersionByTag :one
+SELECT package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256, tag, revision_swhid, mapped_at, src_cnt_swhid, src_dir_swhid, src_hashed_at, src_error, apk_mirrored, src_mirrored, mirrored_at, mirror_error FROM versions WHERE package = ? AND tag = ? LIMIT 1
+`
+
+type GetVersionByTagParams struct {
+	Package string
+	Tag     sql.NullString
+}
+
+func (q *Queries) GetVersionByTag(ctx context.Context, arg GetVersionByTagParams) (Version, error) {
+	row := q.db.QueryRowContext(ctx, getVersionByTag, arg.Package, arg.Tag)
+	var i Version
+	err := row.Scan(
+		&i.Package,
+		&i.VersionCode,
+		&i.VersionName,
+		&i.Added,
+		&i.FileName,
+		&i.FileSha256,
+		&i.SrcName,
+		&i.SrcSha256,
+		&i.Tag,
+		&i.RevisionSwhid,
+		&i.MappedAt,
+		&i.SrcCntSwhid,
+		&i.SrcDirSwhid,
+		&i.SrcHashedAt,
+		&i.SrcError,
+		&i.ApkMirrored,
+		&i.SrcMirrored,
+		&i.MirroredAt,
+		&i.MirrorError,
+	)
+	return i, err
+}
+
+const getVersionSaveRetry = `-- name: GetVersionSaveRetry :one
+SELECT package, version_code, instance, failures, next_attempt_at, last_error_class, gave_up_at FROM version_save_retries
+WHERE package = ? AND version_code = ? AND instance = ?
+`
+
+type GetVersionSaveRetryParams struct {
+	Package     string
+	VersionCode int64
+	Instance    string
+}
+
+func (q *Queries) GetVersionSaveRetry(ctx context.Context, arg GetVersionSaveRetryParams) (VersionSaveRetry, error) {
+	row := q.db.QueryRowContext(ctx, getVersionSaveRetry, arg.Package, arg.VersionCode, arg.Instance)
+	var i VersionSaveRetry
+	err := row.Scan(
+		&i.Package,
+		&i.VersionCode,
+		&i.Instance,
+		&i.Failures,
+		&i.NextAttemptAt,
+		&i.LastErrorClass,
+		&i.GaveUpAt,
+	)
+	return i, err
+}
+
+const getVersionTask = `-- name: GetVersionTask :one
+SELECT tasks.instance, tasks.id, tasks.save_request_status, tasks.save_task_status, tasks.snapshot_swhid, tasks.origin_url, tasks.visit_type, tasks.save_request_date, tasks.visit_date, tasks.visit_status, tasks.loading_task_id, tasks.note, tasks.request_url, tasks.raw, tasks.created_at, tasks.updated_at, tasks.finished_at, tasks.next_poll_at, tasks.poll_failures, tasks.abandoned_at, tasks.abandon_reason FROM version_tasks
+JOIN tasks ON tasks.instance = version_tasks.instance AND tasks.id = version_tasks.last_task_id
+WHERE version_tasks.package = ? AND version_tasks.version_code = ? AND version_tasks.instance = ? LIMIT 1
 `
 
-type CreateOrUpdateTaskParams struct {
-	ID                int64
-	SaveRequestStatus string
-	SaveTaskStatus    string
-	SnapshotSwhid     sql.NullString
+type GetVersionTaskParams struct {
+	Package     string
+	VersionCode int64
+	Instance    string
 }
 
-func (q *Queries) CreateOrUpdateTask(ctx context.Context, arg CreateOrUpdateTaskParams) error {
-	_, err := q.db.ExecContext(ctx, createOrUpdateTask,
-		arg.ID,
-		arg.SaveRequestStatus,
-		arg.SaveTaskStatus,
-		arg.SnapshotSwhid,
+func (q *Queries) GetVersionTask(ctx context.Context, arg GetVersionTaskParams) (Task, error) {
+	row := q.db.QueryRowContext(ctx, getVersionTask, arg.Package, arg.VersionCode, arg.Instance)
+	var i Task
+	err := row.Scan(
+		&i.Instance,
+		&i.ID,
+		&i.SaveRequestStatus,
+		&i.SaveTaskStatus,
+		&i.SnapshotSwhid,
+		&i.OriginUrl,
+		&i.VisitType,
+		&i.SaveRequestDate,
+		&i.VisitDate,
+		&i.VisitStatus,
+		&i.LoadingTaskID,
+		&i.Note,
+		&i.RequestUrl,
+		&i.Raw,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.FinishedAt,
+		&i.NextPollAt,
+		&i.PollFailures,
+		&i.AbandonedAt,
+		&i.AbandonReason,
 	)
-	return err
+	return i, err
 }
 
-const existApp = `-- name: ExistApp :one
-SELECT EXISTS(SELECT 1 FROM apps WHERE package = ?)
+const getVersionsBySource = `-- name: GetVersionsBySource :many
+SELECT versions.package, versions.version_code, versions.version_name, versions.added, versions.file_name, versions.file_sha256, versions.src_name, versions.src_sha256, versions.tag, versions.revision_swhid, versions.mapped_at, versions.src_cnt_swhid, versions.src_dir_swhid, versions.src_hashed_at, versions.src_error, versions.apk_mirrored, versions.src_mirrored, versions.mirrored_at, versions.mirror_error FROM versions
+JOIN apps ON apps.package = versions.package
+WHERE apps.origin_url = ?
 `
 
-func (q *Queries) ExistApp(ctx context.Context, package_ string) (int64, error) {
-	row := q.db.QueryRowContext(ctx, existApp, package_)
-	var column_1 int64
-	err := row.Scan(&column_1)
-	return column_1, err
+func (q *Queries) GetVersionsBySource(ctx context.Context, originUrl sql.NullString) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getVersionsBySource, originUrl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Version
+	for rows.Next() {
+		var i Version
+		if err := rows.Scan(
+			&i.Package,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
-const getAllApps = `-- name: GetAllApps :many
-SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, last_task_id FROM apps_ordered
-WHERE package LIKE ? LIMIT ? OFFSET ?
+const getVersionsToHash = `-- name: GetVersionsToHash :many
+SELECT package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256, tag, revision_swhid, mapped_at, src_cnt_swhid, src_dir_swhid, src_hashed_at, src_error, apk_mirrored, src_mirrored, mirrored_at, mirror_error FROM versions
+WHERE src_name IS NOT NULL AND src_dir_swhid IS NULL AND src_hashed_at < ?
+ORDER BY added DESC LIMIT ?
 `
 
-type GetAllAppsParams struct {
-	Package string
-	Limit   int64
-	Offset  int64
+type GetVersionsToHashParams struct {
+	SrcHashedAt int64
+	Limit       int64
 }
 
-func (q *Queries) GetAllApps(ctx context.Context, arg GetAllAppsParams) ([]AppsOrdered, error) {
-	rows, err := q.db.QueryContext(ctx, getAllApps, arg.Package, arg.Limit, arg.Offset)
+func (q *Queries) GetVersionsToHash(ctx context.Context, arg GetVersionsToHashParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getVersionsToHash, arg.SrcHashedAt, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []AppsOrdered
+	var items []Version
 	for rows.Next() {
-		var i AppsOrdered
+		var i Version
 		if err := rows.Scan(
 			&i.Package,
-			&i.MetaAdded,
-			&i.MetaLastUpdated,
-			&i.MetaSourceCode,
-			&i.LastSaveTriggered,
-			&i.LastTaskID,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
 		); err != nil {
 			return nil, err
 		}
@@ -135,46 +2998,48 @@ func (q *Queries) GetAllApps(ctx context.Context, arg GetAllAppsParams) ([]AppsO
 	return items, nil
 }
 
-const getApp = `-- name: GetApp :one
-SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, last_task_id FROM apps
-WHERE package = ? LIMIT 1
+const getVersionsToMirror = `-- name: GetVersionsToMirror :many
+SELECT package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256, tag, revision_swhid, mapped_at, src_cnt_swhid, src_dir_swhid, src_hashed_at, src_error, apk_mirrored, src_mirrored, mirrored_at, mirror_error FROM versions
+WHERE (NOT apk_mirrored OR (src_name IS NOT NULL AND NOT src_mirrored)) AND mirrored_at < ?
+AND (SELECT COUNT(*) FROM versions AS newer WHERE newer.package = versions.package AND newer.version_code > versions.version_code) < ?
+ORDER BY added DESC LIMIT ?
 `
 
-func (q *Queries) GetApp(ctx context.Context, package_ string) (App, error) {
-	row := q.db.QueryRowContext(ctx, getApp, package_)
-	var i App
-	err := row.Scan(
-		&i.Package,
-		&i.MetaAdded,
-		&i.MetaLastUpdated,
-		&i.MetaSourceCode,
-		&i.LastSaveTriggered,
-		&i.LastTaskID,
-	)
-	return i, err
+type GetVersionsToMirrorParams struct {
+	MirroredAt int64
+	Keep       interface{}
+	Limit      int64
 }
 
-const getAppNeedSave = `-- name: GetAppNeedSave :many
-SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered, last_task_id FROM apps_ordered
-WHERE meta_last_updated > last_save_triggered LIMIT ?
-`
-
-func (q *Queries) GetAppNeedSave(ctx context.Context, limit int64) ([]AppsOrdered, error) {
-	rows, err := q.db.QueryContext(ctx, getAppNeedSave, limit)
+func (q *Queries) GetVersionsToMirror(ctx context.Context, arg GetVersionsToMirrorParams) ([]Version, error) {
+	rows, err := q.db.QueryContext(ctx, getVersionsToMirror, arg.MirroredAt, arg.Keep, arg.Limit)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	var items []AppsOrdered
+	var items []Version
 	for rows.Next() {
-		var i AppsOrdered
+		var i Version
 		if err := rows.Scan(
 			&i.Package,
-			&i.MetaAdded,
-			&i.MetaLastUpdated,
-			&i.MetaSourceCode,
-			&i.LastSaveTriggered,
-			&i.LastTaskID,
+			&i.VersionCode,
+			&i.VersionName,
+			&i.Added,
+			&i.FileName,
+			&i.FileSha256,
+			&i.SrcName,
+			&i.SrcSha256,
+			&i.Tag,
+			&i.RevisionSwhid,
+			&i.MappedAt,
+			&i.SrcCntSwhid,
+			&i.SrcDirSwhid,
+			&i.SrcHashedAt,
+			&i.SrcError,
+			&i.ApkMirrored,
+			&i.SrcMirrored,
+			&i.MirroredAt,
+			&i.MirrorError,
 		); err != nil {
 			return nil, err
 		}
@@ -189,50 +3054,197 @@ func (q *Queries) GetAppNeedSave(ctx context.Context, limit int64) ([]AppsOrdere
 	return items, nil
 }
 
-const getTask = `-- name: GetTask :one
-SELECT id, save_request_status, save_task_status, snapshot_swhid FROM tasks
-WHERE id = ? LIMIT 1
+const isOriginQueued = `-- name: IsOriginQueued :one
+SELECT EXISTS(
+    SELECT 1 FROM queue JOIN apps ON apps.package = queue.package
+    WHERE queue.archiver = ? AND apps.origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?)
+) AS queued
 `
 
-func (q *Queries) GetTask(ctx context.Context, id int64) (Task, error) {
-	row := q.db.QueryRowContext(ctx, getTask, id)
-	var i Task
-	err := row.Scan(
-		&i.ID,
-		&i.SaveRequestStatus,
-		&i.SaveTaskStatus,
-		&i.SnapshotSwhid,
+type IsOriginQueuedParams struct {
+	Archiver string
+	Package  string
+}
+
+func (q *Queries) IsOriginQueued(ctx context.Context, arg IsOriginQueuedParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, isOriginQueued, arg.Archiver, arg.Package)
+	var queued int64
+	err := row.Scan(&queued)
+	return queued, err
+}
+
+const isPaused = `-- name: IsPaused :one
+SELECT EXISTS(SELECT 1 FROM pauses WHERE component = ?) AS paused
+`
+
+func (q *Queries) IsPaused(ctx context.Context, component string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, isPaused, component)
+	var paused int64
+	err := row.Scan(&paused)
+	return paused, err
+}
+
+const markDelistedApps = `-- name: MarkDelistedApps :execrows
+UPDATE apps SET delisted_at = ?
+WHERE delisted_at IS NULL AND listed_at < ?
+`
+
+type MarkDelistedAppsParams struct {
+	DelistedAt int64
+	ListedAt   int64
+}
+
+func (q *Queries) MarkDelistedApps(ctx context.Context, arg MarkDelistedAppsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, markDelistedApps, arg.DelistedAt, arg.ListedAt)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const pauseComponent = `-- name: PauseComponent :execrows
+INSERT INTO pauses (component, actor, reason, paused_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(component) DO NOTHING
+`
+
+type PauseComponentParams struct {
+	Component string
+	Actor     string
+	Reason    string
+	PausedAt  int64
+}
+
+func (q *Queries) PauseComponent(ctx context.Context, arg PauseComponentParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, pauseComponent,
+		arg.Component,
+		arg.Actor,
+		arg.Reason,
+		arg.PausedAt,
 	)
-	return i, err
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
 
-const updateLastSaveTriggered = `-- name: UpdateLastSaveTriggered :exec
-UPDATE apps SET last_save_triggered = ?
+const releaseQueueClaims = `-- name: ReleaseQueueClaims :exec
+UPDATE queue SET claimed_at = 0
+`
+
+func (q *Queries) ReleaseQueueClaims(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, releaseQueueClaims)
+	return err
+}
+
+const requeueApp = `-- name: RequeueApp :exec
+UPDATE apps SET save_failures = 0, next_attempt_at = ?, gave_up_at = NULL
+WHERE package = ?
+`
+
+type RequeueAppParams struct {
+	NextAttemptAt int64
+	Package       string
+}
+
+func (q *Queries) RequeueApp(ctx context.Context, arg RequeueAppParams) error {
+	_, err := q.db.ExecContext(ctx, requeueApp, arg.NextAttemptAt, arg.Package)
+	return err
+}
+
+const resetApp = `-- name: ResetApp :exec
+UPDATE apps SET last_save_triggered = 0, save_failures = 0, next_attempt_at = 0, last_error_class = NULL, gave_up_at = NULL
+WHERE package = ?
+`
+
+func (q *Queries) ResetApp(ctx context.Context, package_ string) error {
+	_, err := q.db.ExecContext(ctx, resetApp, package_)
+	return err
+}
+
+const resetSaveFailures = `-- name: ResetSaveFailures :exec
+UPDATE apps SET save_failures = 0, next_attempt_at = 0, last_error_class = NULL, gave_up_at = NULL
+WHERE package = ?
+`
+
+func (q *Queries) ResetSaveFailures(ctx context.Context, package_ string) error {
+	_, err := q.db.ExecContext(ctx, resetSaveFailures, package_)
+	return err
+}
+
+const resetVersionSaveRetries = `-- name: ResetVersionSaveRetries :exec
+DELETE FROM version_save_retries
 WHERE package = ?
 `
 
+func (q *Queries) ResetVersionSaveRetries(ctx context.Context, package_ string) error {
+	_, err := q.db.ExecContext(ctx, resetVersionSaveRetries, package_)
+	return err
+}
+
+const resumeComponent = `-- name: ResumeComponent :execrows
+DELETE FROM pauses
+WHERE component = ?
+`
+
+func (q *Queries) ResumeComponent(ctx context.Context, component string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, resumeComponent, component)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unmirrorApk = `-- name: UnmirrorApk :exec
+UPDATE versions SET apk_mirrored = FALSE, mirrored_at = 0 WHERE file_sha256 = ?
+`
+
+func (q *Queries) UnmirrorApk(ctx context.Context, fileSha256 string) error {
+	_, err := q.db.ExecContext(ctx, unmirrorApk, fileSha256)
+	return err
+}
+
+const unmirrorSource = `-- name: UnmirrorSource :exec
+UPDATE versions SET src_mirrored = FALSE, mirrored_at = 0 WHERE src_sha256 = ?
+`
+
+func (q *Queries) UnmirrorSource(ctx context.Context, srcSha256 sql.NullString) error {
+	_, err := q.db.ExecContext(ctx, unmirrorSource, srcSha256)
+	return err
+}
+
+const updateLastSaveTriggered = `-- name: UpdateLastSaveTriggered :exec
+UPDATE apps SET last_save_triggered = ?, next_attempt_at = 0
+-- the save covers every app sharing the origin
+WHERE package = ? OR origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?)
+`
+
 type UpdateLastSaveTriggeredParams struct {
 	LastSaveTriggered int64
 	Package           string
+	Package_2         string
 }
 
 func (q *Queries) UpdateLastSaveTriggered(ctx context.Context, arg UpdateLastSaveTriggeredParams) error {
-	_, err := q.db.ExecContext(ctx, updateLastSaveTriggered, arg.LastSaveTriggered, arg.Package)
+	_, err := q.db.ExecContext(ctx, updateLastSaveTriggered, arg.LastSaveTriggered, arg.Package, arg.Package_2)
 	return err
 }
 
 const updateLastTaskId = `-- name: UpdateLastTaskId :exec
-UPDATE apps SET last_task_id = ?
-WHERE package = ?
+INSERT INTO app_tasks (package, instance, last_task_id)
+VALUES (?, ?, ?)
+ON CONFLICT(package, instance) DO UPDATE SET
+    last_task_id = excluded.last_task_id
 `
 
 type UpdateLastTaskIdParams struct {
-	LastTaskID sql.NullInt64
 	Package    string
+	Instance   string
+	LastTaskID int64
 }
 
 func (q *Queries) UpdateLastTaskId(ctx context.Context, arg UpdateLastTaskIdParams) error {
-	_, err := q.db.ExecContext(ctx, updateLastTaskId, arg.LastTaskID, arg.Package)
+	_, err := q.db.ExecContext(ctx, updateLastTaskId, arg.Package, arg.Instance, arg.LastTaskID)
 	return err
 }
 
@@ -257,3 +3269,250 @@ func (q *Queries) UpdateMeta(ctx context.Context, arg UpdateMetaParams) error {
 	)
 	return err
 }
+
+const updateMirrorBlobVerified = `-- name: UpdateMirrorBlobVerified :exec
+UPDATE mirror_blobs SET verified_at = ? WHERE sha256 = ?
+`
+
+type UpdateMirrorBlobVerifiedParams struct {
+	VerifiedAt int64
+	Sha256     string
+}
+
+func (q *Queries) UpdateMirrorBlobVerified(ctx context.Context, arg UpdateMirrorBlobVerifiedParams) error {
+	_, err := q.db.ExecContext(ctx, updateMirrorBlobVerified, arg.VerifiedAt, arg.Sha256)
+	return err
+}
+
+const updateOriginRefsChecked = `-- name: UpdateOriginRefsChecked :exec
+UPDATE origins SET refs_checked_at = ? WHERE url = ?
+`
+
+type UpdateOriginRefsCheckedParams struct {
+	RefsCheckedAt int64
+	Url           string
+}
+
+func (q *Queries) UpdateOriginRefsChecked(ctx context.Context, arg UpdateOriginRefsCheckedParams) error {
+	_, err := q.db.ExecContext(ctx, updateOriginRefsChecked, arg.RefsCheckedAt, arg.Url)
+	return err
+}
+
+const updateRestoreTest = `-- name: UpdateRestoreTest :exec
+UPDATE restore_tests SET status = ?, checked_at = ?, bytes = ?, error = ?
+WHERE id = ?
+`
+
+type UpdateRestoreTestParams struct {
+	Status    string
+	CheckedAt sql.NullInt64
+	Bytes     sql.NullInt64
+	Error     sql.NullString
+	ID        int64
+}
+
+func (q *Queries) UpdateRestoreTest(ctx context.Context, arg UpdateRestoreTestParams) error {
+	_, err := q.db.ExecContext(ctx, updateRestoreTest,
+		arg.Status,
+		arg.CheckedAt,
+		arg.Bytes,
+		arg.Error,
+		arg.ID,
+	)
+	return err
+}
+
+const updateSaveFailure = `-- name: UpdateSaveFailure :exec
+UPDATE apps SET save_failures = ?, next_attempt_at = ?, last_error_class = ?, gave_up_at = ?
+WHERE package = ?
+`
+
+type UpdateSaveFailureParams struct {
+	SaveFailures   int64
+	NextAttemptAt  int64
+	LastErrorClass sql.NullString
+	GaveUpAt       sql.NullInt64
+	Package        string
+}
+
+func (q *Queries) UpdateSaveFailure(ctx context.Context, arg UpdateSaveFailureParams) error {
+	_, err := q.db.ExecContext(ctx, updateSaveFailure,
+		arg.SaveFailures,
+		arg.NextAttemptAt,
+		arg.LastErrorClass,
+		arg.GaveUpAt,
+		arg.Package,
+	)
+	return err
+}
+
+const updateTaskPollSchedule = `-- name: UpdateTaskPollSchedule :exec
+UPDATE tasks SET next_poll_at = ?, poll_failures = ?
+WHERE instance = ? AND id = ?
+`
+
+type UpdateTaskPollScheduleParams struct {
+	NextPollAt   int64
+	PollFailures int64
+	Instance     string
+	ID           int64
+}
+
+func (q *Queries) UpdateTaskPollSchedule(ctx context.Context, arg UpdateTaskPollScheduleParams) error {
+	_, err := q.db.ExecContext(ctx, updateTaskPollSchedule,
+		arg.NextPollAt,
+		arg.PollFailures,
+		arg.Instance,
+		arg.ID,
+	)
+	return err
+}
+
+const updateVersionMirror = `-- name: UpdateVersionMirror :exec
+UPDATE versions SET apk_mirrored = ?, src_mirrored = ?, mirrored_at = ?, mirror_error = ?
+WHERE package = ? AND version_code = ?
+`
+
+type UpdateVersionMirrorParams struct {
+	ApkMirrored bool
+	SrcMirrored bool
+	MirroredAt  int64
+	MirrorError sql.NullString
+	Package     string
+	VersionCode int64
+}
+
+func (q *Queries) UpdateVersionMirror(ctx context.Context, arg UpdateVersionMirrorParams) error {
+	_, err := q.db.ExecContext(ctx, updateVersionMirror,
+		arg.ApkMirrored,
+		arg.SrcMirrored,
+		arg.MirroredAt,
+		arg.MirrorError,
+		arg.Package,
+		arg.VersionCode,
+	)
+	return err
+}
+
+const updateVersionRevision = `-- name: UpdateVersionRevision :exec
+UPDATE versions SET tag = ?, revision_swhid = ?, mapped_at = ?
+WHERE package = ? AND version_code = ?
+`
+
+type UpdateVersionRevisionParams struct {
+	Tag           sql.NullString
+	RevisionSwhid sql.NullString
+	MappedAt      int64
+	Package       string
+	VersionCode   int64
+}
+
+func (q *Queries) UpdateVersionRevision(ctx context.Context, arg UpdateVersionRevisionParams) error {
+	_, err := q.db.ExecContext(ctx, updateVersionRevision,
+		arg.Tag,
+		arg.RevisionSwhid,
+		arg.MappedAt,
+		arg.Package,
+		arg.VersionCode,
+	)
+	return err
+}
+
+const updateVersionSaveFailure = `-- name: UpdateVersionSaveFailure :exec
+INSERT INTO version_save_retries (package, version_code, instance, failures, next_attempt_at, last_error_class, gave_up_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(package, version_code, instance) DO UPDATE SET
+    failures = excluded.failures,
+    next_attempt_at = excluded.next_attempt_at,
+    last_error_class = excluded.last_error_class,
+    gave_up_at = excluded.gave_up_at
+`
+
+type UpdateVersionSaveFailureParams struct {
+	Package        string
+	VersionCode    int64
+	Instance       string
+	Failures       int64
+	NextAttemptAt  int64
+	LastErrorClass sql.NullString
+	GaveUpAt       sql.NullInt64
+}
+
+func (q *Queries) UpdateVersionSaveFailure(ctx context.Context, arg UpdateVersionSaveFailureParams) error {
+	_, err := q.db.ExecContext(ctx, updateVersionSaveFailure,
+		arg.Package,
+		arg.VersionCode,
+		arg.Instance,
+		arg.Failures,
+		arg.NextAttemptAt,
+		arg.LastErrorClass,
+		arg.GaveUpAt,
+	)
+	return err
+}
+
+const updateVersionSourceSwhids = `-- name: UpdateVersionSourceSwhids :exec
+UPDATE versions SET src_cnt_swhid = ?, src_dir_swhid = ?, src_hashed_at = ?, src_error = ?
+WHERE package = ? AND version_code = ?
+`
+
+type UpdateVersionSourceSwhidsParams struct {
+	SrcCntSwhid sql.NullString
+	SrcDirSwhid sql.NullString
+	SrcHashedAt int64
+	SrcError    sql.NullString
+	Package     string
+	VersionCode int64
+}
+
+func (q *Queries) UpdateVersionSourceSwhids(ctx context.Context, arg UpdateVersionSourceSwhidsParams) error {
+	_, err := q.db.ExecContext(ctx, updateVersionSourceSwhids,
+		arg.SrcCntSwhid,
+		arg.SrcDirSwhid,
+		arg.SrcHashedAt,
+		arg.SrcError,
+		arg.Package,
+		arg.VersionCode,
+	)
+	return err
+}
+
+const updateVersionTask = `-- name: UpdateVersionTask :exec
+INSERT INTO version_tasks (package, version_code, instance, last_task_id)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(package, version_code, instance) DO UPDATE SET
+    last_task_id = excluded.last_task_id
+`
+
+type UpdateVersionTaskParams struct {
+	Package     string
+	VersionCode int64
+	Instance    string
+	LastTaskID  int64
+}
+
+func (q *Queries) UpdateVersionTask(ctx context.Context, arg UpdateVersionTaskParams) error {
+	_, err := q.db.ExecContext(ctx, updateVersionTask,
+		arg.Package,
+		arg.VersionCode,
+		arg.Instance,
+		arg.LastTaskID,
+	)
+	return err
+}
+
+const updateVisitFetch = `-- name: UpdateVisitFetch :exec
+INSERT INTO visit_fetches (instance, origin_url, fetched_at) VALUES (?, ?, ?)
+ON CONFLICT (instance, origin_url) DO UPDATE SET fetched_at = excluded.fetched_at
+`
+
+type UpdateVisitFetchParams struct {
+	Instance  string
+	OriginUrl string
+	FetchedAt int64
+}
+
+func (q *Queries) UpdateVisitFetch(ctx context.Context, arg UpdateVisitFetchParams) error {
+	_, err := q.db.ExecContext(ctx, updateVisitFetch, arg.Instance, arg.OriginUrl, arg.FetchedAt)
+	return err
+}
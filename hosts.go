@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Forges are reached through forgeHosts, which keeps every host polite:
+// FORGE_HOST_CONCURRENCY requests at a time, FORGE_HOST_DELAY_MS between
+// their starts. After FORGE_BREAKER_FAILURES connection errors or 5xx in a
+// row the host's circuit opens for FORGE_BREAKER_COOLDOWN_MIN; then one
+// request goes through to probe it, and the cooldown doubles each time the
+// probe fails.
+var (
+	FORGE_HOST_CONCURRENCY     = 2
+	FORGE_HOST_DELAY_MS        = 500
+	FORGE_BREAKER_FAILURES     = 5
+	FORGE_BREAKER_COOLDOWN_MIN = 10
+)
+
+const breakerMaxCooldown = 6 * time.Hour
+
+func init() {
+	for env, v := range map[string]*int{
+		"FORGE_HOST_CONCURRENCY":     &FORGE_HOST_CONCURRENCY,
+		"FORGE_HOST_DELAY_MS":        &FORGE_HOST_DELAY_MS,
+		"FORGE_BREAKER_FAILURES":     &FORGE_BREAKER_FAILURES,
+		"FORGE_BREAKER_COOLDOWN_MIN": &FORGE_BREAKER_COOLDOWN_MIN,
+	} {
+		if n, err := strconv.Atoi(os.Getenv(env)); err == nil && n >= 0 {
+			*v = n
+		}
+	}
+	FORGE_HOST_CONCURRENCY = max(FORGE_HOST_CONCURRENCY, 1)
+	FORGE_BREAKER_FAILURES = max(FORGE_BREAKER_FAILURES, 1)
+}
+
+// HostUnavailableError is returned for requests to a host whose circuit is
+// open or that asked us to come back later.
+type HostUnavailableError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *HostUnavailableError) Error() string {
+	return fmt.Sprintf("host %s unavailable until %s", e.Host, e.Until.Format(time.DateTime))
+}
+
+type hostState struct {
+	slots     chan struct{}
+	lastStart time.Time
+	// connection errors and 5xx in a row
+	failures int
+	// no request goes out before this: open circuit or Retry-After
+	heldUntil time.Time
+	cooldown  time.Duration
+	// the request probing a half-open circuit is in flight
+	probing   bool
+	requests  int64
+	lastError string
+	lastErrAt time.Time
+}
+
+type hostLimiter struct {
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+var forgeHosts = &hostLimiter{hosts: map[string]*hostState{}}
+
+// forgeClient is client with its requests going through forgeHosts.
+func forgeClient(client *http.Client) *http.Client {
+	next := client.Transport
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	c := *client
+	c.Transport = &limitedTransport{limiter: forgeHosts, next: next}
+	return &c
+}
+
+type limitedTransport struct {
+	limiter *hostLimiter
+	next    http.RoundTripper
+}
+
+// RoundTrip holds the slot until the response headers are in; the bodies
+// read from forges are small.
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	h, err := t.limiter.acquire(req.Context(), host)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.next.RoundTrip(req)
+	if until := t.limiter.release(h, host, resp, err, time.Now()); !until.IsZero() {
+		// the host asked us to back off: callers must not mistake the
+		// response for what they asked for
+		resp.Body.Close()
+		return nil, &HostUnavailableError{Host: host, Until: until}
+	}
+	return resp, err
+}
+
+func (l *hostLimiter) host(name string) *hostState {
+	h, ok := l.hosts[name]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, FORGE_HOST_CONCURRENCY)}
+		l.hosts[name] = h
+	}
+	return h
+}
+
+// acquire waits for a slot on host and for the politeness delay, or fails
+// right away if the host is unavailable.
+func (l *hostLimiter) acquire(ctx context.Context, name string) (*hostState, error) {
+	l.mu.Lock()
+	h := l.host(name)
+	now := time.Now()
+	if now.Before(h.heldUntil) {
+		l.mu.Unlock()
+		return nil, &HostUnavailableError{Host: name, Until: h.heldUntil}
+	}
+	if h.failures >= FORGE_BREAKER_FAILURES {
+		// half-open: a single request probes the host
+		if h.probing {
+			l.mu.Unlock()
+			return nil, &HostUnavailableError{Host: name, Until: now.Add(h.cooldown)}
+		}
+		h.probing = true
+	}
+	l.mu.Unlock()
+
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		l.mu.Lock()
+		h.probing = false
+		l.mu.Unlock()
+		return nil, ctx.Err()
+	}
+
+	l.mu.Lock()
+	start := h.lastStart.Add(time.Duration(FORGE_HOST_DELAY_MS) * time.Millisecond)
+	if now := time.Now(); start.Before(now) {
+		start = now
+	}
+	h.lastStart = start
+	l.mu.Unlock()
+	if wait := time.Until(start); wait > 0 {
+		sleepCtx(ctx, wait)
+	}
+	return h, nil
+}
+
+// release frees the slot and records how the request went. It returns when
+// the host may be asked again if it told us to back off.
+func (l *hostLimiter) release(h *hostState, name string, resp *http.Response, err error, now time.Time) time.Time {
+	defer func() { <-h.slots }()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	probe := h.probing
+	h.probing = false
+	if errors.Is(err, context.Canceled) {
+		return time.Time{}
+	}
+	h.requests++
+
+	if err != nil || resp.StatusCode >= 500 {
+		if err != nil {
+			h.lastError = err.Error()
+		} else {
+			h.lastError = resp.Status
+		}
+		h.lastErrAt = now
+		h.failures++
+		if h.failures >= FORGE_BREAKER_FAILURES {
+			switch {
+			case h.cooldown == 0:
+				h.cooldown = time.Duration(FORGE_BREAKER_COOLDOWN_MIN) * time.Minute
+			case probe:
+				h.cooldown = min(h.cooldown*2, breakerMaxCooldown)
+			}
+			if h.heldUntil.Before(now.Add(h.cooldown)) {
+				h.heldUntil = now.Add(h.cooldown)
+			}
+			if probe || h.failures == FORGE_BREAKER_FAILURES {
+				slog.Warn("host circuit open", "host", name, "failures", h.failures, "until", h.heldUntil.Format(time.DateTime))
+			}
+		}
+	} else {
+		if h.failures >= FORGE_BREAKER_FAILURES {
+			slog.Info("host circuit closed", "host", name)
+		}
+		h.failures = 0
+		h.cooldown = 0
+	}
+
+	if resp == nil || resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return time.Time{}
+	}
+	until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if !ok {
+		if resp.StatusCode != http.StatusTooManyRequests {
+			return time.Time{}
+		}
+		until = now.Add(time.Minute)
+	}
+	if until.After(h.heldUntil) {
+		h.heldUntil = until
+	}
+	slog.Warn("host asked us to back off", "host", name, "status", resp.StatusCode, "until", h.heldUntil.Format(time.DateTime))
+	return h.heldUntil
+}
+
+// HostStatus is the health of a forge host as shown on the dashboard.
+type HostStatus struct {
+	Host string
+	// ok, open, half-open or held (by Retry-After)
+	State     string
+	InFlight  int
+	Failures  int
+	HeldUntil string
+	Requests  int64
+	LastError string
+	LastErrAt string
+}
+
+// Status lists the hosts contacted so far, those in trouble first.
+func (l *hostLimiter) Status() []HostStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	var hosts []HostStatus
+	for name, h := range l.hosts {
+		s := HostStatus{
+			Host:      name,
+			State:     "ok",
+			InFlight:  len(h.slots),
+			Failures:  h.failures,
+			Requests:  h.requests,
+			LastError: h.lastError,
+			HeldUntil: "-",
+			LastErrAt: formatTime(h.lastErrAt),
+		}
+		switch {
+		case now.Before(h.heldUntil) && h.failures >= FORGE_BREAKER_FAILURES:
+			s.State = "open"
+		case now.Before(h.heldUntil):
+			s.State = "held"
+		case h.failures >= FORGE_BREAKER_FAILURES:
+			s.State = "half-open"
+		}
+		if now.Before(h.heldUntil) {
+			s.HeldUntil = formatTime(h.heldUntil)
+		}
+		hosts = append(hosts, s)
+	}
+	healthy := func(s HostStatus) int {
+		if s.State == "ok" {
+			return 1
+		}
+		return 0
+	}
+	slices.SortFunc(hosts, func(a, b HostStatus) int {
+		if c := cmp.Compare(healthy(a), healthy(b)); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Failures, a.Failures); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.Requests, a.Requests)
+	})
+	return hosts
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func Test_hostLimiter_breaker(t *testing.T) {
+	l := &hostLimiter{hosts: map[string]*hostState{}}
+	h := l.host("example.org")
+	now := time.Now()
+	fail := func(probe bool) {
+		h.probing = probe
+		h.slots <- struct{}{}
+		l.release(h, "example.org", nil, errors.New("connection refused"), now)
+	}
+	cooldown := time.Duration(FORGE_BREAKER_COOLDOWN_MIN) * time.Minute
+
+	for range FORGE_BREAKER_FAILURES - 1 {
+		fail(false)
+	}
+	if !h.heldUntil.IsZero() {
+		t.Fatalf("circuit open after %d failures", h.failures)
+	}
+	fail(false)
+	if !h.heldUntil.Equal(now.Add(cooldown)) {
+		t.Fatalf("heldUntil = %v, want %v", h.heldUntil, now.Add(cooldown))
+	}
+	fail(true)
+	if h.cooldown != 2*cooldown {
+		t.Errorf("cooldown after a failed probe = %v, want %v", h.cooldown, 2*cooldown)
+	}
+
+	h.probing = true
+	h.slots <- struct{}{}
+	l.release(h, "example.org", &http.Response{StatusCode: http.StatusOK}, nil, now)
+	if h.failures != 0 || h.cooldown != 0 {
+		t.Errorf("circuit still open after a successful probe: %d failures, cooldown %v", h.failures, h.cooldown)
+	}
+
+	later := h.heldUntil.Add(time.Minute)
+	h.slots <- struct{}{}
+	until := l.release(h, "example.org", &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": {"30"}},
+	}, nil, later)
+	if !until.Equal(later.Add(30 * time.Second)) {
+		t.Errorf("release after 429 = %v, want %v", until, later.Add(30*time.Second))
+	}
+}
@@ -2,22 +2,52 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"io"
 	"log/slog"
 	"os"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/saveweb/fdroidswh/db"
 )
 
-func createOrUpdatePkg(ctx context.Context, pkg string, info PackageInfo) error {
-	return dbWriteSqlc.CreateOrUpdateApp(ctx, db.CreateOrUpdateAppParams{
+func createOrUpdatePkg(ctx context.Context, pkg string, info PackageInfo, listedAt time.Time) error {
+	origin, err := createOrigin(ctx, info.Metadata.SourceCode, listedAt)
+	if err != nil {
+		return err
+	}
+	err = dbWriteSqlc.CreateOrUpdateApp(ctx, db.CreateOrUpdateAppParams{
 		Package:         pkg,
 		MetaAdded:       info.Metadata.Added,
 		MetaLastUpdated: info.Metadata.LastUpdated,
 		MetaSourceCode:  info.Metadata.SourceCode,
+		Categories:      strings.Join(info.Metadata.Categories, ","),
+		ListedAt:        listedAt.UnixMilli(),
+		OriginUrl:       origin,
 	})
+	if err != nil {
+		return err
+	}
+
+	for _, v := range info.Versions {
+		err := dbWriteSqlc.CreateOrUpdateVersion(ctx, db.CreateOrUpdateVersionParams{
+			Package:     pkg,
+			VersionCode: v.VersionCode,
+			VersionName: v.VersionName,
+			Added:       v.Added,
+			FileName:    v.FileName,
+			FileSha256:  v.FileSha256,
+			SrcName:     sql.NullString{String: v.SrcName, Valid: v.SrcName != ""},
+			SrcSha256:   sql.NullString{String: v.SrcSha256, Valid: v.SrcSha256 != ""},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 func loadToDB(ctx context.Context) {
@@ -31,14 +61,28 @@ func loadToDB(ctx context.Context) {
 		panic(err)
 	}
 	slog.Info("loading to db", "packages", len(pkgmap))
+	listedAt := time.Now()
 	c := 0
 	for pkg, info := range pkgmap {
 		c += 1
 		fmt.Printf("[%d/%d] %s  \r", c, len(pkgmap), pkg)
-		err := createOrUpdatePkg(ctx, pkg, info)
+		err := createOrUpdatePkg(ctx, pkg, info, listedAt)
+		if err != nil {
+			panic(err)
+		}
+	}
+	// an empty index is more likely broken than the whole repo delisted
+	if len(pkgmap) > 0 {
+		delisted, err := dbWriteSqlc.MarkDelistedApps(ctx, db.MarkDelistedAppsParams{
+			DelistedAt: listedAt.UnixMilli(),
+			ListedAt:   listedAt.UnixMilli(),
+		})
 		if err != nil {
 			panic(err)
 		}
+		if delisted > 0 {
+			slog.Warn("apps delisted from the index", "count", delisted)
+		}
 	}
 	slog.Info("loaded to db")
 }
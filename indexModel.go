@@ -6,13 +6,69 @@ import (
 )
 
 type PackageInfo struct {
-	Metadata Metadata `json:"metadata"`
+	Metadata Metadata  `json:"metadata"`
+	Versions []Version `json:"versions"`
 }
 
 type Metadata struct {
-	Added       int64  `json:"added"`
-	LastUpdated int64  `json:"lastUpdated"`
-	SourceCode  string `json:"sourceCode"`
+	Added       int64    `json:"added"`
+	LastUpdated int64    `json:"lastUpdated"`
+	SourceCode  string   `json:"sourceCode"`
+	Categories  []string `json:"categories"`
+}
+
+type Version struct {
+	VersionCode int64
+	VersionName string
+	Added       int64
+	FileName    string
+	FileSha256  string
+	// empty if there is no source tarball
+	SrcName   string
+	SrcSha256 string
+}
+
+// convertToVersions reads the versions map of a package, keyed by APK hash.
+// Versions with an unexpected shape are skipped.
+func convertToVersions(versionsData any) []Version {
+	versionsMap, ok := versionsData.(map[string]any)
+	if !ok {
+		return nil
+	}
+
+	var versions []Version
+	for _, versionData := range versionsMap {
+		versionMap, ok := versionData.(map[string]any)
+		if !ok {
+			continue
+		}
+		manifest, ok := versionMap["manifest"].(map[string]any)
+		if !ok {
+			continue
+		}
+		versionCode, ok := manifest["versionCode"].(float64)
+		if !ok {
+			continue
+		}
+		file, ok := versionMap["file"].(map[string]any)
+		if !ok {
+			continue
+		}
+
+		version := Version{VersionCode: int64(versionCode)}
+		version.VersionName, _ = manifest["versionName"].(string)
+		if added, ok := versionMap["added"].(float64); ok {
+			version.Added = int64(added)
+		}
+		version.FileName, _ = file["name"].(string)
+		version.FileSha256, _ = file["sha256"].(string)
+		if src, ok := versionMap["src"].(map[string]any); ok {
+			version.SrcName, _ = src["name"].(string)
+			version.SrcSha256, _ = src["sha256"].(string)
+		}
+		versions = append(versions, version)
+	}
+	return versions
 }
 
 func convertToPackageInfo(packageData any) (*PackageInfo, error) {
@@ -41,12 +97,23 @@ func convertToPackageInfo(packageData any) (*PackageInfo, error) {
 		sourceCode = "" // Use a default value (empty string)
 	}
 
+	var categories []string
+	if list, ok := metadataData["categories"].([]any); ok {
+		for _, c := range list {
+			if c, ok := c.(string); ok {
+				categories = append(categories, c)
+			}
+		}
+	}
+
 	packageInfo := &PackageInfo{
 		Metadata: Metadata{
 			Added:       int64(added),
 			LastUpdated: int64(lastUpdated),
 			SourceCode:  sourceCode,
+			Categories:  categories,
 		},
+		Versions: convertToVersions(packageMap["versions"]),
 	}
 	return packageInfo, nil
 }
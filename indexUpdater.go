@@ -92,6 +92,9 @@ func indexUpdater(ctx context.Context, wg *sync.WaitGroup, client *http.Client,
 			return
 		case <-ticker.C:
 			once.Do(func() { ticker.Reset(1 * time.Hour) })
+			if !waitWhilePaused(ctx, "updater") {
+				return
+			}
 
 			updateAvailable, err := checkIndexUpdate(ctx, client)
 			if err != nil {
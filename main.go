@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"log/slog"
 	"net/http"
+	"os"
 	"os/signal"
 	"sync"
 	"syscall"
 	"time"
 
+	"github.com/joho/godotenv"
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 	"github.com/saveweb/fdroidswh/db"
@@ -38,6 +40,8 @@ func sleepCtx(ctx context.Context, delay time.Duration) {
 }
 
 func init() {
+	godotenv.Load()
+
 	var err error
 	dbWrite, err = sql.Open("sqlite3", "file:data/db.sqlite")
 	if err != nil {
@@ -45,7 +49,7 @@ func init() {
 	}
 	dbWrite.SetMaxOpenConns(1)
 
-	if _, err := dbWrite.Exec(ddl); err != nil {
+	if err := migrate(context.Background(), dbWrite); err != nil {
 		slog.Error("error creating database schema", "err", err.Error(), "func", "lq.Init")
 		panic(err)
 	}
@@ -58,14 +62,47 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if len(os.Args) > 1 {
+		os.Exit(command(ctx, os.Args[1:]))
+	}
+
 	client := &http.Client{}
 	updateNotify := make(chan struct{})
 
-	wg.Add(4)
+	archivers, err := newArchivers(client)
+	if err != nil {
+		panic(err)
+	}
+
+	warnPaused(ctx)
+
+	wg.Add(3)
 	go indexUpdater(ctx, wg, client, updateNotify)
 	go indexLoader(ctx, wg, updateNotify)
-	go saver(ctx, wg, client)
-	go webui(ctx, wg)
+	go webui(ctx, wg, archivers)
+	if len(archivers) > 0 {
+		wg.Add(2)
+		go saver(ctx, wg, archivers)
+		go poller(ctx, wg, archivers)
+		if RESTORE_TESTS_PER_DAY > 0 {
+			wg.Add(1)
+			go restoreTester(ctx, wg, archivers)
+		}
+		if REF_WATCH_HOURS > 0 {
+			wg.Add(1)
+			go refWatcher(ctx, wg, forgeClient(client), archivers)
+		}
+	} else {
+		slog.Warn("no archiver configured, running web-only")
+	}
+	if SRC_HASH_WORKERS > 0 {
+		wg.Add(1)
+		go sourceHasher(ctx, wg, client)
+	}
+	if MIRROR_DIR != "" {
+		wg.Add(1)
+		go mirrorer(ctx, wg, client)
+	}
 
 	select {
 	case <-ctx.Done():
@@ -74,3 +111,40 @@ func main() {
 		dbWrite.Close()
 	}
 }
+
+// command runs a one-off maintenance command instead of the service and
+// returns the exit code.
+func command(ctx context.Context, args []string) int {
+	defer dbWrite.Close()
+
+	switch args[0] {
+	case "mirror-check":
+		if MIRROR_DIR == "" {
+			fmt.Fprintln(os.Stderr, "MIRROR_DIR is not set")
+			return 2
+		}
+		bad, err := checkMirror(ctx)
+		if err != nil {
+			slog.Error("checkMirror", "err", err)
+			return 1
+		}
+		if bad > 0 {
+			return 1
+		}
+		return 0
+	case "backfill":
+		archivers, err := newArchivers(&http.Client{})
+		if err != nil {
+			slog.Error("newArchivers", "err", err)
+			return 1
+		}
+		if err := backfill(ctx, archivers, args[1:]); err != nil {
+			slog.Error("backfill", "err", err)
+			return 1
+		}
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q, available: mirror-check, backfill [package...]\n", args[0])
+		return 2
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// migrations upgrade databases created from older versions of schema.sql.
+// migrations[i] takes a database from user_version i to i+1. Fresh databases
+// are created straight from schema.sql and start at the latest version.
+//
+// Tables and indexes that are only added, not changed, need no migration:
+// schema.sql is applied after the migrations and creates whatever is missing.
+var migrations = []func(ctx context.Context, tx *sql.Tx) error{
+	migrateInstanceTasks,
+	migrateFullTasks,
+	migrateTaskPolling,
+	migrateSourceSwhids,
+	migrateMirror,
+	migrateSaveRetries,
+	migrateListing,
+	migrateCategories,
+	migrateOrigins,
+	migrateAbandonedTasks,
+	migrateTaskRefs,
+}
+
+func migrate(ctx context.Context, conn *sql.DB) error {
+	var fresh bool
+	if err := conn.QueryRowContext(ctx,
+		"SELECT NOT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'apps')",
+	).Scan(&fresh); err != nil {
+		return err
+	}
+
+	if fresh {
+		if _, err := conn.ExecContext(ctx, ddl); err != nil {
+			return err
+		}
+		return setUserVersion(ctx, conn, len(migrations))
+	}
+
+	var version int
+	if err := conn.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	if version < len(migrations) {
+		// tables are rebuilt while other tables still point at them, so
+		// constraints are checked after each migration instead; this pragma
+		// is a no-op inside a transaction
+		var foreignKeys bool
+		if err := conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+			return err
+		}
+		if foreignKeys {
+			if _, err := conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+				return err
+			}
+			defer conn.ExecContext(ctx, "PRAGMA foreign_keys = ON")
+		}
+	}
+
+	for ; version < len(migrations); version++ {
+		slog.Info("migrating database", "from", version, "to", version+1)
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](ctx, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if err := foreignKeyCheck(ctx, tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		if err := setUserVersion(ctx, conn, version+1); err != nil {
+			return err
+		}
+	}
+
+	_, err := conn.ExecContext(ctx, ddl)
+	return err
+}
+
+func setUserVersion(ctx context.Context, conn *sql.DB, version int) error {
+	// PRAGMA does not take bind parameters
+	_, err := conn.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+	return err
+}
+
+func foreignKeyCheck(ctx context.Context, tx *sql.Tx) error {
+	rows, err := tx.QueryContext(ctx, "PRAGMA foreign_key_check")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var table, parent string
+		var rowid sql.NullInt64
+		var fkid int
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: %s row %d references missing %s", table, rowid.Int64, parent)
+	}
+	return rows.Err()
+}
+
+// addColumn adds a column unless the table already has it. Tables that do
+// not exist yet are left to schema.sql, which creates them complete.
+func addColumn(ctx context.Context, tx *sql.Tx, table, column, decl string) error {
+	var tableExists, exists bool
+	if err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?)", table,
+	).Scan(&tableExists); err != nil {
+		return err
+	}
+	if !tableExists {
+		return nil
+	}
+	if err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column,
+	).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, decl))
+	return err
+}
+
+func execAll(ctx context.Context, tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("%w: %s", err, stmt)
+		}
+	}
+	return nil
+}
+
+// migrateInstanceTasks keys tasks by SWH instance, since save request ids
+// are only unique per instance, and moves apps.last_task_id into app_tasks so
+// every instance keeps its own last task per app. Existing rows belong to
+// the default instance.
+func migrateInstanceTasks(ctx context.Context, tx *sql.Tx) error {
+	return execAll(ctx, tx,
+		`DROP VIEW IF EXISTS apps_ordered`,
+
+		`CREATE TABLE tasks_new(
+			instance TEXT NOT NULL,
+			id INTEGER NOT NULL,
+			save_request_status TEXT NOT NULL,
+			save_task_status TEXT NOT NULL,
+			snapshot_swhid TEXT,
+			PRIMARY KEY (instance, id)
+		)`,
+		`INSERT INTO tasks_new (instance, id, save_request_status, save_task_status, snapshot_swhid)
+			SELECT 'default', id, save_request_status, save_task_status, snapshot_swhid FROM tasks`,
+
+		`CREATE TABLE app_tasks(
+			package TEXT NOT NULL,
+			instance TEXT NOT NULL,
+			last_task_id INTEGER NOT NULL,
+			PRIMARY KEY (package, instance),
+			FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE,
+			FOREIGN KEY (instance, last_task_id) REFERENCES tasks(instance, id)
+		)`,
+		`INSERT INTO app_tasks (package, instance, last_task_id)
+			SELECT package, 'default', last_task_id FROM apps WHERE last_task_id IS NOT NULL`,
+
+		`CREATE TABLE apps_new(
+			package TEXT NOT NULL PRIMARY KEY,
+			meta_added INTEGER NOT NULL,
+			meta_last_updated INTEGER NOT NULL,
+			meta_source_code TEXT NOT NULL,
+			last_save_triggered INTEGER NOT NULL DEFAULT (0)
+		)`,
+		`INSERT INTO apps_new (package, meta_added, meta_last_updated, meta_source_code, last_save_triggered)
+			SELECT package, meta_added, meta_last_updated, meta_source_code, last_save_triggered FROM apps`,
+
+		`DROP TABLE apps`,
+		`DROP TABLE tasks`,
+		`ALTER TABLE apps_new RENAME TO apps`,
+		`ALTER TABLE tasks_new RENAME TO tasks`,
+	)
+}
+
+// migrateFullTasks keeps the whole save request record in tasks, not just
+// its statuses.
+func migrateFullTasks(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range []struct{ column, decl string }{
+		{"origin_url", "TEXT NOT NULL DEFAULT ''"},
+		{"visit_type", "TEXT NOT NULL DEFAULT ''"},
+		{"save_request_date", "TEXT NOT NULL DEFAULT ''"},
+		{"visit_date", "TEXT"},
+		{"visit_status", "TEXT"},
+		{"loading_task_id", "INTEGER"},
+		{"note", "TEXT"},
+		{"request_url", "TEXT NOT NULL DEFAULT ''"},
+		{"raw", "TEXT NOT NULL DEFAULT ''"},
+		{"created_at", "INTEGER NOT NULL DEFAULT (0)"},
+		{"updated_at", "INTEGER NOT NULL DEFAULT (0)"},
+		{"finished_at", "INTEGER"},
+	} {
+		if err := addColumn(ctx, tx, "tasks", c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateTaskPolling adds the poller's per-task schedule.
+func migrateTaskPolling(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "tasks", "next_poll_at", "INTEGER NOT NULL DEFAULT (0)"); err != nil {
+		return err
+	}
+	return addColumn(ctx, tx, "tasks", "poll_failures", "INTEGER NOT NULL DEFAULT (0)")
+}
+
+// migrateSourceSwhids adds the identifiers computed from source tarballs.
+func migrateSourceSwhids(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range []struct{ column, decl string }{
+		{"src_cnt_swhid", "TEXT"},
+		{"src_dir_swhid", "TEXT"},
+		{"src_hashed_at", "INTEGER NOT NULL DEFAULT (0)"},
+		{"src_error", "TEXT"},
+	} {
+		if err := addColumn(ctx, tx, "versions", c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateMirror adds whether each release's files are in the local mirror.
+func migrateMirror(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range []struct{ column, decl string }{
+		{"apk_mirrored", "BOOLEAN NOT NULL DEFAULT (FALSE)"},
+		{"src_mirrored", "BOOLEAN NOT NULL DEFAULT (FALSE)"},
+		{"mirrored_at", "INTEGER NOT NULL DEFAULT (0)"},
+		{"mirror_error", "TEXT"},
+	} {
+		if err := addColumn(ctx, tx, "versions", c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateSaveRetries adds the retry schedule of failed saves.
+func migrateSaveRetries(ctx context.Context, tx *sql.Tx) error {
+	for _, c := range []struct{ column, decl string }{
+		{"save_failures", "INTEGER NOT NULL DEFAULT (0)"},
+		{"next_attempt_at", "INTEGER NOT NULL DEFAULT (0)"},
+		{"last_error_class", "TEXT"},
+		{"gave_up_at", "INTEGER"},
+	} {
+		if err := addColumn(ctx, tx, "apps", c.column, c.decl); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateListing adds when each app was last seen in the index.
+func migrateListing(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "apps", "listed_at", "INTEGER NOT NULL DEFAULT (0)"); err != nil {
+		return err
+	}
+	return addColumn(ctx, tx, "apps", "delisted_at", "INTEGER")
+}
+
+// migrateCategories keeps the F-Droid categories of each app.
+func migrateCategories(ctx context.Context, tx *sql.Tx) error {
+	return addColumn(ctx, tx, "apps", "categories", "TEXT NOT NULL DEFAULT ''")
+}
+
+// migrateOrigins links every app to the origin of its source code.
+func migrateOrigins(ctx context.Context, tx *sql.Tx) error {
+	if err := execAll(ctx, tx,
+		`CREATE TABLE IF NOT EXISTS origins(
+			url TEXT NOT NULL PRIMARY KEY,
+			first_seen_at INTEGER NOT NULL
+		)`,
+	); err != nil {
+		return err
+	}
+	if err := addColumn(ctx, tx, "apps", "origin_url", "TEXT REFERENCES origins(url)"); err != nil {
+		return err
+	}
+	return execAll(ctx, tx,
+		// as swhOriginURL has it
+		`UPDATE apps SET origin_url = CASE WHEN meta_source_code LIKE '%/' THEN meta_source_code ELSE meta_source_code || '/' END
+			WHERE meta_source_code != ''`,
+		`INSERT INTO origins (url, first_seen_at)
+			SELECT origin_url, MIN(meta_added) FROM apps
+			WHERE origin_url IS NOT NULL
+			GROUP BY origin_url
+			ON CONFLICT(url) DO NOTHING`,
+	)
+}
+
+// migrateAbandonedTasks adds why the poller gave up on a task.
+func migrateAbandonedTasks(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "tasks", "abandoned_at", "INTEGER"); err != nil {
+		return err
+	}
+	return addColumn(ctx, tx, "tasks", "abandon_reason", "TEXT")
+}
+
+// migrateTaskRefs keeps the refs seen when each task was submitted, instead
+// of comparing snapshots with whatever refs are current. Tasks waiting to be
+// verified get the refs the old check would have used.
+func migrateTaskRefs(ctx context.Context, tx *sql.Tx) error {
+	if err := addColumn(ctx, tx, "origins", "refs_checked_at", "INTEGER NOT NULL DEFAULT (0)"); err != nil {
+		return err
+	}
+	var seenRefs bool
+	if err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = 'upstream_refs')",
+	).Scan(&seenRefs); err != nil {
+		return err
+	}
+	if !seenRefs {
+		// nothing to carry over, schema.sql creates the rest
+		return nil
+	}
+	return execAll(ctx, tx,
+		`UPDATE origins SET refs_checked_at = COALESCE((SELECT MAX(seen_at) FROM upstream_refs WHERE upstream_refs.origin_url = origins.url), 0)`,
+		`CREATE TABLE IF NOT EXISTS task_refs(
+			instance TEXT NOT NULL,
+			task_id INTEGER NOT NULL,
+			name TEXT NOT NULL,
+			target TEXT NOT NULL,
+			PRIMARY KEY (instance, task_id, name),
+			FOREIGN KEY (instance, task_id) REFERENCES tasks(instance, id) ON DELETE CASCADE
+		)`,
+		`INSERT INTO task_refs (instance, task_id, name, target)
+			SELECT tasks.instance, tasks.id, upstream_refs.name, upstream_refs.target FROM tasks
+			JOIN upstream_refs ON upstream_refs.origin_url = tasks.origin_url AND upstream_refs.seen_at <= tasks.created_at
+			WHERE tasks.save_request_status != 'rejected' AND tasks.save_task_status != 'failed'
+				AND NOT EXISTS (SELECT 1 FROM snapshot_checks WHERE snapshot_checks.instance = tasks.instance AND snapshot_checks.task_id = tasks.id)
+			ON CONFLICT DO NOTHING`,
+	)
+}
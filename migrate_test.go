@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// schema.sql as it was before migrations existed
+const legacyDDL = `
+CREATE TABLE apps(
+    package TEXT NOT NULL PRIMARY KEY,
+    meta_added INTEGER NOT NULL,
+    meta_last_updated INTEGER NOT NULL,
+    meta_source_code TEXT NOT NULL,
+    last_save_triggered INTEGER NOT NULL DEFAULT (0),
+    last_task_id INTEGER,
+    FOREIGN KEY (last_task_id) REFERENCES tasks(id) ON DELETE SET NULL
+);
+CREATE TABLE tasks(
+    id INTEGER NOT NULL PRIMARY KEY,
+    save_request_status TEXT NOT NULL,
+    save_task_status TEXT NOT NULL,
+    snapshot_swhid TEXT
+);
+CREATE INDEX apps_last_save_triggered ON apps (last_save_triggered);
+CREATE VIEW apps_ordered AS
+SELECT * FROM apps ORDER BY meta_last_updated DESC;
+
+INSERT INTO tasks VALUES (42, 'accepted', 'succeeded', 'swh:1:snp:0000000000000000000000000000000000000000');
+INSERT INTO apps VALUES ('org.example', 1, 2, 'https://example.org/repo', 3, 42);
+`
+
+func Test_migrate(t *testing.T) {
+	ctx := context.Background()
+	conn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetMaxOpenConns(1)
+
+	if _, err := conn.Exec(legacyDDL); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+
+	var version int
+	if err := conn.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Fatalf("user_version = %d, want %d", version, len(migrations))
+	}
+
+	q := db.New(conn)
+	task, err := q.GetAppTask(ctx, db.GetAppTaskParams{Package: "org.example", Instance: defaultSWHInstance})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.ID != 42 || task.SaveTaskStatus != "succeeded" {
+		t.Fatal(task)
+	}
+	apps, err := q.GetAllApps(ctx, db.GetAllAppsParams{Package: "%", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(apps) != 1 || apps[0].LastSaveTriggered != 3 || apps[0].OriginUrl.String != "https://example.org/repo/" {
+		t.Fatal(apps)
+	}
+
+	// a second start is a no-op
+	if err := migrate(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,363 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// MIRROR_DIR is where APKs and source tarballs are kept, empty turns the
+// mirror off.
+var MIRROR_DIR = ""
+
+// MIRROR_QUOTA_GB caps the size of the mirror, 0 means no cap. The cap is
+// checked before each download, so the mirror may exceed it by one file.
+var MIRROR_QUOTA_GB = 0
+
+// MIRROR_KEEP_VERSIONS is how many of the latest releases of each app are
+// kept in the mirror, 0 keeps all of them.
+var MIRROR_KEEP_VERSIONS = 3
+
+const (
+	mirrorBatchSize = 10
+	// releases whose files could not be mirrored are tried again after this long
+	mirrorRetryInterval = 24 * time.Hour
+)
+
+var errMirrorFull = errors.New("mirror quota reached")
+
+func init() {
+	MIRROR_DIR = os.Getenv("MIRROR_DIR")
+	if n, err := strconv.Atoi(os.Getenv("MIRROR_QUOTA_GB")); err == nil && n >= 0 {
+		MIRROR_QUOTA_GB = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MIRROR_KEEP_VERSIONS")); err == nil && n >= 0 {
+		MIRROR_KEEP_VERSIONS = n
+	}
+}
+
+func mirrorQuota() int64 {
+	return int64(MIRROR_QUOTA_GB) << 30
+}
+
+func mirrorKeep() int64 {
+	if MIRROR_KEEP_VERSIONS == 0 {
+		return math.MaxInt32
+	}
+	return int64(MIRROR_KEEP_VERSIONS)
+}
+
+// repoFileURL is where F-Droid hosts a file of the repository, as named in
+// the index.
+func repoFileURL(name string) string {
+	return SRC_BASE_URL + strings.TrimPrefix(name, "/")
+}
+
+// blobPath is where the file with the given sha256 is stored, fanned out by
+// its first byte.
+func blobPath(sha256sum string) string {
+	return filepath.Join(MIRROR_DIR, "sha256", sha256sum[:2], sha256sum)
+}
+
+func validSha256(s string) bool {
+	b, err := hex.DecodeString(s)
+	return err == nil && len(b) == sha256.Size
+}
+
+// storeBlob downloads name into the mirror unless a file with its sha256 is
+// already there. The download is checked against the index's sha256 before
+// it is moved into place.
+func storeBlob(ctx context.Context, client *http.Client, name, sha256sum string) error {
+	if !validSha256(sha256sum) {
+		return fmt.Errorf("%s: bad sha256 %q in index", name, sha256sum)
+	}
+	if _, err := dbWriteSqlc.GetMirrorBlob(ctx, sha256sum); err == nil {
+		if _, err := os.Stat(blobPath(sha256sum)); err == nil {
+			return nil
+		}
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if quota := mirrorQuota(); quota > 0 {
+		usage, err := dbWriteSqlc.GetMirrorUsage(ctx)
+		if err != nil {
+			return err
+		}
+		if usage.Size >= quota {
+			return errMirrorFull
+		}
+	}
+
+	tmpDir := filepath.Join(MIRROR_DIR, "tmp")
+	if err := os.MkdirAll(tmpDir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(tmpDir, "download-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", repoFileURL(name), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "fdroidswh-git")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+
+	sum := sha256.New()
+	size, err := io.Copy(f, io.TeeReader(resp.Body, sum))
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != sha256sum {
+		return fmt.Errorf("%s: sha256 %s, index says %s", name, got, sha256sum)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	dst := blobPath(sha256sum)
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), dst); err != nil {
+		return err
+	}
+	now := time.Now().UnixMilli()
+	return dbWriteSqlc.CreateMirrorBlob(ctx, db.CreateMirrorBlobParams{
+		Sha256:     sha256sum,
+		Size:       size,
+		StoredAt:   now,
+		VerifiedAt: now,
+	})
+}
+
+// mirrorVersion stores the APK and source tarball of a release. A full
+// mirror is passed up so the batch stops; the release is left as it was and
+// tried again once there is room.
+func mirrorVersion(ctx context.Context, client *http.Client, v db.Version) error {
+	apk, src := v.ApkMirrored, v.SrcMirrored
+	mirroredAt := time.Now().UnixMilli()
+	var full error
+	var errs []error
+	if !apk {
+		err := storeBlob(ctx, client, v.FileName, v.FileSha256)
+		if errors.Is(err, context.Canceled) || errors.Is(err, errMirrorFull) {
+			return err
+		}
+		apk = err == nil
+		errs = append(errs, err)
+	}
+	if v.SrcName.Valid && !src {
+		err := storeBlob(ctx, client, v.SrcName.String, v.SrcSha256.String)
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		if errors.Is(err, errMirrorFull) {
+			// record the APK we just stored, the tarball is not an attempt
+			// and stays unmirrored
+			full = err
+			mirroredAt = v.MirroredAt
+		} else {
+			src = err == nil
+			errs = append(errs, err)
+		}
+	}
+
+	err := errors.Join(errs...)
+	if err != nil {
+		slog.Warn("mirror failed", "package", v.Package, "versionCode", v.VersionCode, "err", err)
+	} else {
+		slog.Info("release mirrored", "package", v.Package, "versionCode", v.VersionCode)
+	}
+	var mirrorError string
+	if err != nil {
+		mirrorError = err.Error()
+	}
+	if err := dbWriteSqlc.UpdateVersionMirror(ctx, db.UpdateVersionMirrorParams{
+		ApkMirrored: apk,
+		SrcMirrored: src,
+		MirroredAt:  mirroredAt,
+		MirrorError: sql.NullString{String: mirrorError, Valid: mirrorError != ""},
+		Package:     v.Package,
+		VersionCode: v.VersionCode,
+	}); err != nil {
+		return err
+	}
+	return full
+}
+
+// pruneMirror forgets releases that fell out of MIRROR_KEEP_VERSIONS and
+// deletes the files no kept release refers to any more.
+func pruneMirror(ctx context.Context) error {
+	expired, err := dbWriteSqlc.ExpireMirroredVersions(ctx, mirrorKeep())
+	if err != nil {
+		return err
+	}
+	blobs, err := dbWriteSqlc.GetUnreferencedMirrorBlobs(ctx)
+	if err != nil {
+		return err
+	}
+	var freed int64
+	for _, blob := range blobs {
+		if err := os.Remove(blobPath(blob.Sha256)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		if err := dbWriteSqlc.DeleteMirrorBlob(ctx, blob.Sha256); err != nil {
+			return err
+		}
+		freed += blob.Size
+	}
+	if expired > 0 || len(blobs) > 0 {
+		slog.Info("mirror pruned", "releases", expired, "files", len(blobs), "bytes", freed)
+	}
+	return nil
+}
+
+// mirrorer keeps a copy of what F-Droid shipped: the APKs and source
+// tarballs of the latest releases of each app, newest first, in a
+// content-addressed store under MIRROR_DIR.
+func mirrorer(ctx context.Context, wg *sync.WaitGroup, client *http.Client) {
+	defer wg.Done()
+	slog.Info("mirrorer start", "dir", MIRROR_DIR, "quotaGB", MIRROR_QUOTA_GB, "keep", MIRROR_KEEP_VERSIONS)
+	defer slog.Info("mirrorer exit")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+
+		if err := pruneMirror(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("pruneMirror", "err", err)
+		}
+
+		versions, err := dbWriteSqlc.GetVersionsToMirror(ctx, db.GetVersionsToMirrorParams{
+			MirroredAt: time.Now().Add(-mirrorRetryInterval).UnixMilli(),
+			Keep:       mirrorKeep(),
+			Limit:      mirrorBatchSize,
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("GetVersionsToMirror", "err", err)
+		}
+		if len(versions) == 0 {
+			sleepCtx(ctx, time.Minute)
+			continue
+		}
+
+		for _, v := range versions {
+			err := mirrorVersion(ctx, client, v)
+			if errors.Is(err, errMirrorFull) {
+				slog.Warn("mirror full", "quotaGB", MIRROR_QUOTA_GB)
+				sleepCtx(ctx, time.Hour)
+				break
+			}
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("mirrorVersion", "package", v.Package, "err", err)
+			}
+		}
+	}
+}
+
+// checkMirror re-hashes every file in the mirror. Files that are missing or
+// do not match their sha256 are dropped, and the releases that had them are
+// mirrored again. Returns how many files were bad.
+func checkMirror(ctx context.Context) (int, error) {
+	blobs, err := dbWriteSqlc.GetMirrorBlobs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	bad := 0
+	for _, blob := range blobs {
+		err := verifyBlob(blob.Sha256)
+		if errors.Is(err, context.Canceled) {
+			return bad, err
+		}
+		if err == nil {
+			if err := dbWriteSqlc.UpdateMirrorBlobVerified(ctx, db.UpdateMirrorBlobVerifiedParams{
+				VerifiedAt: time.Now().UnixMilli(),
+				Sha256:     blob.Sha256,
+			}); err != nil {
+				return bad, err
+			}
+			continue
+		}
+
+		bad++
+		slog.Warn("mirror file bad", "sha256", blob.Sha256, "err", err)
+		if err := dropBlob(ctx, blob.Sha256); err != nil {
+			return bad, err
+		}
+	}
+	slog.Info("mirror checked", "files", len(blobs), "bad", bad)
+	return bad, nil
+}
+
+func verifyBlob(sha256sum string) error {
+	f, err := os.Open(blobPath(sha256sum))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sum := sha256.New()
+	if _, err := io.Copy(sum, f); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != sha256sum {
+		return fmt.Errorf("sha256 is %s", got)
+	}
+	return nil
+}
+
+// dropBlob removes a file from the mirror and marks the releases that had it
+// as not mirrored.
+func dropBlob(ctx context.Context, sha256sum string) error {
+	if err := os.Remove(blobPath(sha256sum)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	tx, err := dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := dbWriteSqlc.WithTx(tx)
+	if err := q.UnmirrorApk(ctx, sha256sum); err != nil {
+		return err
+	}
+	if err := q.UnmirrorSource(ctx, sql.NullString{String: sha256sum, Valid: true}); err != nil {
+		return err
+	}
+	if err := q.DeleteMirrorBlob(ctx, sha256sum); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
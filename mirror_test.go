@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+func Test_verifyBlob(t *testing.T) {
+	dir := MIRROR_DIR
+	MIRROR_DIR = t.TempDir()
+	defer func() { MIRROR_DIR = dir }()
+
+	content := []byte("apk")
+	sum := sha256.Sum256(content)
+	good := hex.EncodeToString(sum[:])
+	if err := os.MkdirAll(filepath.Dir(blobPath(good)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(blobPath(good), content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBlob(good); err != nil {
+		t.Errorf("intact file: %v", err)
+	}
+
+	if err := os.WriteFile(blobPath(good), []byte("apk, truncated"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyBlob(good); err == nil {
+		t.Error("corrupt file passed")
+	}
+
+	missing := hex.EncodeToString(make([]byte, sha256.Size))
+	if err := verifyBlob(missing); !os.IsNotExist(err) {
+		t.Errorf("missing file: got %v", err)
+	}
+}
+
+func Test_mirrorVersion_full(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	dir, quota, base := MIRROR_DIR, MIRROR_QUOTA_GB, SRC_BASE_URL
+	defer func() { MIRROR_DIR, MIRROR_QUOTA_GB, SRC_BASE_URL = dir, quota, base }()
+	MIRROR_DIR = t.TempDir()
+	MIRROR_QUOTA_GB = 1
+
+	apk := []byte("apk")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(apk)
+	}))
+	defer srv.Close()
+	SRC_BASE_URL = srv.URL + "/"
+
+	// the APK still fits, the tarball no longer does
+	if err := dbWriteSqlc.CreateMirrorBlob(ctx, db.CreateMirrorBlobParams{
+		Sha256: hex.EncodeToString(make([]byte, sha256.Size)),
+		Size:   1<<30 - 1,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	apkSum := sha256.Sum256(apk)
+	v := db.Version{
+		Package:     "org.example",
+		VersionCode: 1,
+		FileName:    "org.example_1.apk",
+		FileSha256:  hex.EncodeToString(apkSum[:]),
+		SrcName:     sql.NullString{String: "org.example_1_src.tar.gz", Valid: true},
+		SrcSha256:   sql.NullString{String: strings.Repeat("ab", sha256.Size), Valid: true},
+	}
+	if _, err := dbWrite.Exec(`INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code) VALUES (?, 1, 2, '')`, v.Package); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dbWrite.Exec(`INSERT INTO versions (package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256)
+		VALUES (?, ?, '1.0', 1, ?, ?, ?, ?)`, v.Package, v.VersionCode, v.FileName, v.FileSha256, v.SrcName, v.SrcSha256); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mirrorVersion(ctx, srv.Client(), v); !errors.Is(err, errMirrorFull) {
+		t.Fatalf("got %v, want errMirrorFull", err)
+	}
+	var apkMirrored, srcMirrored bool
+	if err := dbWrite.QueryRow("SELECT apk_mirrored, src_mirrored FROM versions WHERE package = ? AND version_code = ?",
+		v.Package, v.VersionCode).Scan(&apkMirrored, &srcMirrored); err != nil {
+		t.Fatal(err)
+	}
+	if !apkMirrored || srcMirrored {
+		t.Fatalf("apk mirrored %v, tarball mirrored %v", apkMirrored, srcMirrored)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// createOrigin makes sure the SWH origin of sourceCode exists and returns it
+// for apps.origin_url, NULL if the app has no source code.
+func createOrigin(ctx context.Context, sourceCode string, now time.Time) (sql.NullString, error) {
+	if sourceCode == "" {
+		return sql.NullString{}, nil
+	}
+	origin := swhOriginURL(sourceCode)
+	err := dbWriteSqlc.CreateOrigin(ctx, db.CreateOriginParams{
+		Url:         origin,
+		FirstSeenAt: now.UnixMilli(),
+	})
+	return sql.NullString{String: origin, Valid: true}, err
+}
+
+// originPackages returns pkg and the other apps built from the same origin,
+// which share its saves.
+func originPackages(ctx context.Context, pkg string) []string {
+	pkgs, err := dbWriteSqlc.GetOriginPackages(ctx, pkg)
+	if err != nil {
+		slog.Error("GetOriginPackages", "package", pkg, "err", err)
+	}
+	if len(pkgs) == 0 {
+		// no origin: the app is on its own
+		return []string{pkg}
+	}
+	return pkgs
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// pausable lists the components an admin can pause, e.g. during an SWH
+// maintenance window: the saver also saves release sources, the poller also
+// verifies and fetches visits, the restorer cooks from the Vault, the
+// refwatcher fetches upstream refs and the hasher downloads source tarballs.
+// Pauses are kept in the database, so they outlive restarts; the web UI
+// keeps running either way.
+var pausable = []string{"saver", "updater", "poller", "restorer", "refwatcher", "hasher"}
+
+// how often paused components look whether they were resumed
+const pauseCheckInterval = 10 * time.Second
+
+var unknownComponent = errors.New("unknown component")
+
+// isPaused tells whether component is paused. When the database cannot
+// tell, the component keeps running.
+func isPaused(ctx context.Context, component string) bool {
+	paused, err := dbWriteSqlc.IsPaused(ctx, component)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			slog.Error("IsPaused", "component", component, "err", err)
+		}
+		return false
+	}
+	return paused != 0
+}
+
+// waitWhilePaused blocks while component is paused. It returns false if ctx
+// was canceled meanwhile.
+func waitWhilePaused(ctx context.Context, component string) bool {
+	for isPaused(ctx, component) {
+		sleepCtx(ctx, pauseCheckInterval)
+	}
+	return ctx.Err() == nil
+}
+
+// warnPaused logs the components that start paused, so a forgotten pause
+// shows in the logs.
+func warnPaused(ctx context.Context) {
+	pauses, err := pausesOf(ctx)
+	if err != nil {
+		slog.Error("GetPauses", "err", err)
+		return
+	}
+	for _, p := range pauses {
+		slog.Warn("component paused by an admin", "component", p.Component, "actor", p.Actor, "since", p.Since, "reason", p.Reason)
+	}
+}
+
+// pauseComponent pauses component for actor and tells whether it was
+// running.
+func pauseComponent(ctx context.Context, component, actor, reason string, now time.Time) (bool, error) {
+	if !slices.Contains(pausable, component) {
+		return false, unknownComponent
+	}
+	n, err := dbWriteSqlc.PauseComponent(ctx, db.PauseComponentParams{
+		Component: component,
+		Actor:     actor,
+		Reason:    reason,
+		PausedAt:  now.UnixMilli(),
+	})
+	return n > 0, err
+}
+
+// resumeComponent resumes component and tells whether it was paused.
+func resumeComponent(ctx context.Context, component string) (bool, error) {
+	if !slices.Contains(pausable, component) {
+		return false, unknownComponent
+	}
+	n, err := dbWriteSqlc.ResumeComponent(ctx, component)
+	return n > 0, err
+}
+
+// PauseView is a paused component as shown in the banner.
+type PauseView struct {
+	db.Pause
+	Since string
+}
+
+// pausesOf lists the paused components for the banner.
+func pausesOf(ctx context.Context) ([]PauseView, error) {
+	pauses, err := dbWriteSqlc.GetPauses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var views []PauseView
+	for _, p := range pauses {
+		views = append(views, PauseView{p, formatTime(time.UnixMilli(p.PausedAt))})
+	}
+	return views, nil
+}
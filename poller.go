@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// PendingJob is a submitted job that has not reached a terminal state yet.
+type PendingJob struct {
+	ArchiveStatus
+	Submitted time.Time
+	// consecutive failed polls
+	Failures int
+	// apps whose save the job is, their retries are settled when it is done
+	Packages []string
+}
+
+// pendingLister is implemented by backends that persist their in-flight
+// jobs. Those jobs are followed by the poller, not by the saver, so they
+// survive restarts and don't hold a saver slot while the backend works.
+type pendingLister interface {
+	// Pending returns jobs due for a poll at now.
+	Pending(ctx context.Context, now time.Time, limit int) ([]PendingJob, error)
+	// Reschedule sets when job is polled next.
+	Reschedule(ctx context.Context, job PendingJob, next time.Time, failures int) error
+	// Abandon stops polling job because of err.
+	Abandon(ctx context.Context, job PendingJob, failures int, err error, now time.Time) error
+}
+
+// verifier is implemented by backends that double-check finished jobs.
+type verifier interface {
+	// Verify checks up to limit finished jobs and returns how many it checked.
+	Verify(ctx context.Context, limit int) (int, error)
+}
+
+// releaseChecker is implemented by backends that can tell whether the
+// source of each release is archived.
+type releaseChecker interface {
+	// CheckReleases looks up a batch of releases and returns how many it
+	// looked up.
+	CheckReleases(ctx context.Context) (int, error)
+}
+
+// visitFetcher is implemented by backends that keep the visit history of
+// origins, including visits made without us asking.
+type visitFetcher interface {
+	// FetchVisits refreshes up to limit origins and returns how many it
+	// refreshed.
+	FetchVisits(ctx context.Context, limit int) (int, error)
+}
+
+const (
+	// origins whose visits are refreshed per round; they don't count as
+	// work done, so the backlog is paced by pollMinInterval and leaves the
+	// API budget to saves
+	visitsBatchSize = 2
+
+	pollBatchSize   = 50
+	pollMinInterval = 10 * time.Second
+	pollMaxInterval = 10 * time.Minute
+	pollMaxBackoff  = time.Hour
+	// a job whose polls failed this many times in a row, about a day, is
+	// abandoned: its request is likely gone or its instance removed
+	pollMaxFailures = 30
+)
+
+// nextPoll returns when to poll a job again. Jobs that are still running are
+// polled less often the longer they run; failed polls back off exponentially.
+func nextPoll(now time.Time, job PendingJob, failures int) time.Time {
+	if failures > 0 {
+		backoff := pollMinInterval << min(failures, 10)
+		return now.Add(min(backoff, pollMaxBackoff))
+	}
+	interval := now.Sub(job.Submitted) / 10
+	return now.Add(min(max(interval, pollMinInterval), pollMaxInterval))
+}
+
+// pollOnce polls every job of a that is due and returns how many it polled.
+func pollOnce(ctx context.Context, a Archiver, lister pendingLister) (int, error) {
+	jobs, err := lister.Pending(ctx, time.Now(), pollBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, job := range jobs {
+		started := time.Now()
+		st, err := a.Poll(ctx, job.ArchiveStatus)
+		failures := 0
+		if err != nil {
+			if errors.Is(err, context.Canceled) {
+				return 0, err
+			}
+			recordAttempt(ctx, a, "poll", "", job.Target, job.JobID, started, err)
+			// throttling is the rate limiter's business, not the job's
+			if !errors.Is(err, RateLimited) {
+				failures = job.Failures + 1
+			}
+			slog.Warn("poll failed", "archiver", a.Name(), "job", job.JobID, "failures", failures, "err", err)
+			if failures >= pollMaxFailures {
+				slog.Warn("job abandoned", "archiver", a.Name(), "job", job.JobID, "failures", failures, "err", err)
+				if err := lister.Abandon(ctx, job, failures, err, time.Now()); err != nil {
+					return 0, err
+				}
+				// the apps are saved again as after any failed save
+				for _, pkg := range job.Packages {
+					saveFailed(ctx, pkg, err, time.Now())
+				}
+				continue
+			}
+		} else {
+			recordAttempt(ctx, a, "poll", "", job.Target, job.JobID, started, statusErr(a, st))
+			slog.Info("poll ok", "archiver", a.Name(), "job", job.JobID, "status", st.Status)
+			if err := a.Record(ctx, "", st); err != nil {
+				return 0, err
+			}
+			if st.Done {
+				settleSaves(ctx, a, job.Packages, st)
+				continue
+			}
+		}
+
+		if err := lister.Reschedule(ctx, job, nextPoll(time.Now(), job, failures), failures); err != nil {
+			return 0, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// poller follows submitted jobs until they are done, for every backend that
+// persists them, verifies them afterwards and checks which releases are
+// archived. In-flight jobs left over from a previous run are picked up on
+// startup.
+func poller(ctx context.Context, wg *sync.WaitGroup, archivers []Archiver) {
+	defer wg.Done()
+	slog.Info("poller start")
+	defer slog.Info("poller exit")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "poller") {
+			return
+		}
+
+		polled := 0
+		for _, a := range archivers {
+			lister, ok := a.(pendingLister)
+			if !ok {
+				continue
+			}
+			n, err := pollOnce(ctx, a, lister)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("pollOnce", "archiver", a.Name(), "err", err)
+			}
+			polled += n
+
+			if v, ok := a.(verifier); ok {
+				n, err := v.Verify(ctx, pollBatchSize)
+				if err != nil && !errors.Is(err, context.Canceled) {
+					slog.Error("Verify", "archiver", a.Name(), "err", err)
+				}
+				polled += n
+			}
+
+			if c, ok := a.(releaseChecker); ok {
+				n, err := c.CheckReleases(ctx)
+				if err != nil && !errors.Is(err, context.Canceled) {
+					slog.Error("CheckReleases", "archiver", a.Name(), "err", err)
+				}
+				polled += n
+			}
+
+			if f, ok := a.(visitFetcher); ok {
+				if _, err := f.FetchVisits(ctx, visitsBatchSize); err != nil && !errors.Is(err, context.Canceled) {
+					slog.Error("FetchVisits", "archiver", a.Name(), "err", err)
+				}
+			}
+		}
+
+		if polled == 0 {
+			sleepCtx(ctx, pollMinInterval)
+		}
+	}
+}
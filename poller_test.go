@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_nextPoll(t *testing.T) {
+	now := time.Now()
+
+	fresh := PendingJob{Submitted: now.Add(-time.Minute)}
+	if got := nextPoll(now, fresh, 0).Sub(now); got != pollMinInterval {
+		t.Fatalf("fresh job: %v", got)
+	}
+
+	old := PendingJob{Submitted: now.Add(-24 * time.Hour)}
+	if got := nextPoll(now, old, 0).Sub(now); got != pollMaxInterval {
+		t.Fatalf("old job: %v", got)
+	}
+
+	if got := nextPoll(now, fresh, 2).Sub(now); got != 4*pollMinInterval {
+		t.Fatalf("2 failures: %v", got)
+	}
+	if got := nextPoll(now, fresh, 30).Sub(now); got != pollMaxBackoff {
+		t.Fatalf("30 failures: %v", got)
+	}
+}
+
+func Test_pollMaxFailures(t *testing.T) {
+	now := time.Now()
+	job := PendingJob{Submitted: now}
+	var polling time.Duration
+	for failures := 1; failures < pollMaxFailures; failures++ {
+		polling += nextPoll(now, job, failures).Sub(now)
+	}
+	if polling < 12*time.Hour || polling > 48*time.Hour {
+		t.Fatalf("jobs abandoned after %v of failed polls", polling)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// PRIORITY_WEIGHTS tunes how apps waiting for a save are ranked, as a
+// comma-separated list of name=weight overriding the defaults below, e.g.
+// "delisted=200,failure=-20".
+var PRIORITY_WEIGHTS = map[string]float64{
+	// no snapshot of the origin at all
+	"never_archived": 100,
+	// per month since the last snapshot, up to a year
+	"stale_month": 10,
+	// gone from the index: its repository may follow
+	"delisted": 80,
+	// upstream deleted or moved tags, emptied the repository or rewrote history
+	"at_risk": 60,
+	// a release or moved branches in the last month
+	"upstream_change": 30,
+	// per failed save in a row
+	"failure": -10,
+}
+
+const (
+	// how far back alerts make an app at risk and releases count as changes
+	priorityRecent = 30 * 24 * time.Hour
+	// staleness stops growing after this many months
+	priorityMaxStaleMonths = 12
+)
+
+func init() {
+	if s := os.Getenv("PRIORITY_WEIGHTS"); s != "" {
+		for _, item := range strings.Split(s, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(item), "=")
+			w, err := strconv.ParseFloat(value, 64)
+			if _, ok := PRIORITY_WEIGHTS[name]; !ok || err != nil {
+				slog.Warn("ignoring priority weight", "weight", item)
+				continue
+			}
+			PRIORITY_WEIGHTS[name] = w
+		}
+	}
+}
+
+// Priority is how urgently an app should be saved, and why.
+type Priority struct {
+	Score   float64
+	Reasons []string
+}
+
+func (p Priority) String() string {
+	return strconv.FormatFloat(p.Score, 'f', 0, 64)
+}
+
+// scorePriority adds up the weighted reasons to save an app now.
+func scorePriority(app db.GetAppPrioritiesRow, weights map[string]float64, now time.Time) Priority {
+	var p Priority
+	add := func(weight string, times float64, reason string) {
+		if v := weights[weight] * times; v != 0 {
+			p.Score += v
+			p.Reasons = append(p.Reasons, fmt.Sprintf("%s (%+.0f)", reason, v))
+		}
+	}
+
+	lastSnapshot := max(app.LastSnapshotAt, app.LastVisitAt*1000)
+	if lastSnapshot == 0 {
+		add("never_archived", 1, "never archived")
+	} else if months := now.Sub(time.UnixMilli(lastSnapshot)).Hours() / 24 / 30; months >= 1 {
+		months = min(months, priorityMaxStaleMonths)
+		add("stale_month", months, fmt.Sprintf("last snapshot %.0f months ago", months))
+	}
+	if app.DelistedAt.Valid {
+		add("delisted", 1, "delisted")
+	}
+	if app.Alerts > 0 {
+		add("at_risk", 1, fmt.Sprintf("%d upstream alerts", app.Alerts))
+	}
+	if now.Sub(time.UnixMilli(app.MetaLastUpdated)) < priorityRecent {
+		add("upstream_change", 1, "updated this month")
+	} else if app.RefMoves > 0 {
+		add("upstream_change", 1, fmt.Sprintf("%d branches moved", app.RefMoves))
+	}
+	if app.SaveFailures > 0 {
+		add("failure", float64(app.SaveFailures), fmt.Sprintf("%d failed saves", app.SaveFailures))
+	}
+	return p
+}
+
+// rankSaveCandidates orders apps by priority, highest first, keeping the
+// most recently updated first among equals, and returns their packages.
+func rankSaveCandidates(apps []db.GetSaveCandidatesRow, now time.Time) []string {
+	type ranked struct {
+		pkg     string
+		score   float64
+		updated int64
+	}
+	var ranks []ranked
+	for _, app := range apps {
+		p := scorePriority(db.GetAppPrioritiesRow(app), PRIORITY_WEIGHTS, now)
+		ranks = append(ranks, ranked{app.Package, p.Score, app.MetaLastUpdated})
+	}
+	slices.SortStableFunc(ranks, func(a, b ranked) int {
+		if c := cmp.Compare(b.score, a.score); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.updated, a.updated)
+	})
+	pkgs := make([]string, len(ranks))
+	for i, r := range ranks {
+		pkgs[i] = r.pkg
+	}
+	return pkgs
+}
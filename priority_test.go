@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+func Test_scorePriority(t *testing.T) {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	weights := map[string]float64{
+		"never_archived":  100,
+		"stale_month":     10,
+		"delisted":        80,
+		"at_risk":         60,
+		"upstream_change": 30,
+		"failure":         -10,
+	}
+
+	fresh := db.GetAppPrioritiesRow{
+		MetaLastUpdated: now.AddDate(0, -6, 0).UnixMilli(),
+		LastSnapshotAt:  now.AddDate(0, 0, -3).UnixMilli(),
+	}
+	if p := scorePriority(fresh, weights, now); p.Score != 0 || p.Reasons != nil {
+		t.Errorf("fresh: %+v", p)
+	}
+
+	risky := db.GetAppPrioritiesRow{
+		MetaLastUpdated: now.AddDate(0, 0, -2).UnixMilli(),
+		SaveFailures:    2,
+		DelistedAt:      sql.NullInt64{Int64: now.UnixMilli(), Valid: true},
+		Alerts:          1,
+	}
+	p := scorePriority(risky, weights, now)
+	want := []string{"never archived (+100)", "delisted (+80)", "1 upstream alerts (+60)", "updated this month (+30)", "2 failed saves (-20)"}
+	if p.Score != 250 || !reflect.DeepEqual(p.Reasons, want) {
+		t.Errorf("risky: %+v", p)
+	}
+
+	// visits are in seconds, staleness is capped
+	stale := db.GetAppPrioritiesRow{
+		MetaLastUpdated: now.AddDate(-3, 0, 0).UnixMilli(),
+		LastVisitAt:     now.AddDate(-3, 0, 0).Unix(),
+		RefMoves:        2,
+	}
+	p = scorePriority(stale, weights, now)
+	want = []string{"last snapshot 12 months ago (+120)", "2 branches moved (+30)"}
+	if p.Score != 150 || !reflect.DeepEqual(p.Reasons, want) {
+		t.Errorf("stale: %+v", p)
+	}
+}
+
+func Test_GetAppPriorities_instance(t *testing.T) {
+	useTestDB(t)
+	for _, stmt := range []string{
+		`INSERT INTO origins (url, first_seen_at) VALUES ('https://example.org/app/', 1)`,
+		`INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code, origin_url) VALUES ('org.example', 1, 2, 'https://example.org/app', 'https://example.org/app/')`,
+		`INSERT INTO tasks (instance, id, save_request_status, save_task_status, snapshot_swhid, origin_url, created_at, finished_at)
+			VALUES ('staging', 1, 'accepted', 'succeeded', 'swh:1:snp:1111111111111111111111111111111111111111', 'https://example.org/app/', 1000, 2000)`,
+	} {
+		if _, err := dbWrite.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a snapshot on one instance says nothing about another
+	for instance, want := range map[string]int64{"staging": 2000, defaultSWHInstance: 0} {
+		rows, err := dbWriteSqlc.GetAppPriorities(context.Background(), db.GetAppPrioritiesParams{
+			Instance:   instance,
+			Instance_2: instance,
+			Package:    "org.example",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) != 1 || rows[0].LastSnapshotAt != want {
+			t.Errorf("%s: %+v, want last_snapshot_at %d", instance, rows, want)
+		}
+	}
+}
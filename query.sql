@@ -17,33 +17,578 @@ UPDATE apps SET meta_added = ?, meta_last_updated = ?, meta_source_code = ?
 WHERE package = ?;
 
 -- name: CreateOrUpdateApp :exec
-INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code)
-VALUES (?, ?, ?, ?)
+INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code, categories, listed_at, origin_url)
+VALUES (?, ?, ?, ?, ?, ?, ?)
 ON CONFLICT(package) DO UPDATE SET
     meta_added = excluded.meta_added,
     meta_last_updated = excluded.meta_last_updated,
-    meta_source_code = excluded.meta_source_code;
+    meta_source_code = excluded.meta_source_code,
+    categories = excluded.categories,
+    origin_url = excluded.origin_url,
+    listed_at = excluded.listed_at,
+    delisted_at = NULL,
+    -- an update may have fixed what kept failing
+    save_failures = CASE WHEN excluded.meta_last_updated > apps.meta_last_updated THEN 0 ELSE apps.save_failures END,
+    gave_up_at = CASE WHEN excluded.meta_last_updated > apps.meta_last_updated THEN NULL ELSE apps.gave_up_at END;
 
 -- name: UpdateLastSaveTriggered :exec
-UPDATE apps SET last_save_triggered = ?
+UPDATE apps SET last_save_triggered = ?, next_attempt_at = 0
+-- the save covers every app sharing the origin
+WHERE package = ? OR origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?);
+
+-- name: CreateOrigin :exec
+INSERT INTO origins (url, first_seen_at) VALUES (?, ?)
+ON CONFLICT(url) DO NOTHING;
+
+-- name: GetOriginPackages :many
+SELECT package FROM apps
+WHERE origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?)
+ORDER BY package;
+
+-- name: IsOriginQueued :one
+SELECT EXISTS(
+    SELECT 1 FROM queue JOIN apps ON apps.package = queue.package
+    WHERE queue.archiver = ? AND apps.origin_url = (SELECT a.origin_url FROM apps AS a WHERE a.package = ?)
+) AS queued;
+
+-- name: GetOriginStats :one
+SELECT COUNT(*) AS origins,
+    CAST(COALESCE(SUM(apps > 1), 0) AS INTEGER) AS shared,
+    CAST(COALESCE(SUM(CASE WHEN apps > 1 THEN apps ELSE 0 END), 0) AS INTEGER) AS sharing_apps
+FROM (SELECT origin_url, COUNT(*) AS apps FROM apps WHERE origin_url IS NOT NULL GROUP BY origin_url);
+
+-- name: MarkDelistedApps :execrows
+UPDATE apps SET delisted_at = sqlc.arg(delisted_at)
+WHERE delisted_at IS NULL AND listed_at < sqlc.arg(listed_at);
+
+-- name: GetSaveCandidates :many
+SELECT apps.package, apps.meta_source_code, apps.categories, apps.meta_last_updated,
+    apps.last_save_triggered, apps.save_failures, apps.next_attempt_at, apps.gave_up_at, apps.delisted_at,
+    CAST(COALESCE((SELECT MAX(COALESCE(tasks.finished_at, tasks.created_at)) FROM tasks
+        WHERE tasks.instance = ? AND tasks.origin_url = apps.origin_url AND tasks.snapshot_swhid IS NOT NULL), 0) AS INTEGER) AS last_snapshot_at,
+    CAST(COALESCE((SELECT MAX(CAST(strftime('%s', origin_visits.date) AS INTEGER)) FROM origin_visits
+        WHERE origin_visits.instance = ? AND origin_visits.origin_url = apps.origin_url AND origin_visits.status IN ('full', 'partial')), 0) AS INTEGER) AS last_visit_at,
+    CAST((SELECT COUNT(*) FROM ref_alerts WHERE ref_alerts.package = apps.package AND ref_alerts.detected_at > ?) AS INTEGER) AS alerts,
+    CAST((SELECT COUNT(*) FROM ref_moves WHERE ref_moves.origin_url = apps.origin_url) AS INTEGER) AS ref_moves
+FROM apps
+WHERE (apps.meta_last_updated > apps.last_save_triggered AND apps.next_attempt_at <= ?)
+    OR (apps.gave_up_at IS NULL AND apps.next_attempt_at > 0 AND apps.next_attempt_at <= ?)
+    -- possibly due for re-archiving, checked against each app's cadence
+    OR (apps.gave_up_at IS NULL AND apps.next_attempt_at <= ? AND apps.last_save_triggered < ?)
+LIMIT ?;
+
+-- name: GetAppPriorities :many
+SELECT apps.package, apps.meta_source_code, apps.categories, apps.meta_last_updated,
+    apps.last_save_triggered, apps.save_failures, apps.next_attempt_at, apps.gave_up_at, apps.delisted_at,
+    CAST(COALESCE((SELECT MAX(COALESCE(tasks.finished_at, tasks.created_at)) FROM tasks
+        WHERE tasks.instance = ? AND tasks.origin_url = apps.origin_url AND tasks.snapshot_swhid IS NOT NULL), 0) AS INTEGER) AS last_snapshot_at,
+    CAST(COALESCE((SELECT MAX(CAST(strftime('%s', origin_visits.date) AS INTEGER)) FROM origin_visits
+        WHERE origin_visits.instance = ? AND origin_visits.origin_url = apps.origin_url AND origin_visits.status IN ('full', 'partial')), 0) AS INTEGER) AS last_visit_at,
+    CAST((SELECT COUNT(*) FROM ref_alerts WHERE ref_alerts.package = apps.package AND ref_alerts.detected_at > ?) AS INTEGER) AS alerts,
+    CAST((SELECT COUNT(*) FROM ref_moves WHERE ref_moves.origin_url = apps.origin_url) AS INTEGER) AS ref_moves
+FROM apps
+WHERE apps.package LIKE ?;
+
+-- name: UpdateSaveFailure :exec
+UPDATE apps SET save_failures = ?, next_attempt_at = ?, last_error_class = ?, gave_up_at = ?
 WHERE package = ?;
 
--- name: GetAppNeedSave :many
-SELECT * FROM apps_ordered
-WHERE meta_last_updated > last_save_triggered LIMIT ?;
+-- name: DeferSave :exec
+UPDATE apps SET next_attempt_at = ?
+WHERE package = ?;
 
--- name: UpdateLastTaskId :exec
-UPDATE apps SET last_task_id = ?
+-- name: ResetSaveFailures :exec
+UPDATE apps SET save_failures = 0, next_attempt_at = 0, last_error_class = NULL, gave_up_at = NULL
+WHERE package = ?;
+
+-- name: RequeueApp :exec
+UPDATE apps SET save_failures = 0, next_attempt_at = ?, gave_up_at = NULL
+WHERE package = ?;
+
+-- name: ResetApp :exec
+UPDATE apps SET last_save_triggered = 0, save_failures = 0, next_attempt_at = 0, last_error_class = NULL, gave_up_at = NULL
+WHERE package = ?;
+
+-- name: ResetVersionSaveRetries :exec
+DELETE FROM version_save_retries
 WHERE package = ?;
 
+-- name: GetFailedApps :many
+SELECT package, meta_source_code, last_error_class FROM apps
+WHERE save_failures > 0
+ORDER BY package;
+
+-- name: UpdateLastTaskId :exec
+INSERT INTO app_tasks (package, instance, last_task_id)
+VALUES (?, ?, ?)
+ON CONFLICT(package, instance) DO UPDATE SET
+    last_task_id = excluded.last_task_id;
+
+-- name: GetAppTask :one
+SELECT tasks.* FROM app_tasks
+JOIN tasks ON tasks.instance = app_tasks.instance AND tasks.id = app_tasks.last_task_id
+WHERE app_tasks.package = ? AND app_tasks.instance = ? LIMIT 1;
+
+-- name: GetAppTasks :many
+SELECT app_tasks.package, tasks.* FROM app_tasks
+JOIN tasks ON tasks.instance = app_tasks.instance AND tasks.id = app_tasks.last_task_id
+WHERE app_tasks.instance = ?;
+
 -- name: GetTask :one
 SELECT * FROM tasks
-WHERE id = ? LIMIT 1;
+WHERE instance = ? AND id = ? LIMIT 1;
 
 -- name: CreateOrUpdateTask :exec
-INSERT INTO tasks (id, save_request_status, save_task_status, snapshot_swhid)
-VALUES (?, ?, ?, ?)
-ON CONFLICT(id) DO UPDATE SET
+INSERT INTO tasks (
+    instance, id, save_request_status, save_task_status, snapshot_swhid,
+    origin_url, visit_type, save_request_date, visit_date, visit_status,
+    loading_task_id, note, request_url, raw, created_at, updated_at, finished_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(instance, id) DO UPDATE SET
     save_request_status = excluded.save_request_status,
     save_task_status = excluded.save_task_status,
-    snapshot_swhid = excluded.snapshot_swhid;
\ No newline at end of file
+    snapshot_swhid = excluded.snapshot_swhid,
+    origin_url = excluded.origin_url,
+    visit_type = excluded.visit_type,
+    save_request_date = excluded.save_request_date,
+    visit_date = excluded.visit_date,
+    visit_status = excluded.visit_status,
+    loading_task_id = excluded.loading_task_id,
+    note = excluded.note,
+    request_url = excluded.request_url,
+    raw = excluded.raw,
+    updated_at = excluded.updated_at,
+    finished_at = COALESCE(tasks.finished_at, excluded.finished_at);
+
+-- name: GetTasksByOrigin :many
+SELECT * FROM tasks
+WHERE instance = ? AND origin_url = ?
+ORDER BY id DESC LIMIT ?;
+
+-- name: GetPendingTasks :many
+SELECT * FROM tasks
+WHERE instance = ? AND save_request_status != 'rejected'
+    AND save_task_status NOT IN ('succeeded', 'failed')
+    AND abandoned_at IS NULL AND next_poll_at <= ?
+ORDER BY next_poll_at LIMIT ?;
+
+-- name: UpdateTaskPollSchedule :exec
+UPDATE tasks SET next_poll_at = ?, poll_failures = ?
+WHERE instance = ? AND id = ?;
+
+-- name: AbandonTask :exec
+UPDATE tasks SET abandoned_at = ?, abandon_reason = ?, poll_failures = ?
+WHERE instance = ? AND id = ?;
+
+-- name: EnqueueApp :exec
+INSERT INTO queue (package, archiver, stage, target, enqueued_at)
+VALUES (?, ?, 'probe', ?, ?)
+ON CONFLICT(package, archiver) DO NOTHING;
+
+-- name: ClaimQueueItem :one
+UPDATE queue SET claimed_at = ?
+WHERE rowid = (
+    SELECT rowid FROM queue
+    WHERE stage = ? AND claimed_at = 0
+    ORDER BY enqueued_at LIMIT 1
+)
+RETURNING *;
+
+-- name: AdvanceQueueItem :exec
+UPDATE queue SET stage = ?, claimed_at = 0
+WHERE package = ? AND archiver = ?;
+
+-- name: DeleteQueueItem :exec
+DELETE FROM queue
+WHERE package = ? AND archiver = ?;
+
+-- name: ReleaseQueueClaims :exec
+UPDATE queue SET claimed_at = 0;
+
+-- name: CancelAppQueue :execrows
+DELETE FROM queue
+WHERE package = ? AND claimed_at = 0;
+
+-- name: CancelQueue :execrows
+DELETE FROM queue
+WHERE claimed_at = 0;
+
+-- name: CountQueue :many
+SELECT stage, COUNT(*) AS count FROM queue
+GROUP BY stage;
+
+-- name: DeleteUpstreamRefs :exec
+DELETE FROM upstream_refs
+WHERE origin_url = ?;
+
+-- name: CreateUpstreamRef :exec
+INSERT INTO upstream_refs (origin_url, name, target, seen_at)
+VALUES (?, ?, ?, ?);
+
+-- name: GetUpstreamRefs :many
+SELECT * FROM upstream_refs
+WHERE origin_url = ?
+ORDER BY name;
+
+-- name: GetUnverifiedTasks :many
+SELECT tasks.* FROM tasks
+LEFT JOIN snapshot_checks ON snapshot_checks.instance = tasks.instance AND snapshot_checks.task_id = tasks.id
+WHERE tasks.instance = ? AND tasks.save_task_status = 'succeeded'
+    AND tasks.snapshot_swhid IS NOT NULL AND snapshot_checks.task_id IS NULL
+    AND EXISTS (SELECT 1 FROM task_refs WHERE task_refs.instance = tasks.instance AND task_refs.task_id = tasks.id)
+ORDER BY tasks.finished_at LIMIT ?;
+
+-- name: CreateTaskRefs :exec
+INSERT INTO task_refs (instance, task_id, name, target)
+SELECT tasks.instance, tasks.id, upstream_refs.name, upstream_refs.target FROM tasks
+JOIN upstream_refs ON upstream_refs.origin_url = ?
+WHERE tasks.instance = ? AND tasks.id = ?
+ON CONFLICT DO NOTHING;
+
+-- name: GetTaskRefs :many
+SELECT * FROM task_refs
+WHERE instance = ? AND task_id = ?
+ORDER BY name;
+
+-- name: DeleteTaskRefs :exec
+DELETE FROM task_refs
+WHERE instance = ? AND task_id = ?;
+
+-- name: DeleteUnverifiableTaskRefs :execrows
+DELETE FROM task_refs
+WHERE instance = ? AND task_id IN (
+    SELECT id FROM tasks
+    WHERE tasks.instance = task_refs.instance
+        AND (save_request_status = 'rejected' OR save_task_status = 'failed' OR abandoned_at IS NOT NULL)
+);
+
+-- name: CreateSnapshotCheck :exec
+INSERT INTO snapshot_checks (
+    instance, task_id, origin_url, snapshot_swhid, head_swhid,
+    branches, tags, missing, complete, requeues, checked_at
+)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetLatestSnapshotCheck :one
+SELECT * FROM snapshot_checks
+WHERE instance = ? AND origin_url = ?
+ORDER BY checked_at DESC LIMIT 1;
+
+-- name: GetLatestSnapshotChecks :many
+SELECT snapshot_checks.* FROM snapshot_checks
+JOIN (SELECT origin_url, MAX(checked_at) AS checked_at FROM snapshot_checks WHERE instance = ? GROUP BY origin_url) AS latest
+    ON latest.origin_url = snapshot_checks.origin_url AND latest.checked_at = snapshot_checks.checked_at
+WHERE snapshot_checks.instance = ?;
+
+-- name: GetTaskPackages :many
+SELECT package FROM app_tasks
+WHERE instance = ? AND last_task_id = ?;
+
+-- name: CreateOrUpdateVersion :exec
+INSERT INTO versions (package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(package, version_code) DO UPDATE SET
+    version_name = excluded.version_name,
+    added = excluded.added,
+    file_name = excluded.file_name,
+    file_sha256 = excluded.file_sha256,
+    src_name = excluded.src_name,
+    src_sha256 = excluded.src_sha256;
+
+-- name: GetVersionsBySource :many
+SELECT versions.* FROM versions
+JOIN apps ON apps.package = versions.package
+WHERE apps.origin_url = ?;
+
+-- name: UpdateVersionRevision :exec
+UPDATE versions SET tag = ?, revision_swhid = ?, mapped_at = ?
+WHERE package = ? AND version_code = ?;
+
+-- name: GetUncheckedRevisions :many
+SELECT versions.revision_swhid FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid
+WHERE versions.revision_swhid IS NOT NULL
+    AND (known_swhids.swhid IS NULL OR (known_swhids.known = 0 AND known_swhids.checked_at < ?))
+GROUP BY versions.revision_swhid
+LIMIT ?;
+
+-- name: CreateOrUpdateKnownSwhid :exec
+INSERT INTO known_swhids (instance, swhid, known, checked_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(instance, swhid) DO UPDATE SET
+    known = excluded.known,
+    checked_at = excluded.checked_at;
+
+-- name: GetAppReleases :many
+SELECT versions.*, known_swhids.known FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid
+WHERE versions.package = ?
+ORDER BY versions.version_code DESC;
+
+-- name: GetReleaseCoverage :many
+SELECT versions.package, COUNT(*) AS total, COUNT(known_swhids.swhid) AS archived FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.revision_swhid AND known_swhids.known = 1
+GROUP BY versions.package;
+
+-- name: GetVersionsToHash :many
+SELECT * FROM versions
+WHERE src_name IS NOT NULL AND src_dir_swhid IS NULL AND src_hashed_at < ?
+ORDER BY added DESC LIMIT ?;
+
+-- name: UpdateVersionSourceSwhids :exec
+UPDATE versions SET src_cnt_swhid = ?, src_dir_swhid = ?, src_hashed_at = ?, src_error = ?
+WHERE package = ? AND version_code = ?;
+
+-- name: GetUncheckedSources :many
+SELECT versions.* FROM versions
+LEFT JOIN known_swhids ON known_swhids.instance = ? AND known_swhids.swhid = versions.src_dir_swhid
+WHERE versions.src_dir_swhid IS NOT NULL
+    AND (known_swhids.swhid IS NULL OR (known_swhids.known = 0 AND known_swhids.checked_at < ?))
+LIMIT ?;
+
+-- name: GetKnownSwhid :one
+SELECT * FROM known_swhids
+WHERE instance = ? AND swhid = ? LIMIT 1;
+
+-- name: GetSourcesToSave :many
+SELECT versions.* FROM versions
+JOIN known_swhids AS dir_known ON dir_known.instance = ? AND dir_known.swhid = versions.src_dir_swhid AND dir_known.known = 0
+LEFT JOIN known_swhids AS rev_known ON rev_known.instance = ? AND rev_known.swhid = versions.revision_swhid AND rev_known.known = 1
+LEFT JOIN version_tasks ON version_tasks.package = versions.package AND version_tasks.version_code = versions.version_code AND version_tasks.instance = ?
+LEFT JOIN tasks ON tasks.instance = version_tasks.instance AND tasks.id = version_tasks.last_task_id
+LEFT JOIN version_save_retries AS retries ON retries.package = versions.package AND retries.version_code = versions.version_code AND retries.instance = ?
+WHERE rev_known.swhid IS NULL
+    AND (version_tasks.last_task_id IS NULL OR tasks.save_request_status = 'rejected'
+        OR tasks.save_task_status = 'failed' OR tasks.abandoned_at IS NOT NULL)
+    AND retries.gave_up_at IS NULL AND (retries.next_attempt_at IS NULL OR retries.next_attempt_at <= ?)
+ORDER BY versions.added DESC LIMIT ?;
+
+-- name: GetTaskVersions :many
+SELECT versions.* FROM version_tasks
+JOIN versions ON versions.package = version_tasks.package AND versions.version_code = version_tasks.version_code
+WHERE version_tasks.instance = ? AND version_tasks.last_task_id = ?;
+
+-- name: GetVersionSaveRetry :one
+SELECT * FROM version_save_retries
+WHERE package = ? AND version_code = ? AND instance = ?;
+
+-- name: UpdateVersionSaveFailure :exec
+INSERT INTO version_save_retries (package, version_code, instance, failures, next_attempt_at, last_error_class, gave_up_at)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(package, version_code, instance) DO UPDATE SET
+    failures = excluded.failures,
+    next_attempt_at = excluded.next_attempt_at,
+    last_error_class = excluded.last_error_class,
+    gave_up_at = excluded.gave_up_at;
+
+-- name: DeleteVersionSaveRetry :exec
+DELETE FROM version_save_retries
+WHERE package = ? AND version_code = ? AND instance = ?;
+
+-- name: UpdateVersionTask :exec
+INSERT INTO version_tasks (package, version_code, instance, last_task_id)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(package, version_code, instance) DO UPDATE SET
+    last_task_id = excluded.last_task_id;
+
+-- name: GetVersionTask :one
+SELECT tasks.* FROM version_tasks
+JOIN tasks ON tasks.instance = version_tasks.instance AND tasks.id = version_tasks.last_task_id
+WHERE version_tasks.package = ? AND version_tasks.version_code = ? AND version_tasks.instance = ? LIMIT 1;
+
+-- name: GetVersionsToMirror :many
+SELECT * FROM versions
+WHERE (NOT apk_mirrored OR (src_name IS NOT NULL AND NOT src_mirrored)) AND mirrored_at < ?
+AND (SELECT COUNT(*) FROM versions AS newer WHERE newer.package = versions.package AND newer.version_code > versions.version_code) < sqlc.arg(keep)
+ORDER BY added DESC LIMIT ?;
+
+-- name: UpdateVersionMirror :exec
+UPDATE versions SET apk_mirrored = ?, src_mirrored = ?, mirrored_at = ?, mirror_error = ?
+WHERE package = ? AND version_code = ?;
+
+-- name: ExpireMirroredVersions :execrows
+UPDATE versions SET apk_mirrored = FALSE, src_mirrored = FALSE
+WHERE (apk_mirrored OR src_mirrored)
+AND (SELECT COUNT(*) FROM versions AS newer WHERE newer.package = versions.package AND newer.version_code > versions.version_code) >= sqlc.arg(keep);
+
+-- name: CreateMirrorBlob :exec
+INSERT INTO mirror_blobs (sha256, size, stored_at, verified_at) VALUES (?, ?, ?, ?)
+ON CONFLICT (sha256) DO UPDATE SET size = excluded.size, verified_at = excluded.verified_at;
+
+-- name: GetMirrorBlob :one
+SELECT * FROM mirror_blobs WHERE sha256 = ?;
+
+-- name: GetMirrorBlobs :many
+SELECT * FROM mirror_blobs ORDER BY verified_at;
+
+-- name: GetUnreferencedMirrorBlobs :many
+SELECT * FROM mirror_blobs
+WHERE NOT EXISTS (SELECT 1 FROM versions WHERE versions.apk_mirrored AND versions.file_sha256 = mirror_blobs.sha256)
+AND NOT EXISTS (SELECT 1 FROM versions WHERE versions.src_mirrored AND versions.src_sha256 = mirror_blobs.sha256);
+
+-- name: UpdateMirrorBlobVerified :exec
+UPDATE mirror_blobs SET verified_at = ? WHERE sha256 = ?;
+
+-- name: DeleteMirrorBlob :exec
+DELETE FROM mirror_blobs WHERE sha256 = ?;
+
+-- name: UnmirrorApk :exec
+UPDATE versions SET apk_mirrored = FALSE, mirrored_at = 0 WHERE file_sha256 = ?;
+
+-- name: UnmirrorSource :exec
+UPDATE versions SET src_mirrored = FALSE, mirrored_at = 0 WHERE src_sha256 = ?;
+
+-- name: GetMirrorUsage :one
+SELECT COUNT(*) AS blobs, CAST(COALESCE(SUM(size), 0) AS INTEGER) AS size FROM mirror_blobs;
+
+-- name: GetAppsByOrigin :many
+SELECT * FROM apps_ordered WHERE origin_url = ?;
+
+-- name: GetVersionByTag :one
+SELECT * FROM versions WHERE package = ? AND tag = ? LIMIT 1;
+
+-- name: CreateRefAlert :exec
+INSERT INTO ref_alerts (package, origin_url, kind, ref, before, after, release, detected_at)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetAppRefAlerts :many
+SELECT * FROM ref_alerts WHERE package = ?
+ORDER BY detected_at DESC, id DESC LIMIT ?;
+
+-- name: CountRefAlerts :many
+SELECT package, COUNT(*) AS alerts FROM ref_alerts
+GROUP BY package;
+
+-- name: CreateOrUpdateRefMove :exec
+INSERT INTO ref_moves (origin_url, ref, before, after, seen_at) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (origin_url, ref) DO UPDATE SET after = excluded.after, seen_at = excluded.seen_at;
+
+-- name: GetRefMoves :many
+SELECT * FROM ref_moves WHERE origin_url = ?
+ORDER BY seen_at LIMIT ?;
+
+-- name: DeleteRefMove :exec
+DELETE FROM ref_moves WHERE origin_url = ? AND ref = ?;
+
+-- name: GetStaleOrigins :many
+SELECT url FROM origins
+WHERE refs_checked_at < ?
+    AND EXISTS (SELECT 1 FROM upstream_refs WHERE upstream_refs.origin_url = origins.url)
+ORDER BY refs_checked_at LIMIT ?;
+
+-- name: UpdateOriginRefsChecked :exec
+UPDATE origins SET refs_checked_at = ? WHERE url = ?;
+
+-- name: BackfillLastSaveTriggered :exec
+UPDATE apps SET last_save_triggered = MAX(last_save_triggered, sqlc.arg(last_save_triggered))
+WHERE package = sqlc.arg(package);
+
+-- name: GetOriginsToFetchVisits :many
+SELECT origins.url FROM origins
+LEFT JOIN visit_fetches ON visit_fetches.instance = ? AND visit_fetches.origin_url = origins.url
+WHERE (visit_fetches.fetched_at IS NULL OR visit_fetches.fetched_at < ?)
+    AND EXISTS (SELECT 1 FROM apps WHERE apps.origin_url = origins.url)
+ORDER BY COALESCE(visit_fetches.fetched_at, 0) LIMIT ?;
+
+-- name: GetLastFinishedVisit :one
+SELECT CAST(COALESCE(MAX(visit), 0) AS INTEGER) AS visit FROM origin_visits
+WHERE instance = ? AND origin_url = ? AND status NOT IN ('created', 'ongoing');
+
+-- name: CreateOrUpdateOriginVisit :exec
+INSERT INTO origin_visits (instance, origin_url, visit, date, type, status, snapshot_swhid)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (instance, origin_url, visit) DO UPDATE SET
+    date = excluded.date,
+    type = excluded.type,
+    status = excluded.status,
+    snapshot_swhid = excluded.snapshot_swhid;
+
+-- name: UpdateVisitFetch :exec
+INSERT INTO visit_fetches (instance, origin_url, fetched_at) VALUES (?, ?, ?)
+ON CONFLICT (instance, origin_url) DO UPDATE SET fetched_at = excluded.fetched_at;
+
+-- name: GetOriginVisits :many
+SELECT * FROM origin_visits
+WHERE instance = ? AND origin_url = ?
+ORDER BY visit DESC LIMIT ?;
+
+-- name: GetAppVersions :many
+SELECT * FROM versions WHERE package = ?
+ORDER BY added DESC LIMIT ?;
+
+-- name: GetRestoreCandidates :many
+SELECT app_tasks.package, snapshot_checks.head_swhid FROM snapshot_checks
+JOIN app_tasks ON app_tasks.instance = snapshot_checks.instance AND app_tasks.last_task_id = snapshot_checks.task_id
+WHERE snapshot_checks.instance = ? AND snapshot_checks.head_swhid IS NOT NULL
+    AND NOT EXISTS (SELECT 1 FROM restore_tests WHERE restore_tests.instance = snapshot_checks.instance AND restore_tests.package = app_tasks.package AND restore_tests.requested_at > ?)
+ORDER BY RANDOM() LIMIT ?;
+
+-- name: CreateRestoreTest :exec
+INSERT INTO restore_tests (instance, package, swhid, status, requested_at)
+VALUES (?, ?, ?, 'pending', ?);
+
+-- name: GetPendingRestoreTests :many
+SELECT * FROM restore_tests
+WHERE instance = ? AND status = 'pending'
+ORDER BY requested_at;
+
+-- name: UpdateRestoreTest :exec
+UPDATE restore_tests SET status = ?, checked_at = ?, bytes = ?, error = ?
+WHERE id = ?;
+
+-- name: CountRestoreTestsSince :one
+SELECT COUNT(*) FROM restore_tests
+WHERE instance = ? AND requested_at > ?;
+
+-- name: GetRestoreTestStats :many
+SELECT status, COUNT(*) AS count FROM restore_tests
+WHERE instance = ? AND requested_at > ?
+GROUP BY status;
+
+-- name: CreateAttempt :exec
+INSERT INTO attempts (package, archiver, stage, target, job_id, started_at, finished_at, outcome, error_class, http_status, message)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);
+
+-- name: GetAppAttempts :many
+SELECT * FROM attempts
+WHERE package = ? OR target = ? OR target = ?
+ORDER BY started_at DESC LIMIT ?;
+
+-- name: GetLastAttempts :many
+SELECT * FROM attempts
+WHERE id IN (SELECT MAX(id) FROM attempts WHERE package IS NOT NULL GROUP BY package);
+
+-- name: CountFailedAttempts :many
+SELECT stage, error_class, COUNT(*) AS count FROM attempts
+WHERE outcome = 'failed' AND started_at > ?
+GROUP BY stage, error_class
+ORDER BY count DESC;
+
+-- name: DeleteAttemptsBefore :execrows
+DELETE FROM attempts WHERE started_at < ?;
+
+-- name: CreateAdminAction :exec
+INSERT INTO admin_actions (actor, action, package, filter, affected, created_at)
+VALUES (?, ?, ?, ?, ?, ?);
+
+-- name: GetAdminActions :many
+SELECT * FROM admin_actions
+ORDER BY id DESC
+LIMIT ?;
+
+-- name: PauseComponent :execrows
+INSERT INTO pauses (component, actor, reason, paused_at)
+VALUES (?, ?, ?, ?)
+ON CONFLICT(component) DO NOTHING;
+
+-- name: ResumeComponent :execrows
+DELETE FROM pauses
+WHERE component = ?;
+
+-- name: IsPaused :one
+SELECT EXISTS(SELECT 1 FROM pauses WHERE component = ?) AS paused;
+
+-- name: GetPauses :many
+SELECT * FROM pauses
+ORDER BY paused_at;
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimiter is a token bucket shared by every call to one rate-limited API.
+//
+// The bucket is refilled from the server's X-RateLimit-Limit,
+// X-RateLimit-Remaining and X-RateLimit-Reset headers instead of a local
+// guess, and a 429's Retry-After blocks every caller at once. Once the
+// remaining budget drops below slowdownRatio of the limit, calls are spread
+// evenly over what is left of the window so we run out at the reset, not
+// before it.
+type rateLimiter struct {
+	mu           sync.Mutex
+	limit        int // 0 if unknown
+	remaining    int // -1 if unknown
+	reset        time.Time
+	blockedUntil time.Time
+	nextCall     time.Time
+}
+
+const slowdownRatio = 0.5
+
+// RateBudget is a point-in-time view of a rateLimiter, for display.
+type RateBudget struct {
+	Limit        int
+	Remaining    int
+	Reset        time.Time
+	BlockedUntil time.Time
+}
+
+func newRateLimiter() *rateLimiter {
+	return &rateLimiter{remaining: -1}
+}
+
+// reserve takes a token and returns how long the caller has to wait before
+// using it.
+func (l *rateLimiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.reset.IsZero() && !now.Before(l.reset) {
+		// window is over, the server will tell us the new budget
+		l.remaining = -1
+		l.reset = time.Time{}
+	}
+
+	at := now
+	if at.Before(l.blockedUntil) {
+		at = l.blockedUntil
+	}
+
+	if l.remaining == 0 {
+		if l.reset.After(at) {
+			at = l.reset
+		}
+	} else if l.remaining > 0 && l.limit > 0 && float64(l.remaining) < float64(l.limit)*slowdownRatio && l.reset.After(now) {
+		interval := l.reset.Sub(now) / time.Duration(l.remaining)
+		if at.Before(l.nextCall) {
+			at = l.nextCall
+		}
+		l.nextCall = at.Add(interval)
+	}
+
+	if l.remaining > 0 {
+		l.remaining--
+	}
+	return at.Sub(now)
+}
+
+// availableAt returns when the next call could go out, without taking a
+// token.
+func (l *rateLimiter) availableAt(now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := now
+	if at.Before(l.blockedUntil) {
+		at = l.blockedUntil
+	}
+	if l.remaining == 0 && l.reset.After(at) {
+		at = l.reset
+	}
+	if at.Before(l.nextCall) {
+		at = l.nextCall
+	}
+	return at
+}
+
+// Wait blocks until the caller may make one call.
+func (l *rateLimiter) Wait(ctx context.Context) error {
+	delay := l.reserve(time.Now())
+	if delay > 0 {
+		sleepCtx(ctx, delay)
+	}
+	return ctx.Err()
+}
+
+// Update feeds the rate-limit headers of resp back into the bucket.
+func (l *rateLimiter) Update(resp *http.Response) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Limit")); err == nil {
+		l.limit = v
+	}
+	if v, err := strconv.Atoi(resp.Header.Get("X-RateLimit-Remaining")); err == nil {
+		l.remaining = v
+	}
+	if v, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+		l.reset = time.Unix(v, 0)
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		l.remaining = 0
+		until, ok := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+		if !ok {
+			until = l.reset
+		}
+		if until.IsZero() || !until.After(now) {
+			until = now.Add(time.Minute)
+		}
+		l.blockedUntil = until
+	}
+}
+
+// Budget returns the current state of the bucket.
+func (l *rateLimiter) Budget() RateBudget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return RateBudget{
+		Limit:        l.limit,
+		Remaining:    l.remaining,
+		Reset:        l.reset,
+		BlockedUntil: l.blockedUntil,
+	}
+}
+
+// Retry-After further out than this is not taken at its word
+const maxRetryAfter = 24 * time.Hour
+
+// parseRetryAfter handles both forms of Retry-After: delay in seconds and
+// HTTP date. Dates in the past mean now, and anything is held to
+// maxRetryAfter. ok is false if v is empty or invalid.
+func parseRetryAfter(v string, now time.Time) (until time.Time, ok bool) {
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		until = now.Add(time.Duration(secs) * time.Second)
+	} else if t, err := http.ParseTime(v); err == nil {
+		until = t
+	} else {
+		return time.Time{}, false
+	}
+	if until.Before(now) {
+		until = now
+	}
+	if limit := now.Add(maxRetryAfter); until.After(limit) {
+		until = limit
+	}
+	return until, true
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_rateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter()
+
+	if d := l.reserve(now); d != 0 {
+		t.Fatalf("unknown budget should not wait, got %v", d)
+	}
+
+	l.Update(&http.Response{StatusCode: http.StatusOK, Header: http.Header{
+		"X-Ratelimit-Limit":     {"100"},
+		"X-Ratelimit-Remaining": {"10"},
+		"X-Ratelimit-Reset":     {strconv.FormatInt(now.Add(100*time.Second).Unix(), 10)},
+	}})
+	first := l.reserve(now)
+	second := l.reserve(now)
+	if second <= first {
+		t.Fatalf("low budget should be paced, got %v then %v", first, second)
+	}
+
+	l.Update(&http.Response{StatusCode: http.StatusTooManyRequests, Header: http.Header{
+		"Retry-After": {"30"},
+	}})
+	if d := l.reserve(now); d < 29*time.Second {
+		t.Fatalf("429 should block for Retry-After, got %v", d)
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Time
+		ok     bool
+	}{
+		{"", time.Time{}, false},
+		{"120", now.Add(2 * time.Minute), true},
+		{"Wed, 01 Jan 2025 00:05:00 GMT", now.Add(5 * time.Minute), true},
+		{"Tue, 31 Dec 2024 23:00:00 GMT", now, true},
+		{"999999", now.Add(maxRetryAfter), true},
+		{"-5", time.Time{}, false},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseRetryAfter(tt.header, now)
+		if !got.Equal(tt.want) || ok != tt.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+var badPktLine = errors.New("malformed pkt-line")
+
+// readPktLine reads one pkt-line and returns its payload without the
+// trailing newline. flush is true for a flush-pkt ("0000").
+func readPktLine(r *bufio.Reader) (line string, flush bool, err error) {
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return "", false, err
+	}
+	n, err := strconv.ParseUint(string(size[:]), 16, 16)
+	if err != nil {
+		return "", false, badPktLine
+	}
+	if n == 0 {
+		return "", true, nil
+	}
+	if n < 4 {
+		return "", false, badPktLine
+	}
+	payload := make([]byte, n-4)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return "", false, err
+	}
+	return strings.TrimSuffix(string(payload), "\n"), false, nil
+}
+
+// parseRefAdvertisement parses a protocol v0 info/refs response of the smart
+// HTTP protocol into ref name → object id. Annotated tags also appear
+// peeled, as "refs/tags/<name>^{}" → the commit they point at. An empty
+// repository has no refs.
+func parseRefAdvertisement(r io.Reader) (map[string]string, error) {
+	br := bufio.NewReader(r)
+
+	// "# service=git-upload-pack" and its flush
+	line, _, err := readPktLine(br)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "# service=") {
+		return nil, fmt.Errorf("%w: no service header", badPktLine)
+	}
+	if _, flush, err := readPktLine(br); err != nil || !flush {
+		return nil, fmt.Errorf("%w: no flush after service header", badPktLine)
+	}
+
+	refs := map[string]string{}
+	for {
+		line, flush, err := readPktLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if flush {
+			return refs, nil
+		}
+		// the first ref carries the capabilities after a NUL
+		line, _, _ = strings.Cut(line, "\x00")
+		oid, name, ok := strings.Cut(line, " ")
+		if !ok {
+			return nil, fmt.Errorf("%w: %q", badPktLine, line)
+		}
+		if name == "capabilities^{}" {
+			continue
+		}
+		refs[name] = oid
+	}
+}
+
+// fetchUpstreamRefs lists the refs of a git repository over smart HTTP.
+func fetchUpstreamRefs(ctx context.Context, client *http.Client, sourceCode string) (map[string]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", swhOriginURL(sourceCode)+"info/refs?service=git-upload-pack", nil)
+	if err != nil {
+		return nil, err
+	}
+	// no Git-Protocol header: v2 would only advertise capabilities
+	req.Header.Set("User-Agent", "fdroidswh-git")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET info/refs: %s", resp.Status)
+	}
+	return parseRefAdvertisement(resp.Body)
+}
+
+// recordUpstreamRefs replaces the refs we know of an origin.
+func recordUpstreamRefs(ctx context.Context, origin string, refs map[string]string, seen time.Time) error {
+	tx, err := dbWrite.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := dbWriteSqlc.WithTx(tx)
+	if err := q.DeleteUpstreamRefs(ctx, origin); err != nil {
+		return err
+	}
+	for name, target := range refs {
+		if err := q.CreateUpstreamRef(ctx, db.CreateUpstreamRefParams{
+			OriginUrl: origin,
+			Name:      name,
+			Target:    target,
+			SeenAt:    seen.UnixMilli(),
+		}); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
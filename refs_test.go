@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func pktLine(s string) string {
+	return fmt.Sprintf("%04x%s", len(s)+4, s)
+}
+
+func Test_parseRefAdvertisement(t *testing.T) {
+	body := pktLine("# service=git-upload-pack\n") + "0000" +
+		pktLine("1111111111111111111111111111111111111111 HEAD\x00multi_ack symref=HEAD:refs/heads/main\n") +
+		pktLine("1111111111111111111111111111111111111111 refs/heads/main\n") +
+		pktLine("2222222222222222222222222222222222222222 refs/tags/v1.0\n") +
+		pktLine("3333333333333333333333333333333333333333 refs/tags/v1.0^{}\n") +
+		"0000"
+
+	refs, err := parseRefAdvertisement(strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"HEAD":              "1111111111111111111111111111111111111111",
+		"refs/heads/main":   "1111111111111111111111111111111111111111",
+		"refs/tags/v1.0":    "2222222222222222222222222222222222222222",
+		"refs/tags/v1.0^{}": "3333333333333333333333333333333333333333",
+	}
+	if !maps.Equal(refs, want) {
+		t.Errorf("got %v, want %v", refs, want)
+	}
+
+	empty := pktLine("# service=git-upload-pack\n") + "0000" +
+		pktLine("0000000000000000000000000000000000000000 capabilities^{}\x00multi_ack\n") + "0000"
+	refs, err = parseRefAdvertisement(strings.NewReader(empty))
+	if err != nil || len(refs) != 0 {
+		t.Errorf("empty repository: got %v, %v", refs, err)
+	}
+
+	if _, err := parseRefAdvertisement(strings.NewReader("<html>")); err == nil {
+		t.Error("accepted a non-pkt-line body")
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+const (
+	// SWH's known API takes at most this many SWHIDs per call
+	knownBatchSize = 1000
+	// SWHIDs SWH did not know yet are asked about again after this long
+	knownRecheckInterval = 24 * time.Hour
+)
+
+// tagVersion reduces a tag or version name to what is compared when matching
+// releases to tags: lower case, without a leading "v", "release-",
+// "<app>-" or similar.
+func tagVersion(name string) string {
+	name = strings.ToLower(name)
+	if i := strings.IndexFunc(name, func(r rune) bool { return r >= '0' && r <= '9' }); i > 0 {
+		name = name[i:]
+	}
+	return name
+}
+
+// matchTag finds the upstream tag of a release and the commit it points at.
+// An exact versionName tag wins over a normalized match, which wins over a
+// tag named after the versionCode. Returns "" if no tag matches.
+func matchTag(refs map[string]string, v Version) (tag, commit string) {
+	var tags []string
+	for name := range refs {
+		if t, ok := strings.CutPrefix(name, "refs/tags/"); ok && !strings.HasSuffix(t, "^{}") {
+			tags = append(tags, t)
+		}
+	}
+	// shortest, then alphabetical, so the choice is stable
+	slices.SortFunc(tags, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	})
+
+	code := strconv.FormatInt(v.VersionCode, 10)
+	for _, match := range []func(t string) bool{
+		func(t string) bool { return v.VersionName != "" && t == v.VersionName },
+		func(t string) bool { return v.VersionName != "" && tagVersion(t) == tagVersion(v.VersionName) },
+		func(t string) bool { return tagVersion(t) == code },
+	} {
+		for _, t := range tags {
+			if !match(t) {
+				continue
+			}
+			// annotated tags point at the tag object, the peeled ref at the commit
+			if peeled, ok := refs["refs/tags/"+t+"^{}"]; ok {
+				return t, peeled
+			}
+			return t, refs["refs/tags/"+t]
+		}
+	}
+	return "", ""
+}
+
+// mapReleases matches the releases of the apps whose origin is sourceCode
+// to the tags in refs. The index does not carry build commits, so tags are
+// all we have to go on.
+func mapReleases(ctx context.Context, sourceCode string, refs map[string]string, now time.Time) error {
+	versions, err := dbWriteSqlc.GetVersionsBySource(ctx, sql.NullString{String: swhOriginURL(sourceCode), Valid: true})
+	if err != nil {
+		return err
+	}
+
+	matched := 0
+	for _, version := range versions {
+		tag, commit := matchTag(refs, Version{VersionCode: version.VersionCode, VersionName: version.VersionName})
+		if tag != "" {
+			matched++
+		}
+		if err := dbWriteSqlc.UpdateVersionRevision(ctx, db.UpdateVersionRevisionParams{
+			Tag:           sql.NullString{String: tag, Valid: tag != ""},
+			RevisionSwhid: sql.NullString{String: "swh:1:rev:" + commit, Valid: commit != ""},
+			MappedAt:      now.UnixMilli(),
+			Package:       version.Package,
+			VersionCode:   version.VersionCode,
+		}); err != nil {
+			return err
+		}
+	}
+	if len(versions) > 0 {
+		slog.Info("releases mapped", "sourceCode", sourceCode, "versions", len(versions), "matched", matched)
+	}
+	return nil
+}
+
+type knownResp map[string]struct {
+	Known bool `json:"known"`
+}
+
+// fetchKnown asks SWH which of swhids it has archived.
+func fetchKnown(ctx context.Context, c *swhClient, swhids []string) (map[string]bool, error) {
+	var resp knownResp
+	if err := c.call(ctx, "POST", c.api+"known/", swhids, &resp); err != nil {
+		return nil, err
+	}
+	known := make(map[string]bool, len(swhids))
+	for _, swhid := range swhids {
+		known[swhid] = resp[swhid].Known
+	}
+	return known, nil
+}
+
+// CheckReleases looks up the revisions of mapped releases and the source
+// trees of hashed ones with the known API. Returns how many SWHIDs it looked
+// up.
+func (a *swhArchiver) CheckReleases(ctx context.Context) (int, error) {
+	now := time.Now()
+	recheckBefore := now.Add(-knownRecheckInterval).UnixMilli()
+
+	revisions, err := dbWriteSqlc.GetUncheckedRevisions(ctx, db.GetUncheckedRevisionsParams{
+		Instance:  a.instance,
+		CheckedAt: recheckBefore,
+		Limit:     knownBatchSize / 3,
+	})
+	if err != nil {
+		return 0, err
+	}
+	sources, err := dbWriteSqlc.GetUncheckedSources(ctx, db.GetUncheckedSourcesParams{
+		Instance:  a.instance,
+		CheckedAt: recheckBefore,
+		Limit:     knownBatchSize / 3,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	var swhids []string
+	for _, revision := range revisions {
+		swhids = append(swhids, revision.String)
+	}
+	for _, source := range sources {
+		swhids = append(swhids, source.SrcDirSwhid.String)
+		if source.SrcCntSwhid.Valid {
+			swhids = append(swhids, source.SrcCntSwhid.String)
+		}
+	}
+	if len(swhids) == 0 {
+		return 0, nil
+	}
+
+	known, err := fetchKnown(ctx, a.swh, swhids)
+	if err != nil {
+		return 0, err
+	}
+
+	for swhid, ok := range known {
+		if err := dbWriteSqlc.CreateOrUpdateKnownSwhid(ctx, db.CreateOrUpdateKnownSwhidParams{
+			Instance:  a.instance,
+			Swhid:     swhid,
+			Known:     ok,
+			CheckedAt: now.UnixMilli(),
+		}); err != nil {
+			return 0, err
+		}
+	}
+	slog.Info("SWHIDs checked", "archiver", a.Name(), "revisions", len(revisions), "sources", len(sources))
+	return len(swhids), nil
+}
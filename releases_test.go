@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func Test_matchTag(t *testing.T) {
+	refs := map[string]string{
+		"refs/heads/main":      "aaaa",
+		"refs/tags/v1.2.0":     "1111",
+		"refs/tags/v1.2.0^{}":  "1112",
+		"refs/tags/1.3":        "1300",
+		"refs/tags/myapp-1.3":  "1301",
+		"refs/tags/Release_42": "4200",
+	}
+
+	for _, tt := range []struct {
+		version     Version
+		tag, commit string
+	}{
+		{Version{VersionName: "1.2.0", VersionCode: 120}, "v1.2.0", "1112"},
+		{Version{VersionName: "1.3", VersionCode: 130}, "1.3", "1300"},
+		{Version{VersionName: "myapp-1.3", VersionCode: 130}, "myapp-1.3", "1301"},
+		{Version{VersionName: "2.0-beta", VersionCode: 42}, "Release_42", "4200"},
+		{Version{VersionName: "9.9", VersionCode: 990}, "", ""},
+	} {
+		tag, commit := matchTag(refs, tt.version)
+		if tag != tt.tag || commit != tt.commit {
+			t.Errorf("matchTag(%+v) = %q, %q, want %q, %q", tt.version, tag, commit, tt.tag, tt.commit)
+		}
+	}
+}
+
+func Test_mapReleases(t *testing.T) {
+	useTestDB(t)
+	// the app's source is spelled without the slash we push it with
+	for _, stmt := range []string{
+		`INSERT INTO origins (url, first_seen_at) VALUES ('https://example.org/app/', 1)`,
+		`INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code, origin_url) VALUES ('org.example', 1, 2, 'https://example.org/app', 'https://example.org/app/')`,
+		`INSERT INTO versions (package, version_code, version_name, added, file_name, file_sha256) VALUES ('org.example', 120, '1.2.0', 1, 'a.apk', 'x')`,
+	} {
+		if _, err := dbWrite.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	refs := map[string]string{"refs/tags/v1.2.0": "1111"}
+	if err := mapReleases(context.Background(), "https://example.org/app/", refs, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	var tag string
+	if err := dbWrite.QueryRow("SELECT tag FROM versions WHERE package = 'org.example'").Scan(&tag); err != nil {
+		t.Fatal(err)
+	}
+	if tag != "v1.2.0" {
+		t.Fatalf("tag = %q", tag)
+	}
+}
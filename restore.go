@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// RESTORE_TESTS_PER_DAY archived apps per SWH instance are cooked from the
+// Vault and checked each day, 0 turns restore testing off.
+var RESTORE_TESTS_PER_DAY = 5
+
+const (
+	// an app is tested at most once in this long
+	restoreRetestInterval = 30 * 24 * time.Hour
+	// cooks still not done after this long count as failed
+	restoreCookTimeout = 48 * time.Hour
+)
+
+var errCommitNotInBundle = errors.New("commit not in bundle")
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("RESTORE_TESTS_PER_DAY")); err == nil && n >= 0 {
+		RESTORE_TESTS_PER_DAY = n
+	}
+}
+
+type vaultResp struct {
+	FetchURL        string  `json:"fetch_url"`
+	Status          string  `json:"status"`
+	ProgressMessage *string `json:"progress_message"`
+}
+
+// findPackIdx looks up an object id in a version 2 pack index.
+func findPackIdx(idx []byte, id [sha1.Size]byte) (bool, error) {
+	const header = 8
+	const fanout = 256 * 4
+	if len(idx) < header+fanout || !bytes.Equal(idx[:8], []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}) {
+		return false, errors.New("not a version 2 pack index")
+	}
+	fan := func(i int) int { return int(binary.BigEndian.Uint32(idx[header+i*4:])) }
+	lo, hi := 0, fan(int(id[0]))
+	if id[0] > 0 {
+		lo = fan(int(id[0]) - 1)
+	}
+	names := idx[header+fanout:]
+	if len(names) < fan(255)*sha1.Size {
+		return false, errors.New("truncated pack index")
+	}
+	for lo < hi {
+		mid := (lo + hi) / 2
+		switch c := bytes.Compare(names[mid*sha1.Size:(mid+1)*sha1.Size], id[:]); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = mid + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// checkLooseCommit inflates a loose object and checks it is the commit it is
+// named after.
+func checkLooseCommit(r io.Reader, commit string) error {
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return err
+	}
+	if !bytes.HasPrefix(data, []byte("commit ")) {
+		return fmt.Errorf("object %s is not a commit", commit)
+	}
+	if sum := sha1.Sum(data); hex.EncodeToString(sum[:]) != commit {
+		return fmt.Errorf("object %s is corrupt", commit)
+	}
+	return nil
+}
+
+// checkBundle reads a git_bare bundle, a tarball of a bare repository,
+// gzipped or not, and checks that it holds commit, loose or packed.
+func checkBundle(r io.Reader, commit string) error {
+	var id [sha1.Size]byte
+	if n, err := hex.Decode(id[:], []byte(commit)); err != nil || n != sha1.Size {
+		return fmt.Errorf("bad commit id %q", commit)
+	}
+	loose := "/objects/" + commit[:2] + "/" + commit[2:]
+
+	br := bufio.NewReader(r)
+	var tr *tar.Reader
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		tr = tar.NewReader(gz)
+	} else {
+		tr = tar.NewReader(br)
+	}
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return errCommitNotInBundle
+		}
+		if err != nil {
+			return err
+		}
+		name := "/" + strings.TrimPrefix(hdr.Name, "./")
+		switch {
+		case strings.HasSuffix(name, loose):
+			return checkLooseCommit(tr, commit)
+		case strings.Contains(name, "/objects/pack/") && strings.HasSuffix(name, ".idx"):
+			idx, err := io.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			found, err := findPackIdx(idx, id)
+			if err != nil {
+				return fmt.Errorf("%s: %w", hdr.Name, err)
+			}
+			if found {
+				return nil
+			}
+		}
+	}
+}
+
+// TestRestores moves pending restore tests along and starts new ones, up to
+// RESTORE_TESTS_PER_DAY a day. Returns how many tests finished.
+func (a *swhArchiver) TestRestores(ctx context.Context) (int, error) {
+	now := time.Now()
+	pending, err := dbWriteSqlc.GetPendingRestoreTests(ctx, a.instance)
+	if err != nil {
+		return 0, err
+	}
+	finished := 0
+	for _, test := range pending {
+		done, err := a.checkRestore(ctx, test, now)
+		if errors.Is(err, context.Canceled) {
+			return finished, err
+		}
+		if err != nil {
+			slog.Warn("checkRestore", "archiver", a.Name(), "package", test.Package, "err", err)
+			continue
+		}
+		if done {
+			finished++
+		}
+	}
+
+	started, err := dbWriteSqlc.CountRestoreTestsSince(ctx, db.CountRestoreTestsSinceParams{
+		Instance:    a.instance,
+		RequestedAt: now.Add(-24 * time.Hour).UnixMilli(),
+	})
+	if err != nil {
+		return finished, err
+	}
+	if started >= int64(RESTORE_TESTS_PER_DAY) {
+		return finished, nil
+	}
+	// one at a time, so the day's tests are spread out
+	candidates, err := dbWriteSqlc.GetRestoreCandidates(ctx, db.GetRestoreCandidatesParams{
+		Instance:    a.instance,
+		RequestedAt: now.Add(-restoreRetestInterval).UnixMilli(),
+		Limit:       1,
+	})
+	if err != nil {
+		return finished, err
+	}
+	for _, candidate := range candidates {
+		var resp vaultResp
+		if err := a.swh.call(ctx, "POST", a.swh.api+"vault/git-bare/"+candidate.HeadSwhid.String+"/", nil, &resp); err != nil {
+			return finished, err
+		}
+		slog.Info("restore test requested", "archiver", a.Name(), "package", candidate.Package, "swhid", candidate.HeadSwhid.String, "status", resp.Status)
+		if err := dbWriteSqlc.CreateRestoreTest(ctx, db.CreateRestoreTestParams{
+			Instance:    a.instance,
+			Package:     candidate.Package,
+			Swhid:       candidate.HeadSwhid.String,
+			RequestedAt: now.UnixMilli(),
+		}); err != nil {
+			return finished, err
+		}
+	}
+	return finished, nil
+}
+
+// checkRestore asks the Vault how a cook is going and, once it is done,
+// downloads and checks the bundle. Returns whether the test is over.
+func (a *swhArchiver) checkRestore(ctx context.Context, test db.RestoreTest, now time.Time) (bool, error) {
+	finish := func(size int64, testErr error) (bool, error) {
+		status := "passed"
+		var msg sql.NullString
+		if testErr != nil {
+			status = "failed"
+			msg = sql.NullString{String: testErr.Error(), Valid: true}
+			slog.Warn("restore test failed", "archiver", a.Name(), "package", test.Package, "swhid", test.Swhid, "err", testErr)
+		} else {
+			slog.Info("restore test passed", "archiver", a.Name(), "package", test.Package, "swhid", test.Swhid, "bytes", size)
+		}
+		return true, dbWriteSqlc.UpdateRestoreTest(ctx, db.UpdateRestoreTestParams{
+			Status:    status,
+			CheckedAt: sql.NullInt64{Int64: now.UnixMilli(), Valid: true},
+			Bytes:     sql.NullInt64{Int64: size, Valid: size > 0},
+			Error:     msg,
+			ID:        test.ID,
+		})
+	}
+
+	var resp vaultResp
+	err := a.swh.call(ctx, "GET", a.swh.api+"vault/git-bare/"+test.Swhid+"/", nil, &resp)
+	if errors.Is(err, swhNotFound) {
+		return finish(0, errors.New("cook not found in the vault"))
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch resp.Status {
+	case "done":
+	case "failed":
+		msg := "cook failed"
+		if resp.ProgressMessage != nil {
+			msg += ": " + *resp.ProgressMessage
+		}
+		return finish(0, errors.New(msg))
+	default:
+		if now.Sub(time.UnixMilli(test.RequestedAt)) > restoreCookTimeout {
+			return finish(0, fmt.Errorf("cook still %s after %s", resp.Status, restoreCookTimeout))
+		}
+		return false, nil
+	}
+
+	f, err := os.CreateTemp("", "fdroidswh-bundle-*")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	fetchURL := resp.FetchURL
+	if fetchURL == "" {
+		fetchURL = a.swh.api + "vault/git-bare/" + test.Swhid + "/raw/"
+	}
+	if err := a.swh.call(ctx, "GET", fetchURL, nil, f); err != nil {
+		// a bundle that can't be fetched is no better than a cook that
+		// never finishes
+		if !errors.Is(err, context.Canceled) && now.Sub(time.UnixMilli(test.RequestedAt)) > restoreCookTimeout {
+			return finish(0, fmt.Errorf("download still failing after %s: %w", restoreCookTimeout, err))
+		}
+		return false, err
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return false, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	return finish(size, checkBundle(f, strings.TrimPrefix(test.Swhid, "swh:1:rev:")))
+}
+
+// restorer is implemented by backends that can hand archived data back, so
+// we can check it is really there.
+type restorer interface {
+	// TestRestores advances and starts restore tests and returns how many
+	// finished.
+	TestRestores(ctx context.Context) (int, error)
+}
+
+// restoreTester runs the restore tests of every archiver that has them.
+func restoreTester(ctx context.Context, wg *sync.WaitGroup, archivers []Archiver) {
+	defer wg.Done()
+	slog.Info("restoreTester start", "perDay", RESTORE_TESTS_PER_DAY)
+	defer slog.Info("restoreTester exit")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "restorer") {
+			return
+		}
+
+		for _, a := range archivers {
+			t, ok := a.(restorer)
+			if !ok {
+				continue
+			}
+			if _, err := t.TestRestores(ctx); err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("TestRestores", "archiver", a.Name(), "err", err)
+			}
+		}
+		sleepCtx(ctx, 10*time.Minute)
+	}
+}
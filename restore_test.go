@@ -0,0 +1,141 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+func Test_checkBundle(t *testing.T) {
+	object := []byte("commit 11\x00tree abcdef")
+	sum := sha1.Sum(object)
+	commit := hex.EncodeToString(sum[:])
+	var loose bytes.Buffer
+	zw := zlib.NewWriter(&loose)
+	zw.Write(object)
+	zw.Close()
+
+	// a version 2 pack index listing packed and two other ids
+	packed := sha1.Sum([]byte("packed"))
+	ids := [][sha1.Size]byte{packed, sha1.Sum([]byte("a")), sha1.Sum([]byte("b"))}
+	slices.SortFunc(ids, func(a, b [sha1.Size]byte) int { return bytes.Compare(a[:], b[:]) })
+	idx := []byte{0xff, 't', 'O', 'c', 0, 0, 0, 2}
+	for i := range 256 {
+		n := 0
+		for _, id := range ids {
+			if int(id[0]) <= i {
+				n++
+			}
+		}
+		idx = binary.BigEndian.AppendUint32(idx, uint32(n))
+	}
+	for _, id := range ids {
+		idx = append(idx, id[:]...)
+	}
+
+	tarball := func(files map[string][]byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		for name, content := range files {
+			tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+			tw.Write(content)
+		}
+		tw.Close()
+		return &buf
+	}
+	bundle := func(files map[string][]byte) *bytes.Buffer {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(tarball(files).Bytes())
+		gz.Close()
+		return &buf
+	}
+
+	loosePath := "repo.git/objects/" + commit[:2] + "/" + commit[2:]
+	if err := checkBundle(bundle(map[string][]byte{loosePath: loose.Bytes()}), commit); err != nil {
+		t.Errorf("loose commit: %v", err)
+	}
+	if err := checkBundle(bundle(map[string][]byte{"repo.git/objects/pack/pack-1.idx": idx}), hex.EncodeToString(packed[:])); err != nil {
+		t.Errorf("packed commit: %v", err)
+	}
+	if err := checkBundle(tarball(map[string][]byte{loosePath: loose.Bytes()}), commit); err != nil {
+		t.Errorf("uncompressed bundle: %v", err)
+	}
+	missing := sha1.Sum([]byte("missing"))
+	if err := checkBundle(bundle(map[string][]byte{"repo.git/objects/pack/pack-1.idx": idx}), hex.EncodeToString(missing[:])); !errors.Is(err, errCommitNotInBundle) {
+		t.Errorf("missing commit: got %v", err)
+	}
+
+	var corrupt bytes.Buffer
+	zw = zlib.NewWriter(&corrupt)
+	zw.Write([]byte("commit 11\x00tree 000000"))
+	zw.Close()
+	if err := checkBundle(bundle(map[string][]byte{loosePath: corrupt.Bytes()}), commit); err == nil {
+		t.Error("corrupt commit passed")
+	}
+}
+
+func Test_checkRestore_downloadFailing(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/raw/") {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"status": "done"}`))
+	}))
+	defer srv.Close()
+	a := &swhArchiver{
+		instance: defaultSWHInstance,
+		swh:      newSWHClient(srv.Client(), srv.URL+"/api/1/", newTokenPool([]string{"token"})),
+	}
+
+	if _, err := dbWrite.Exec(`INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code) VALUES ('org.example', 1, 2, '')`); err != nil {
+		t.Fatal(err)
+	}
+	requested := time.Now()
+	if err := dbWriteSqlc.CreateRestoreTest(ctx, db.CreateRestoreTestParams{
+		Instance:    a.instance,
+		Package:     "org.example",
+		Swhid:       "swh:1:rev:1111111111111111111111111111111111111111",
+		RequestedAt: requested.UnixMilli(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tests, err := dbWriteSqlc.GetPendingRestoreTests(ctx, a.instance)
+	if err != nil || len(tests) != 1 {
+		t.Fatal(tests, err)
+	}
+
+	if over, err := a.checkRestore(ctx, tests[0], requested.Add(time.Hour)); over || err == nil {
+		t.Fatalf("failed download ended the test early: %v, %v", over, err)
+	}
+	if over, err := a.checkRestore(ctx, tests[0], requested.Add(restoreCookTimeout+time.Hour)); !over || err != nil {
+		t.Fatalf("failed download outlived the deadline: %v, %v", over, err)
+	}
+	if tests, err := dbWriteSqlc.GetPendingRestoreTests(ctx, a.instance); err != nil || len(tests) != 0 {
+		t.Fatal("test still pending", err)
+	}
+	var status, msg string
+	if err := dbWrite.QueryRow("SELECT status, error FROM restore_tests").Scan(&status, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if status != "failed" || !strings.Contains(msg, "download") {
+		t.Fatal(status, msg)
+	}
+}
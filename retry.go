@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// retryPolicy is how failed saves of one kind are retried: after base, then
+// twice as long after each further failure up to max, giving up after
+// attempts failures in a row.
+type retryPolicy struct {
+	base     time.Duration
+	max      time.Duration
+	attempts int
+}
+
+var (
+	// network trouble, SWH or forge outages, token problems: likely gone
+	// in a while
+	transientRetry = retryPolicy{base: time.Hour, max: 7 * 24 * time.Hour, attempts: 10}
+	// the repository is gone or is not git: only a few checks in case it
+	// comes back
+	permanentRetry = retryPolicy{base: 24 * time.Hour, max: 14 * 24 * time.Hour, attempts: 3}
+	// SWH rejected the request or its loader failed
+	rejectedRetry = retryPolicy{base: 6 * time.Hour, max: 7 * 24 * time.Hour, attempts: 5}
+)
+
+// retryPolicyOf picks the policy for an error class from classifyError.
+func retryPolicyOf(class string) retryPolicy {
+	switch class {
+	case "not_found", "invalid_repo", "client_error":
+		return permanentRetry
+	case "archive_failed":
+		return rejectedRetry
+	}
+	return transientRetry
+}
+
+// retryDelay returns how long to wait before the next save after failures
+// failed ones in a row, spread by up to a fifth either way according to
+// jitter in [0, 1) so apps that failed together are not retried together.
+// ok is false once the policy gives up.
+func retryDelay(class string, failures int, jitter float64) (time.Duration, bool) {
+	p := retryPolicyOf(class)
+	if failures >= p.attempts {
+		return 0, false
+	}
+	delay := p.max
+	if shift := failures - 1; shift < 30 && p.base<<shift < p.max {
+		delay = p.base << shift
+	}
+	return time.Duration(float64(delay) * (0.8 + 0.4*jitter)), true
+}
+
+// saveFailed schedules the next save of pkg after a failed one, or gives up
+// on it.
+func saveFailed(ctx context.Context, pkg string, saveErr error, now time.Time) {
+	app, err := dbWriteSqlc.GetApp(ctx, pkg)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("GetApp", "package", pkg, "err", err)
+		}
+		return
+	}
+
+	class, _ := classifyError(saveErr)
+	failures := app.SaveFailures + 1
+	params := db.UpdateSaveFailureParams{
+		SaveFailures:   failures,
+		LastErrorClass: sql.NullString{String: class, Valid: true},
+		Package:        pkg,
+	}
+	if delay, ok := retryDelay(class, int(failures), rand.Float64()); ok {
+		params.NextAttemptAt = now.Add(delay).UnixMilli()
+		slog.Info("save retry scheduled", "package", pkg, "class", class, "failures", failures, "in", delay.Round(time.Minute))
+	} else {
+		params.GaveUpAt = sql.NullInt64{Int64: now.UnixMilli(), Valid: true}
+		slog.Warn("save gave up", "package", pkg, "class", class, "failures", failures)
+	}
+	if err := dbWriteSqlc.UpdateSaveFailure(ctx, params); err != nil {
+		slog.Error("UpdateSaveFailure", "package", pkg, "err", err)
+	}
+}
+
+// saveDeferred schedules the next save of pkg at until without counting a
+// failure, for saves that could not be tried.
+func saveDeferred(ctx context.Context, pkg string, until time.Time) {
+	if err := dbWriteSqlc.DeferSave(ctx, db.DeferSaveParams{
+		NextAttemptAt: until.UnixMilli(),
+		Package:       pkg,
+	}); err != nil {
+		slog.Error("DeferSave", "package", pkg, "err", err)
+	}
+}
+
+// saveSucceeded clears the failures of pkg once a save went through.
+func saveSucceeded(ctx context.Context, pkg string) {
+	if err := dbWriteSqlc.ResetSaveFailures(ctx, pkg); err != nil {
+		slog.Error("ResetSaveFailures", "package", pkg, "err", err)
+	}
+}
+
+// settleSaves settles the retries of the apps a finished job of a saved.
+func settleSaves(ctx context.Context, a Archiver, pkgs []string, st ArchiveStatus) {
+	for _, pkg := range pkgs {
+		if st.Failed {
+			saveFailed(ctx, pkg, statusErr(a, st), time.Now())
+		} else {
+			saveSucceeded(ctx, pkg)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_retryDelay(t *testing.T) {
+	tests := []struct {
+		class    string
+		failures int
+		jitter   float64
+		want     time.Duration
+		ok       bool
+	}{
+		{"network", 1, 0.5, time.Hour, true},
+		{"network", 3, 0.5, 4 * time.Hour, true},
+		{"network", 3, 0, 4 * time.Hour * 8 / 10, true},
+		{"server_error", 9, 0.5, 7 * 24 * time.Hour, true},
+		{"server_error", 10, 0.5, 0, false},
+		{"not_found", 2, 0.5, 48 * time.Hour, true},
+		{"invalid_repo", 3, 0.5, 0, false},
+		{"archive_failed", 1, 0.5, 6 * time.Hour, true},
+		{"archive_failed", 5, 0.5, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryDelay(tt.class, tt.failures, tt.jitter)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("retryDelay(%s, %d, %v) = %v, %v, want %v, %v", tt.class, tt.failures, tt.jitter, got, ok, tt.want, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// REF_WATCH_HOURS is how often the refs of every known origin are fetched
+// again to catch rewrites between saves, 0 turns watching off.
+var REF_WATCH_HOURS = 24
+
+const (
+	refWatchBatchSize = 50
+	// how far back the new commit's history is walked looking for the old one
+	revisionLogLimit = 1000
+	// moved branches checked per verified snapshot
+	refMovesPerCheck = 5
+)
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("REF_WATCH_HOURS")); err == nil && n >= 0 {
+		REF_WATCH_HOURS = n
+	}
+}
+
+// RefChange is a difference between two observations of an origin's refs.
+// Kind is one of tag_deleted, tag_moved, branch_deleted, branch_moved or
+// emptied. Before and After are commits, After is "" if the ref is gone.
+type RefChange struct {
+	Kind   string
+	Ref    string
+	Before string
+	After  string
+}
+
+// alerting tells whether a change is reported as is. Moved branches are
+// normal unless the old commit is no longer in their history, which is only
+// known once SWH archived the new one.
+func (c RefChange) alerting() bool {
+	return c.Kind != "branch_moved"
+}
+
+// refCommit is the commit a ref points at, looking through annotated tags.
+func refCommit(refs map[string]string, name string) string {
+	if peeled, ok := refs[name+"^{}"]; ok {
+		return peeled
+	}
+	return refs[name]
+}
+
+// diffRefs lists the branches and tags that were deleted or moved between
+// two observations. A repository that lost all its refs is reported once as
+// emptied, not ref by ref.
+func diffRefs(before, after map[string]string) []RefChange {
+	if len(before) > 0 && len(after) == 0 {
+		return []RefChange{{Kind: "emptied", Before: refCommit(before, "HEAD")}}
+	}
+
+	var changes []RefChange
+	for name := range before {
+		var kind string
+		switch {
+		case strings.HasSuffix(name, "^{}"):
+			continue
+		case strings.HasPrefix(name, "refs/heads/"):
+			kind = "branch"
+		case strings.HasPrefix(name, "refs/tags/"):
+			kind = "tag"
+		default:
+			continue
+		}
+		old := refCommit(before, name)
+		if _, ok := after[name]; !ok {
+			changes = append(changes, RefChange{Kind: kind + "_deleted", Ref: name, Before: old})
+			continue
+		}
+		if cur := refCommit(after, name); cur != old {
+			changes = append(changes, RefChange{Kind: kind + "_moved", Ref: name, Before: old, After: cur})
+		}
+	}
+	slices.SortFunc(changes, func(a, b RefChange) int { return strings.Compare(a.Ref, b.Ref) })
+	return changes
+}
+
+// observeRefs records the refs just fetched from sourceCode and compares them
+// with the previous observation. Deleted and moved tags, deleted branches and
+// emptied repositories are recorded as alerts on every app built from it;
+// moved branches are kept to be checked for rewritten history. Returns the
+// changes that raised alerts.
+func observeRefs(ctx context.Context, sourceCode string, refs map[string]string, now time.Time) ([]RefChange, error) {
+	origin := swhOriginURL(sourceCode)
+	rows, err := dbWriteSqlc.GetUpstreamRefs(ctx, origin)
+	if err != nil {
+		return nil, err
+	}
+	prev := map[string]string{}
+	for _, row := range rows {
+		prev[row.Name] = row.Target
+	}
+
+	var alerts []RefChange
+	for _, change := range diffRefs(prev, refs) {
+		if change.alerting() {
+			alerts = append(alerts, change)
+			continue
+		}
+		if err := dbWriteSqlc.CreateOrUpdateRefMove(ctx, db.CreateOrUpdateRefMoveParams{
+			OriginUrl: origin,
+			Ref:       change.Ref,
+			Before:    change.Before,
+			After:     change.After,
+			SeenAt:    now.UnixMilli(),
+		}); err != nil {
+			return nil, err
+		}
+	}
+	if err := recordRefAlerts(ctx, origin, alerts, now); err != nil {
+		return nil, err
+	}
+	if err := recordUpstreamRefs(ctx, origin, refs, now); err != nil {
+		return nil, err
+	}
+	if err := dbWriteSqlc.UpdateOriginRefsChecked(ctx, db.UpdateOriginRefsCheckedParams{
+		RefsCheckedAt: now.UnixMilli(),
+		Url:           origin,
+	}); err != nil {
+		return nil, err
+	}
+	return alerts, nil
+}
+
+// recordRefAlerts files changes as alerts on each app built from origin,
+// naming the release a tag belonged to.
+func recordRefAlerts(ctx context.Context, origin string, changes []RefChange, now time.Time) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	apps, err := dbWriteSqlc.GetAppsByOrigin(ctx, sql.NullString{String: swhOriginURL(origin), Valid: true})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		for _, change := range changes {
+			var release sql.NullString
+			if tag, ok := strings.CutPrefix(change.Ref, "refs/tags/"); ok {
+				version, err := dbWriteSqlc.GetVersionByTag(ctx, db.GetVersionByTagParams{
+					Package: app.Package,
+					Tag:     sql.NullString{String: tag, Valid: true},
+				})
+				if err == nil {
+					release = sql.NullString{String: version.VersionName, Valid: true}
+				} else if !errors.Is(err, sql.ErrNoRows) {
+					return err
+				}
+			}
+			slog.Warn("upstream ref alert", "package", app.Package, "kind", change.Kind, "ref", change.Ref,
+				"before", change.Before, "after", change.After, "release", release.String)
+			if err := dbWriteSqlc.CreateRefAlert(ctx, db.CreateRefAlertParams{
+				Package:    app.Package,
+				OriginUrl:  origin,
+				Kind:       change.Kind,
+				Ref:        change.Ref,
+				Before:     change.Before,
+				After:      sql.NullString{String: change.After, Valid: change.After != ""},
+				Release:    release,
+				DetectedAt: now.UnixMilli(),
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type revisionLogEntry struct {
+	ID string `json:"id"`
+}
+
+// inHistory walks the archived history of commit looking for ancestor. ok
+// is false if the walk stopped at revisionLogLimit without an answer.
+func inHistory(ctx context.Context, c *swhClient, commit, ancestor string) (found, ok bool, err error) {
+	var log []revisionLogEntry
+	if err := c.call(ctx, "GET", c.api+"revision/"+commit+"/log/?limit="+strconv.Itoa(revisionLogLimit), nil, &log); err != nil {
+		return false, false, err
+	}
+	for _, rev := range log {
+		if rev.ID == ancestor {
+			return true, true, nil
+		}
+	}
+	return false, len(log) < revisionLogLimit, nil
+}
+
+// checkRefMoves looks at the branches of origin that moved upstream and are
+// now archived at their new commit. A branch whose old commit is not in the
+// new commit's history was force-pushed over, and is reported as rewritten.
+func (a *swhArchiver) checkRefMoves(ctx context.Context, origin string, branches map[string]*SnapshotBranch) error {
+	moves, err := dbWriteSqlc.GetRefMoves(ctx, db.GetRefMovesParams{
+		OriginUrl: origin,
+		Limit:     refMovesPerCheck,
+	})
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, move := range moves {
+		b := resolveBranch(branches, move.Ref)
+		if b == nil || b.TargetType != "revision" || b.Target != move.After {
+			// not archived at the new commit yet
+			continue
+		}
+		found, ok, err := inHistory(ctx, a.swh, move.After, move.Before)
+		if err != nil {
+			return err
+		}
+		switch {
+		case !ok:
+			slog.Info("branch history too long to check", "origin", origin, "ref", move.Ref)
+		case !found:
+			if err := recordRefAlerts(ctx, origin, []RefChange{{
+				Kind:   "history_rewritten",
+				Ref:    move.Ref,
+				Before: move.Before,
+				After:  move.After,
+			}}, now); err != nil {
+				return err
+			}
+		}
+		if err := dbWriteSqlc.DeleteRefMove(ctx, db.DeleteRefMoveParams{
+			OriginUrl: origin,
+			Ref:       move.Ref,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refWatcher fetches the refs of origins not checked for REF_WATCH_HOURS, so
+// deleted tags and emptied repositories are noticed even when the index does
+// not change. Apps whose origin raised an alert are saved right away, while
+// what is left of their history is still reachable.
+func refWatcher(ctx context.Context, wg *sync.WaitGroup, client *http.Client, archivers []Archiver) {
+	defer wg.Done()
+	slog.Info("refWatcher start", "hours", REF_WATCH_HOURS)
+	defer slog.Info("refWatcher exit")
+
+	interval := time.Duration(REF_WATCH_HOURS) * time.Hour
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "refwatcher") {
+			return
+		}
+
+		origins, err := dbWriteSqlc.GetStaleOrigins(ctx, db.GetStaleOriginsParams{
+			RefsCheckedAt: time.Now().Add(-interval).UnixMilli(),
+			Limit:         refWatchBatchSize,
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("GetStaleOrigins", "err", err)
+		}
+		if len(origins) == 0 {
+			sleepCtx(ctx, 10*time.Minute)
+			continue
+		}
+
+		for _, origin := range origins {
+			if err := watchOrigin(ctx, client, archivers, origin); err != nil {
+				if errors.Is(err, context.Canceled) {
+					return
+				}
+				slog.Warn("watchOrigin", "origin", origin, "err", err)
+			}
+		}
+	}
+}
+
+func watchOrigin(ctx context.Context, client *http.Client, archivers []Archiver, origin string) error {
+	now := time.Now()
+	refs, err := fetchUpstreamRefs(ctx, client, origin)
+	if err != nil {
+		// keep the last refs as they were seen, an unreachable forge is not
+		// a deleted tag; try again next round
+		if err := dbWriteSqlc.UpdateOriginRefsChecked(ctx, db.UpdateOriginRefsCheckedParams{
+			RefsCheckedAt: now.UnixMilli(),
+			Url:           origin,
+		}); err != nil {
+			return err
+		}
+		return err
+	}
+	alerts, err := observeRefs(ctx, origin, refs, now)
+	if err != nil {
+		return err
+	}
+	if len(alerts) == 0 || len(refs) == 0 {
+		// nothing new to save from an empty repository
+		return nil
+	}
+
+	apps, err := dbWriteSqlc.GetAppsByOrigin(ctx, sql.NullString{String: swhOriginURL(origin), Valid: true})
+	if err != nil {
+		return err
+	}
+	for _, app := range apps {
+		enqueueSave(ctx, archivers, app, now)
+	}
+	slog.Info("apps enqueued after ref alerts", "origin", origin, "alerts", len(alerts), "apps", len(apps))
+	return nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func Test_diffRefs(t *testing.T) {
+	a, b, c, d := "aaaa", "bbbb", "cccc", "dddd"
+	before := map[string]string{
+		"HEAD":                  a,
+		"refs/heads/main":       a,
+		"refs/heads/old":        b,
+		"refs/tags/v1":          "t1",
+		"refs/tags/v1^{}":       b,
+		"refs/tags/v2":          c,
+		"refs/tags/v3":          "t3",
+		"refs/tags/v3^{}":       c,
+		"refs/pull/1/head":      d,
+		"refs/tags/unchanged":   d,
+		"refs/tags/retagged":    "t4",
+		"refs/tags/retagged^{}": d,
+	}
+	after := map[string]string{
+		"HEAD":                c,
+		"refs/heads/main":     c,
+		"refs/tags/v1":        "t1",
+		"refs/tags/v1^{}":     b,
+		"refs/tags/v3":        "t5",
+		"refs/tags/v3^{}":     a,
+		"refs/tags/unchanged": d,
+		// re-created as an annotated tag on the same commit
+		"refs/tags/retagged":    "t6",
+		"refs/tags/retagged^{}": d,
+	}
+
+	want := []RefChange{
+		{Kind: "branch_moved", Ref: "refs/heads/main", Before: a, After: c},
+		{Kind: "branch_deleted", Ref: "refs/heads/old", Before: b},
+		{Kind: "tag_deleted", Ref: "refs/tags/v2", Before: c},
+		{Kind: "tag_moved", Ref: "refs/tags/v3", Before: c, After: a},
+	}
+	if got := diffRefs(before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffRefs() = %+v, want %+v", got, want)
+	}
+
+	if got := diffRefs(before, map[string]string{}); !reflect.DeepEqual(got, []RefChange{{Kind: "emptied", Before: a}}) {
+		t.Errorf("emptied: got %+v", got)
+	}
+	if got := diffRefs(map[string]string{}, after); len(got) != 0 {
+		t.Errorf("first observation: got %+v", got)
+	}
+}
@@ -3,31 +3,19 @@ package main
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
 	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"slices"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/joho/godotenv"
 	"github.com/saveweb/fdroidswh/db"
 )
 
-var SWH_TOKEN string
-
-func init() {
-	godotenv.Load()
-	SWH_TOKEN = os.Getenv("SWH_TOKEN")
-	if SWH_TOKEN == "" {
-		panic("SWH_TOKEN is empty")
-	}
-}
-
 func validateGitUrl(ctx context.Context, client *http.Client, sourceCode string) (bool, error) {
 	if !strings.HasSuffix(sourceCode, "/") {
 		sourceCode = sourceCode + "/"
@@ -68,6 +56,10 @@ func validateGitUrl(ctx context.Context, client *http.Client, sourceCode string)
 		}
 		defer resp.Body.Close()
 
+		// the forge is down, which says nothing about the repository
+		if resp.StatusCode >= 500 {
+			return false, &HTTPError{Status: resp.StatusCode, Method: "GET", URL: refsURL}
+		}
 		if resp.Header.Get("Content-Type") != "application/x-git-upload-pack-advertisement" {
 			return false, errors.New("Content-Type is not x-git-upload-pack-advertisement")
 		} else {
@@ -78,245 +70,368 @@ func validateGitUrl(ctx context.Context, client *http.Client, sourceCode string)
 	return false, errors.New("retries exceeded")
 }
 
-type TaskResp struct {
-	// The request ID
-	ID int32 `json:"id" validate:"required"`
-	// not created, pending, scheduled, running, succeeded or failed
-	SaveTaskStatus string `json:"save_task_status" validate:"oneof='not created' pending scheduled running succeeded failed"`
-	// accepted, rejected or pending
-	SaveRequestStatus string `json:"save_request_status" validate:"oneof=accepted rejected pending"`
-	// snapshot_swhid (null if it is missing or unknown)
-	SnapshotSwhid string `json:"snapshot_swhid"`
-	RequestUrl    string `json:"request_url" validate:"http_url"`
-}
-
-var RateLimited = errors.New("too many requests")
-
-func pushSWH(ctx context.Context, client *http.Client, sourceCode string) (TaskResp, error) {
-	var TaskResp TaskResp
-	if !strings.HasSuffix(sourceCode, "/") {
-		sourceCode = sourceCode + "/"
-	}
+var notValidGitUrl = errors.New("the sourceCode is not a valid git url")
 
-	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
-	defer cancel()
+var (
+	PROBE_WORKERS  = 10
+	SUBMIT_WORKERS = 2
+)
 
-	pushURL := "https://archive.softwareheritage.org/api/1/origin/save/git/url/" + sourceCode
-	req, err := http.NewRequestWithContext(ctx, "POST", pushURL, nil)
-	if err != nil {
-		return TaskResp, err
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("PROBE_WORKERS")); err == nil && n > 0 {
+		PROBE_WORKERS = n
 	}
-
-	req.Header.Set("Authorization", "Bearer "+SWH_TOKEN)
-	req.Header.Set("User-Agent", "fdroidswh-git")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return TaskResp, err
+	if n, err := strconv.Atoi(os.Getenv("SUBMIT_WORKERS")); err == nil && n > 0 {
+		SUBMIT_WORKERS = n
 	}
-	defer resp.Body.Close()
+}
 
-	// X-RateLimit-Remaining
-	slog.Info("pushSWH", "X-RateLimit-Remaining", resp.Header.Get("X-RateLimit-Remaining"))
+// saver moves apps through the save pipeline:
+//
+//	scheduler -> queue (probe) -> probe workers -> queue (submit) -> submit workers -> poller
+//
+// The queue lives in the database, so a restart resumes where the last run
+// stopped, and every stage has its own workers: a slow forge or a long SWH
+// task never keeps new submissions waiting. Release source tarballs skip the
+// queue: they come from F-Droid and were checked when they were hashed.
+func saver(ctx context.Context, wg *sync.WaitGroup, archivers []Archiver) {
+	defer wg.Done()
+	slog.Info("saver start")
+	defer slog.Info("saver exit")
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return TaskResp, RateLimited
+	byName := make(map[string]Archiver)
+	for _, a := range archivers {
+		byName[a.Name()] = a
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		return TaskResp, errors.New("push failed")
+	// items a previous run was working on when it stopped
+	if err := dbWriteSqlc.ReleaseQueueClaims(ctx); err != nil {
+		slog.Error("ReleaseQueueClaims", "err", err)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&TaskResp); err != nil {
-		return TaskResp, err
+	stages := &sync.WaitGroup{}
+	for range PROBE_WORKERS {
+		stages.Add(1)
+		go stageWorker(ctx, stages, "probe", byName, probeItem)
 	}
-
-	return TaskResp, nil
-}
-
-func fetchTaskStatus(ctx context.Context, client *http.Client, requestUrl string) (TaskResp, error) {
-	var TaskResp TaskResp
-
-	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", requestUrl, nil)
-	if err != nil {
-		return TaskResp, err
+	for range SUBMIT_WORKERS {
+		stages.Add(1)
+		go stageWorker(ctx, stages, "submit", byName, submitItem)
 	}
 
-	req.Header.Set("Authorization", "Bearer "+SWH_TOKEN)
-	req.Header.Set("User-Agent", "fdroidswh-git")
+	stages.Add(1)
+	go sourceScheduler(ctx, stages, archivers)
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return TaskResp, err
-	}
-	defer resp.Body.Close()
+	scheduler(ctx, archivers)
+	stages.Wait()
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return TaskResp, errors.New("get task status failed")
-	}
+// schedulerWake cuts the scheduler's idle wait short, e.g. after an admin
+// made apps due.
+var schedulerWake = make(chan struct{}, 1)
 
-	if err := json.NewDecoder(resp.Body).Decode(&TaskResp); err != nil {
-		return TaskResp, err
+// wakeScheduler has the scheduler look for due apps right away.
+func wakeScheduler() {
+	select {
+	case schedulerWake <- struct{}{}:
+	default:
 	}
-
-	return TaskResp, nil
 }
 
-func saveTaskRespToDB(ctx context.Context, taskResp TaskResp) error {
-	return dbWriteSqlc.CreateOrUpdateTask(ctx, db.CreateOrUpdateTaskParams{
-		ID:                int64(taskResp.ID),
-		SaveTaskStatus:    taskResp.SaveTaskStatus,
-		SaveRequestStatus: taskResp.SaveRequestStatus,
-		SnapshotSwhid:     sql.NullString{String: taskResp.SnapshotSwhid, Valid: taskResp.SnapshotSwhid != ""},
-	})
-}
-
-var notValidGitUrl = errors.New("the sourceCode is not a valid git url")
+// scheduler enqueues apps that need a save, highest priority first, keeping
+// the queue short so urgent apps are not stuck behind a long backlog.
+func scheduler(ctx context.Context, archivers []Archiver) {
+	const batchSize = 100
+	// apps ranked per round; more than this wait for the next ones
+	const candidates = 10000
+	// apps are saved to every instance but ranked by how stale they are
+	// on the primary one
+	instance := primaryInstance(archivers)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "saver") {
+			return
+		}
 
-func validateAndPushToSWH(ctx context.Context, client *http.Client, pkg, sourceCode string) error {
-	var ok bool
-	var err error
+		pruneAttempts(ctx, time.Now())
 
-	for range 3 {
-		ok, err = validateGitUrl(ctx, client, sourceCode)
+		queued, err := queueLength(ctx)
 		if err != nil {
-			slog.Warn("retrying validateGitUrl", "sourceCode", sourceCode, "err", err)
+			slog.Error("queueLength", "err", err)
+			sleepCtx(ctx, time.Minute)
+			continue
+		}
+		if queued >= batchSize {
+			sleepCtx(ctx, time.Minute)
 			continue
 		}
-		break
-	}
 
-	if !ok {
-		if err != nil && !errors.Is(err, context.Canceled) {
-			return errors.Join(err, notValidGitUrl)
+		now := time.Now()
+		// apps not saved for the shortest cadence might be due for
+		// re-archiving, saveDue checks their own
+		var rearchiveCutoff int64
+		if cadence := minCadence(); cadence > 0 {
+			rearchiveCutoff = now.Add(-cadence).UnixMilli()
+		}
+		rows, err := dbWriteSqlc.GetSaveCandidates(ctx, db.GetSaveCandidatesParams{
+			Instance:          instance,
+			Instance_2:        instance,
+			DetectedAt:        now.Add(-priorityRecent).UnixMilli(),
+			NextAttemptAt:     now.UnixMilli(),
+			NextAttemptAt_2:   now.UnixMilli(),
+			NextAttemptAt_3:   now.UnixMilli(),
+			LastSaveTriggered: rearchiveCutoff,
+			Limit:             candidates,
+		})
+		if err != nil {
+			slog.Error("GetSaveCandidates", "err", err)
+			sleepCtx(ctx, time.Minute)
+			continue
+		}
+		var apps []db.GetSaveCandidatesRow
+		for _, row := range rows {
+			if saveDue(row, now) {
+				apps = append(apps, row)
+			}
+		}
+		if len(apps) == 0 {
+			slog.Info("no app need save")
+			select {
+			case <-ctx.Done():
+			case <-schedulerWake:
+			case <-time.After(10 * time.Minute):
+			}
+			continue
 		}
 
-		return notValidGitUrl
+		pkgs := rankSaveCandidates(apps, now)
+		pkgs = pkgs[:min(len(pkgs), batchSize)]
+		for _, pkg := range pkgs {
+			app, err := dbWriteSqlc.GetApp(ctx, pkg)
+			if err != nil {
+				slog.Error("GetApp", "package", pkg, "err", err)
+				continue
+			}
+			enqueueSave(ctx, archivers, db.AppsOrdered(app), time.Now())
+		}
+		slog.Info("apps enqueued", "count", len(pkgs), "waiting", len(apps)-len(pkgs))
 	}
+}
 
-	// ok
-	slog.Info("validateGitUrl ok", "sourceCode", sourceCode)
-	var taskResp TaskResp
-	for i := 0; i < 3; i++ {
-		taskResp, err = pushSWH(ctx, client, sourceCode)
+// enqueueSave queues app for every archiver that has a target for it,
+// unless another app of the same origin is queued already: one save covers
+// them all. It returns how many queue items it added.
+func enqueueSave(ctx context.Context, archivers []Archiver, app db.AppsOrdered, now time.Time) int {
+	enqueued := 0
+	for _, a := range archivers {
+		target := a.Target(app)
+		if target == "" {
+			continue
+		}
+		queued, err := dbWriteSqlc.IsOriginQueued(ctx, db.IsOriginQueuedParams{
+			Archiver: a.Name(),
+			Package:  app.Package,
+		})
 		if err != nil {
-			if errors.Is(err, RateLimited) {
-				slog.Warn("pushSWH rate limited", "sourceCode", sourceCode, "err", err)
-				i -= 1 // always retry
-				sleepCtx(ctx, 300*time.Second)
-				continue
-			} else if errors.Is(err, context.Canceled) {
-				return err
-			}
-			slog.Warn("retrying pushSWH", "sourceCode", sourceCode, "err", err)
-			sleepCtx(ctx, 10*time.Second)
+			slog.Error("IsOriginQueued", "package", app.Package, "err", err)
+			continue
+		}
+		if queued != 0 {
+			slog.Debug("origin already queued", "archiver", a.Name(), "package", app.Package)
+			continue
+		}
+		if err := dbWriteSqlc.EnqueueApp(ctx, db.EnqueueAppParams{
+			Package:    app.Package,
+			Archiver:   a.Name(),
+			Target:     target,
+			EnqueuedAt: now.UnixMilli(),
+		}); err != nil {
+			slog.Error("EnqueueApp", "package", app.Package, "err", err)
 			continue
 		}
-		break
+		enqueued++
 	}
 
-	if err != nil {
-		slog.Warn("pushSWH failed", "sourceCode", sourceCode, "err", err)
-		return err
-	}
+	dbWriteSqlc.UpdateLastSaveTriggered(ctx, db.UpdateLastSaveTriggeredParams{
+		Package:           app.Package,
+		Package_2:         app.Package,
+		LastSaveTriggered: now.UnixMilli(),
+	})
+	return enqueued
+}
 
-	// ok
-	slog.Info("pushSWH action ok", "sourceCode", sourceCode, "taskResp", taskResp)
-	// save task to db
-	if err := saveTaskRespToDB(ctx, taskResp); err != nil {
-		return err
-	}
-	// update last task id
-	if err := dbWriteSqlc.UpdateLastTaskId(ctx, db.UpdateLastTaskIdParams{
-		Package:    pkg,
-		LastTaskID: sql.NullInt64{Int64: int64(taskResp.ID), Valid: true},
-	}); err != nil {
-		return err
-	}
+// sourceArchiver is implemented by backends that archive release source
+// tarballs on their own.
+type sourceArchiver interface {
+	// SaveSources submits up to limit tarballs and returns how many it
+	// submitted.
+	SaveSources(ctx context.Context, limit int) (int, error)
+}
 
-	if taskResp.SaveRequestStatus == "rejected" {
-		slog.Warn("pushSWH rejected", "sourceCode", sourceCode, "err", err)
-		return errors.New("pushSWH rejected")
-	}
+// sourceScheduler submits release source tarballs that are not archived
+// yet, in small batches so the app pipeline keeps its share of the API.
+func sourceScheduler(ctx context.Context, wg *sync.WaitGroup, archivers []Archiver) {
+	defer wg.Done()
+	const batchSize = 10
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "saver") {
+			return
+		}
 
-	for !slices.Contains([]string{"succeeded", "failed"}, taskResp.SaveTaskStatus) {
-		sleepCtx(ctx, 10*time.Second)
-		newTaskResp, err := fetchTaskStatus(ctx, client, taskResp.RequestUrl)
-		if err != nil {
-			if errors.Is(err, RateLimited) {
-				slog.Warn("fetchTaskStatus rate limited", "sourceCode", sourceCode, "err", err)
-				sleepCtx(ctx, 60*time.Second)
+		saved := 0
+		for _, a := range archivers {
+			s, ok := a.(sourceArchiver)
+			if !ok {
 				continue
-			} else if errors.Is(err, context.Canceled) {
-				return err
 			}
-
-			slog.Warn("retrying fetchTaskStatus", "sourceCode", sourceCode, "err", err)
-			sleepCtx(ctx, 20*time.Second)
-			continue
+			n, err := s.SaveSources(ctx, batchSize)
+			if err != nil && !errors.Is(err, context.Canceled) {
+				slog.Error("SaveSources", "archiver", a.Name(), "err", err)
+			}
+			saved += n
 		}
 
-		taskResp = newTaskResp
-		slog.Info("fetchTaskStatus ok", "sourceCode", sourceCode, "taskResp", taskResp)
-		if err := saveTaskRespToDB(ctx, taskResp); err != nil {
-			return err
+		if saved == 0 {
+			sleepCtx(ctx, 10*time.Minute)
+		} else {
+			sleepCtx(ctx, time.Minute)
 		}
-		continue
 	}
+}
 
-	return nil
+func queueLength(ctx context.Context) (int64, error) {
+	counts, err := dbWriteSqlc.CountQueue(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, c := range counts {
+		total += c.Count
+	}
+	return total, nil
 }
 
-func saver(ctx context.Context, wg *sync.WaitGroup, client *http.Client) {
+// stageHandler works on one claimed queue item and returns the stage it
+// moves to next, or "" when the item is done.
+type stageHandler func(ctx context.Context, a Archiver, item db.Queue) (string, error)
+
+func stageWorker(ctx context.Context, wg *sync.WaitGroup, stage string, archivers map[string]Archiver, handle stageHandler) {
 	defer wg.Done()
-	const batchSize = 100
 	for {
 		select {
 		case <-ctx.Done():
 			return
 		default:
 		}
+		if !waitWhilePaused(ctx, "saver") {
+			return
+		}
 
-		apps, err := dbWriteSqlc.GetAppNeedSave(ctx, batchSize)
-		if err != nil {
-			slog.Error("GetAppNeedSave", "err", err)
+		item, err := dbWriteSqlc.ClaimQueueItem(ctx, db.ClaimQueueItemParams{
+			ClaimedAt: time.Now().UnixMilli(),
+			Stage:     stage,
+		})
+		if errors.Is(err, sql.ErrNoRows) {
+			sleepCtx(ctx, 5*time.Second)
 			continue
-		}
-		if len(apps) == 0 {
-			slog.Info("no app need save")
-			sleepCtx(ctx, 10*time.Minute)
+		} else if err != nil {
+			if !errors.Is(err, context.Canceled) {
+				slog.Error("ClaimQueueItem", "stage", stage, "err", err)
+			}
+			sleepCtx(ctx, 5*time.Second)
 			continue
 		}
-		sem := make(chan struct{}, 10)
-		for _, app := range apps {
-			sem <- struct{}{}
-			go func(ctx context.Context, client *http.Client, app db.AppsOrdered, sem chan struct{}) {
-				defer func() { <-sem }()
-				err := validateAndPushToSWH(ctx, client, app.Package, app.MetaSourceCode)
-				if err != nil {
-					// if context.Canceled, do not update the last save triggered
-					if errors.Is(err, context.Canceled) {
-						slog.Warn("context canceled", "err", err)
-						return
+
+		next := ""
+		a, ok := archivers[item.Archiver]
+		if !ok {
+			slog.Warn("archiver no longer configured, dropping queue item", "archiver", item.Archiver, "package", item.Package)
+		} else {
+			next, err = handle(ctx, a, item)
+			if err != nil {
+				// if context.Canceled, leave the item for the next run
+				if errors.Is(err, context.Canceled) {
+					slog.Warn("context canceled", "err", err)
+					return
+				}
+				slog.Error(stage+" failed", "archiver", a.Name(), "package", item.Package, "target", item.Target, "err", err)
+				var hostErr *HostUnavailableError
+				for _, pkg := range originPackages(ctx, item.Package) {
+					if errors.As(err, &hostErr) {
+						// not the app's fault, it waits for the host
+						saveDeferred(ctx, pkg, hostErr.Until)
+					} else {
+						saveFailed(ctx, pkg, err, time.Now())
 					}
-					slog.Error("validateAndPushToSWH failed", "sourceCode", app.MetaSourceCode, "err", err)
-				} else {
-					slog.Info("validateAndPushToSWH ok", "sourceCode", app.MetaSourceCode, "err", err)
 				}
+				next = ""
+			}
+		}
 
-				dbWriteSqlc.UpdateLastSaveTriggered(ctx, db.UpdateLastSaveTriggeredParams{
-					Package:           app.Package,
-					LastSaveTriggered: time.Now().UnixMilli(),
-				})
-			}(ctx, client, app, sem)
+		if next == "" {
+			err = dbWriteSqlc.DeleteQueueItem(ctx, db.DeleteQueueItemParams{
+				Package:  item.Package,
+				Archiver: item.Archiver,
+			})
+		} else {
+			err = dbWriteSqlc.AdvanceQueueItem(ctx, db.AdvanceQueueItemParams{
+				Stage:    next,
+				Package:  item.Package,
+				Archiver: item.Archiver,
+			})
+		}
+		if err != nil {
+			slog.Error("update queue item", "package", item.Package, "err", err)
 		}
+	}
+}
+
+func probeItem(ctx context.Context, a Archiver, item db.Queue) (string, error) {
+	started := time.Now()
+	err := a.Probe(ctx, item.Target)
+	recordAttempt(ctx, a, "probe", item.Package, item.Target, "", started, err)
+	if err != nil {
+		return "", err
+	}
+	slog.Info("probe ok", "archiver", a.Name(), "target", item.Target)
+	return "submit", nil
+}
+
+func submitItem(ctx context.Context, a Archiver, item db.Queue) (string, error) {
+	st, err := submit(ctx, a, item.Package, item.Target)
+	if err != nil {
+		return "", err
+	}
+
+	// ok
+	slog.Info("submit ok", "archiver", a.Name(), "target", item.Target, "status", st.Status)
+	// every app of the origin follows the job, so the poller settles them all
+	pkgs := originPackages(ctx, item.Package)
+	for _, pkg := range pkgs {
+		if err := a.Record(ctx, pkg, st); err != nil {
+			return "", err
+		}
+	}
+
+	if _, ok := a.(pendingLister); !ok {
+		if st, err = waitDone(ctx, a, item.Package, st); err != nil {
+			return "", err
+		}
+	}
 
-		for range cap(sem) {
-			sem <- struct{}{}
+	if st.Done && !st.Failed {
+		for _, pkg := range pkgs {
+			saveSucceeded(ctx, pkg)
 		}
-		close(sem)
 	}
+	return "", statusErr(a, st)
 }
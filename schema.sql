@@ -4,18 +4,321 @@ CREATE TABLE IF NOT EXISTS apps(
     meta_last_updated INTEGER NOT NULL,
     meta_source_code TEXT NOT NULL,
     last_save_triggered INTEGER NOT NULL DEFAULT (0),
-    last_task_id INTEGER,
-    FOREIGN KEY (last_task_id) REFERENCES tasks(id) ON DELETE SET NULL
+    -- failed saves in a row, when the next one is due (0 if none is) and
+    -- the class of the last failure
+    save_failures INTEGER NOT NULL DEFAULT (0),
+    next_attempt_at INTEGER NOT NULL DEFAULT (0),
+    last_error_class TEXT,
+    -- set once too many saves failed; only an index update retries the app
+    gave_up_at INTEGER,
+    -- when an index load last listed the app, and when one first did not
+    listed_at INTEGER NOT NULL DEFAULT (0),
+    delisted_at INTEGER,
+    -- F-Droid categories, comma-separated
+    categories TEXT NOT NULL DEFAULT '',
+    -- the source code repository as an SWH origin, NULL without one; apps
+    -- sharing it are saved together
+    origin_url TEXT REFERENCES origins(url)
 );
+-- source code repositories, each saved once for all the apps built from it
+CREATE TABLE IF NOT EXISTS origins(
+    -- meta_source_code with a single trailing slash, as SWH has it
+    url TEXT NOT NULL PRIMARY KEY,
+    -- when an app first pointed at it (unix ms)
+    first_seen_at INTEGER NOT NULL,
+    -- when we last fetched its refs or tried to (unix ms)
+    refs_checked_at INTEGER NOT NULL DEFAULT (0)
+);
+-- save requests, ids are only unique per SWH instance
 CREATE TABLE IF NOT EXISTS tasks(
-    id INTEGER NOT NULL PRIMARY KEY,
+    instance TEXT NOT NULL,
+    id INTEGER NOT NULL,
     save_request_status TEXT NOT NULL,
     save_task_status TEXT NOT NULL,
-    snapshot_swhid TEXT
+    snapshot_swhid TEXT,
+    origin_url TEXT NOT NULL DEFAULT '',
+    visit_type TEXT NOT NULL DEFAULT '',
+    save_request_date TEXT NOT NULL DEFAULT '',
+    visit_date TEXT,
+    visit_status TEXT,
+    loading_task_id INTEGER,
+    -- SWH puts the rejection reason here
+    note TEXT,
+    request_url TEXT NOT NULL DEFAULT '',
+    -- last response as returned by SWH
+    raw TEXT NOT NULL DEFAULT '',
+    -- when we first saw the task, last refreshed it and saw it finish (unix ms)
+    created_at INTEGER NOT NULL DEFAULT (0),
+    updated_at INTEGER NOT NULL DEFAULT (0),
+    finished_at INTEGER,
+    -- when the poller looks at the task next, and how many polls failed in a row
+    next_poll_at INTEGER NOT NULL DEFAULT (0),
+    poll_failures INTEGER NOT NULL DEFAULT (0),
+    -- when the poller gave up on a task it could not poll, and why
+    abandoned_at INTEGER,
+    abandon_reason TEXT,
+    PRIMARY KEY (instance, id)
+);
+-- last save request of each app on each SWH instance
+CREATE TABLE IF NOT EXISTS app_tasks(
+    package TEXT NOT NULL,
+    instance TEXT NOT NULL,
+    last_task_id INTEGER NOT NULL,
+    PRIMARY KEY (package, instance),
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE,
+    FOREIGN KEY (instance, last_task_id) REFERENCES tasks(instance, id)
+);
+-- work waiting for the saver's probe and submit stages, one row per app and archiver
+CREATE TABLE IF NOT EXISTS queue(
+    package TEXT NOT NULL,
+    archiver TEXT NOT NULL,
+    -- probe or submit
+    stage TEXT NOT NULL,
+    target TEXT NOT NULL,
+    enqueued_at INTEGER NOT NULL,
+    -- when a worker took the item (unix ms), 0 while it waits
+    claimed_at INTEGER NOT NULL DEFAULT (0),
+    PRIMARY KEY (package, archiver),
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE
+);
+-- releases of each app as listed in the index
+CREATE TABLE IF NOT EXISTS versions(
+    package TEXT NOT NULL,
+    version_code INTEGER NOT NULL,
+    version_name TEXT NOT NULL,
+    added INTEGER NOT NULL,
+    file_name TEXT NOT NULL,
+    file_sha256 TEXT NOT NULL,
+    -- source tarball, if F-Droid built the release
+    src_name TEXT,
+    src_sha256 TEXT,
+    -- upstream tag the release was matched to and the commit it points at
+    tag TEXT,
+    revision_swhid TEXT,
+    -- when tag matching last ran for this release (unix ms)
+    mapped_at INTEGER NOT NULL DEFAULT (0),
+    -- SWHIDs of the source tarball and of the tree it unpacks to
+    src_cnt_swhid TEXT,
+    src_dir_swhid TEXT,
+    -- last attempt at hashing the tarball (unix ms) and why it failed
+    src_hashed_at INTEGER NOT NULL DEFAULT (0),
+    src_error TEXT,
+    -- whether the APK and the source tarball are in the local mirror, when
+    -- mirroring was last attempted (unix ms) and why it failed
+    apk_mirrored BOOLEAN NOT NULL DEFAULT (FALSE),
+    src_mirrored BOOLEAN NOT NULL DEFAULT (FALSE),
+    mirrored_at INTEGER NOT NULL DEFAULT (0),
+    mirror_error TEXT,
+    PRIMARY KEY (package, version_code),
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE
+);
+-- files in the local mirror, stored under their sha256
+CREATE TABLE IF NOT EXISTS mirror_blobs(
+    sha256 TEXT NOT NULL PRIMARY KEY,
+    size INTEGER NOT NULL,
+    stored_at INTEGER NOT NULL,
+    -- last integrity check (unix ms)
+    verified_at INTEGER NOT NULL
+);
+-- last save request of each release's source tarball on each SWH instance
+CREATE TABLE IF NOT EXISTS version_tasks(
+    package TEXT NOT NULL,
+    version_code INTEGER NOT NULL,
+    instance TEXT NOT NULL,
+    last_task_id INTEGER NOT NULL,
+    PRIMARY KEY (package, version_code, instance),
+    FOREIGN KEY (package, version_code) REFERENCES versions(package, version_code) ON DELETE CASCADE,
+    FOREIGN KEY (instance, last_task_id) REFERENCES tasks(instance, id)
+);
+-- retry schedule of release source tarballs whose save failed, per SWH instance
+CREATE TABLE IF NOT EXISTS version_save_retries(
+    package TEXT NOT NULL,
+    version_code INTEGER NOT NULL,
+    instance TEXT NOT NULL,
+    failures INTEGER NOT NULL,
+    next_attempt_at INTEGER NOT NULL,
+    last_error_class TEXT,
+    gave_up_at INTEGER,
+    PRIMARY KEY (package, version_code, instance),
+    FOREIGN KEY (package, version_code) REFERENCES versions(package, version_code) ON DELETE CASCADE
+);
+-- answers of SWH's known API, per instance
+CREATE TABLE IF NOT EXISTS known_swhids(
+    instance TEXT NOT NULL,
+    swhid TEXT NOT NULL,
+    known BOOLEAN NOT NULL,
+    checked_at INTEGER NOT NULL,
+    PRIMARY KEY (instance, swhid)
+);
+-- refs advertised by each origin the last time the saver probed it
+CREATE TABLE IF NOT EXISTS upstream_refs(
+    origin_url TEXT NOT NULL,
+    -- HEAD, refs/heads/..., refs/tags/...
+    name TEXT NOT NULL,
+    -- object id the ref points at
+    target TEXT NOT NULL,
+    seen_at INTEGER NOT NULL,
+    PRIMARY KEY (origin_url, name)
+);
+-- upstream refs as they were when each save request was submitted, kept
+-- until its snapshot is compared with them
+CREATE TABLE IF NOT EXISTS task_refs(
+    instance TEXT NOT NULL,
+    task_id INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    target TEXT NOT NULL,
+    PRIMARY KEY (instance, task_id, name),
+    FOREIGN KEY (instance, task_id) REFERENCES tasks(instance, id) ON DELETE CASCADE
+);
+-- succeeded tasks whose snapshot was compared with the refs seen when they
+-- were submitted
+CREATE TABLE IF NOT EXISTS snapshot_checks(
+    instance TEXT NOT NULL,
+    task_id INTEGER NOT NULL,
+    origin_url TEXT NOT NULL,
+    snapshot_swhid TEXT NOT NULL,
+    -- swh:1:rev of the snapshot's HEAD, if it has one
+    head_swhid TEXT,
+    branches INTEGER NOT NULL,
+    tags INTEGER NOT NULL,
+    -- upstream refs absent from the snapshot or pointing elsewhere, one per line
+    missing TEXT NOT NULL,
+    complete BOOLEAN NOT NULL,
+    -- incomplete checks in a row for this origin, each one re-queued the app
+    requeues INTEGER NOT NULL,
+    checked_at INTEGER NOT NULL,
+    PRIMARY KEY (instance, task_id),
+    FOREIGN KEY (instance, task_id) REFERENCES tasks(instance, id) ON DELETE CASCADE
+);
+-- visits of each origin by SWH, whoever asked for them
+CREATE TABLE IF NOT EXISTS origin_visits(
+    instance TEXT NOT NULL,
+    origin_url TEXT NOT NULL,
+    -- SWH's visit number, counting up per origin
+    visit INTEGER NOT NULL,
+    date TEXT NOT NULL,
+    type TEXT NOT NULL,
+    -- created, ongoing, full, partial, not_found or failed
+    status TEXT NOT NULL,
+    -- swh:1:snp, NULL if the visit produced none
+    snapshot_swhid TEXT,
+    PRIMARY KEY (instance, origin_url, visit)
+);
+-- when the visits of each origin were last fetched
+CREATE TABLE IF NOT EXISTS visit_fetches(
+    instance TEXT NOT NULL,
+    origin_url TEXT NOT NULL,
+    fetched_at INTEGER NOT NULL,
+    PRIMARY KEY (instance, origin_url)
+);
+-- archived revisions cooked by the SWH Vault and checked after download
+CREATE TABLE IF NOT EXISTS restore_tests(
+    id INTEGER NOT NULL PRIMARY KEY,
+    instance TEXT NOT NULL,
+    package TEXT NOT NULL,
+    -- swh:1:rev of the snapshot's HEAD
+    swhid TEXT NOT NULL,
+    -- pending, passed or failed
+    status TEXT NOT NULL,
+    requested_at INTEGER NOT NULL,
+    checked_at INTEGER,
+    -- size of the bundle, once downloaded
+    bytes INTEGER,
+    error TEXT,
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE
+);
+-- suspicious changes between two observations of an origin's refs, per app
+-- built from it
+CREATE TABLE IF NOT EXISTS ref_alerts(
+    id INTEGER NOT NULL PRIMARY KEY,
+    package TEXT NOT NULL,
+    origin_url TEXT NOT NULL,
+    -- tag_deleted, tag_moved, branch_deleted, history_rewritten or emptied
+    kind TEXT NOT NULL,
+    -- empty for emptied
+    ref TEXT NOT NULL,
+    -- commits the ref pointed at before and after, after is NULL if it is gone
+    before TEXT NOT NULL,
+    after TEXT,
+    -- versionName of the release built from the tag, if any
+    release TEXT,
+    detected_at INTEGER NOT NULL,
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE
+);
+-- branches that moved upstream, checked for rewritten history once SWH
+-- archived the new commit
+CREATE TABLE IF NOT EXISTS ref_moves(
+    origin_url TEXT NOT NULL,
+    ref TEXT NOT NULL,
+    before TEXT NOT NULL,
+    after TEXT NOT NULL,
+    seen_at INTEGER NOT NULL,
+    PRIMARY KEY (origin_url, ref)
+);
+-- every probe, submit and poll the saver and poller made, with how it went
+CREATE TABLE IF NOT EXISTS attempts(
+    id INTEGER NOT NULL PRIMARY KEY,
+    -- NULL for polls, which follow a job rather than an app
+    package TEXT,
+    archiver TEXT NOT NULL,
+    -- probe, submit or poll
+    stage TEXT NOT NULL,
+    -- what was probed or submitted, the job's origin for polls
+    target TEXT NOT NULL,
+    job_id TEXT,
+    started_at INTEGER NOT NULL,
+    finished_at INTEGER NOT NULL,
+    -- ok or failed
+    outcome TEXT NOT NULL,
+    -- see classifyError, NULL when ok
+    error_class TEXT,
+    http_status INTEGER,
+    message TEXT,
+    FOREIGN KEY (package) REFERENCES apps(package) ON DELETE CASCADE
+);
+-- what operators did from the web UI, and who
+CREATE TABLE IF NOT EXISTS admin_actions(
+    id INTEGER NOT NULL PRIMARY KEY,
+    -- the admin user, as they logged in
+    actor TEXT NOT NULL,
+    -- save, reset, cancel, requeue, cancel_all, pause or resume
+    action TEXT NOT NULL,
+    -- NULL for bulk actions; kept after the app is gone
+    package TEXT,
+    -- what a bulk action was limited to, e.g. "class=timeout host=github.com",
+    -- or the component paused or resumed
+    filter TEXT NOT NULL DEFAULT '',
+    -- apps or queue items the action touched
+    affected INTEGER NOT NULL,
+    created_at INTEGER NOT NULL
+);
+-- components an admin paused, until one resumes them
+CREATE TABLE IF NOT EXISTS pauses(
+    -- saver, updater or poller
+    component TEXT NOT NULL PRIMARY KEY,
+    actor TEXT NOT NULL,
+    reason TEXT NOT NULL DEFAULT '',
+    paused_at INTEGER NOT NULL
 );
 CREATE INDEX IF NOT EXISTS apps_meta_added ON apps (meta_added);
 CREATE INDEX IF NOT EXISTS apps_meta_last_updated ON apps (meta_last_updated);
 CREATE INDEX IF NOT EXISTS apps_last_save_triggered ON apps (last_save_triggered);
+CREATE INDEX IF NOT EXISTS apps_next_attempt_at ON apps (next_attempt_at);
+CREATE INDEX IF NOT EXISTS apps_origin_url ON apps (origin_url);
+CREATE INDEX IF NOT EXISTS app_tasks_last_task_id ON app_tasks (instance, last_task_id);
+CREATE INDEX IF NOT EXISTS tasks_origin_url ON tasks (instance, origin_url);
+CREATE INDEX IF NOT EXISTS tasks_next_poll_at ON tasks (instance, next_poll_at);
+CREATE INDEX IF NOT EXISTS tasks_snapshot ON tasks (origin_url, snapshot_swhid);
+CREATE INDEX IF NOT EXISTS origin_visits_origin_url ON origin_visits (origin_url, status);
+CREATE INDEX IF NOT EXISTS versions_revision_swhid ON versions (revision_swhid);
+CREATE INDEX IF NOT EXISTS snapshot_checks_origin_url ON snapshot_checks (instance, origin_url, checked_at);
+CREATE INDEX IF NOT EXISTS ref_alerts_package ON ref_alerts (package, detected_at);
+CREATE INDEX IF NOT EXISTS upstream_refs_seen_at ON upstream_refs (seen_at);
+CREATE INDEX IF NOT EXISTS restore_tests_requested_at ON restore_tests (instance, requested_at);
+CREATE INDEX IF NOT EXISTS queue_stage ON queue (stage, claimed_at, enqueued_at);
+CREATE INDEX IF NOT EXISTS attempts_package ON attempts (package, started_at);
+CREATE INDEX IF NOT EXISTS attempts_target ON attempts (target, started_at);
+CREATE INDEX IF NOT EXISTS attempts_started_at ON attempts (started_at);
 
 CREATE VIEW IF NOT EXISTS apps_ordered AS
 SELECT * FROM apps ORDER BY meta_last_updated DESC;
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// source tarballs are downloaded from next to the index, or from a mirror
+var SRC_BASE_URL = strings.TrimSuffix(INDEX_URL, "index-v2.json")
+
+// SRC_HASH_WORKERS tarballs are downloaded at a time, 0 turns hashing off.
+var SRC_HASH_WORKERS = 1
+
+const (
+	srcHashBatchSize = 10
+	// tarballs that could not be hashed are tried again after this long
+	srcHashRetryInterval = 7 * 24 * time.Hour
+)
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("SRC_HASH_WORKERS")); err == nil && n >= 0 {
+		SRC_HASH_WORKERS = n
+	}
+	if base := os.Getenv("SRC_BASE_URL"); base != "" {
+		SRC_BASE_URL = strings.TrimSuffix(base, "/") + "/"
+	}
+}
+
+// swhNode is a file, symlink or directory of an unpacked tarball, as SWH
+// (and git) hash it.
+type swhNode struct {
+	// 100644, 100755, 120000 or 40000
+	mode     string
+	id       [sha1.Size]byte
+	children map[string]*swhNode
+}
+
+func newDirNode() *swhNode {
+	return &swhNode{mode: "40000", children: map[string]*swhNode{}}
+}
+
+// gitHasher starts a git object hash: "<kind> <size>\0" followed by the
+// object's bytes.
+func gitHasher(kind string, size int64) hash.Hash {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s %d\x00", kind, size)
+	return h
+}
+
+func gitHashSum(h hash.Hash) (id [sha1.Size]byte) {
+	copy(id[:], h.Sum(nil))
+	return id
+}
+
+// hashDir computes the id of a directory from its children's. Entries are
+// ordered like git does, with directory names sorting as if they ended in
+// "/". Unlike git, SWH keeps empty directories.
+func hashDir(n *swhNode) [sha1.Size]byte {
+	names := make([]string, 0, len(n.children))
+	for name := range n.children {
+		names = append(names, name)
+	}
+	sortKey := func(name string) string {
+		if n.children[name].children != nil {
+			return name + "/"
+		}
+		return name
+	}
+	slices.SortFunc(names, func(a, b string) int { return strings.Compare(sortKey(a), sortKey(b)) })
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		child := n.children[name]
+		if child.children != nil {
+			child.id = hashDir(child)
+		}
+		buf.WriteString(child.mode + " " + name + "\x00")
+		buf.Write(child.id[:])
+	}
+	h := gitHasher("tree", int64(buf.Len()))
+	h.Write(buf.Bytes())
+	n.id = gitHashSum(h)
+	return n.id
+}
+
+// hashTar builds the tree of a tar stream and returns the directory SWHID
+// of its root. A tarball holding a single top-level directory is hashed as
+// that directory, the way SWH's tarball loader unpacks it.
+func hashTar(r io.Reader) (string, error) {
+	root := newDirNode()
+	lookup := func(p string, create bool) (*swhNode, string) {
+		dir := root
+		parts := strings.Split(p, "/")
+		for _, part := range parts[:len(parts)-1] {
+			child, ok := dir.children[part]
+			if !ok {
+				if !create {
+					return nil, ""
+				}
+				child = newDirNode()
+				dir.children[part] = child
+			}
+			dir = child
+		}
+		return dir, parts[len(parts)-1]
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if name == "." || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		dir, base := lookup(name, true)
+		if dir.children == nil {
+			return "", fmt.Errorf("%s: parent is not a directory", name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if _, ok := dir.children[base]; !ok {
+				dir.children[base] = newDirNode()
+			}
+		case tar.TypeReg:
+			h := gitHasher("blob", hdr.Size)
+			if _, err := io.Copy(h, tr); err != nil {
+				return "", err
+			}
+			mode := "100644"
+			if hdr.Mode&0o100 != 0 {
+				mode = "100755"
+			}
+			dir.children[base] = &swhNode{mode: mode, id: gitHashSum(h)}
+		case tar.TypeSymlink:
+			h := gitHasher("blob", int64(len(hdr.Linkname)))
+			io.WriteString(h, hdr.Linkname)
+			dir.children[base] = &swhNode{mode: "120000", id: gitHashSum(h)}
+		case tar.TypeLink:
+			targetDir, targetBase := lookup(path.Clean(strings.TrimPrefix(hdr.Linkname, "/")), false)
+			if targetDir == nil || targetDir.children[targetBase] == nil {
+				return "", fmt.Errorf("%s: hard link to unknown %s", name, hdr.Linkname)
+			}
+			target := *targetDir.children[targetBase]
+			dir.children[base] = &target
+		default:
+			// devices, fifos and pax global headers are not part of the tree
+		}
+	}
+
+	top := root
+	if len(root.children) == 1 {
+		for _, child := range root.children {
+			if child.children != nil {
+				top = child
+			}
+		}
+	}
+	id := hashDir(top)
+	return "swh:1:dir:" + hex.EncodeToString(id[:]), nil
+}
+
+// hashSource downloads a source tarball, checks it against the index's
+// sha256 and returns its content and directory SWHIDs.
+func hashSource(ctx context.Context, client *http.Client, name, sha256sum string) (cnt, dir string, err error) {
+	f, err := os.CreateTemp("", "fdroidswh-src-*")
+	if err != nil {
+		return "", "", err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", srcOriginURL(name), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", "fdroidswh-git")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("GET %s: %s", name, resp.Status)
+	}
+
+	sum := sha256.New()
+	size, err := io.Copy(f, io.TeeReader(resp.Body, sum))
+	if err != nil {
+		return "", "", err
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != sha256sum {
+		return "", "", fmt.Errorf("%s: sha256 %s, index says %s", name, got, sha256sum)
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	h := gitHasher("blob", size)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", "", err
+	}
+	id := gitHashSum(h)
+	cnt = "swh:1:cnt:" + hex.EncodeToString(id[:])
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", "", err
+	}
+	var tarStream io.Reader
+	switch {
+	case strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz"):
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", "", err
+		}
+		defer gz.Close()
+		tarStream = gz
+	case strings.HasSuffix(name, ".tar.bz2"):
+		tarStream = bzip2.NewReader(f)
+	case strings.HasSuffix(name, ".tar"):
+		tarStream = f
+	default:
+		return cnt, "", fmt.Errorf("%s: unsupported archive format", name)
+	}
+	dir, err = hashTar(tarStream)
+	return cnt, dir, err
+}
+
+func hashVersion(ctx context.Context, client *http.Client, v db.Version) error {
+	cnt, dir, err := hashSource(ctx, client, v.SrcName.String, v.SrcSha256.String)
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	if err != nil {
+		slog.Warn("hashSource", "package", v.Package, "versionCode", v.VersionCode, "err", err)
+	} else {
+		slog.Info("source hashed", "package", v.Package, "versionCode", v.VersionCode, "dir", dir)
+	}
+	var srcError string
+	if err != nil {
+		srcError = err.Error()
+	}
+	return dbWriteSqlc.UpdateVersionSourceSwhids(ctx, db.UpdateVersionSourceSwhidsParams{
+		SrcCntSwhid: sql.NullString{String: cnt, Valid: cnt != ""},
+		SrcDirSwhid: sql.NullString{String: dir, Valid: dir != ""},
+		SrcHashedAt: time.Now().UnixMilli(),
+		SrcError:    sql.NullString{String: srcError, Valid: srcError != ""},
+		Package:     v.Package,
+		VersionCode: v.VersionCode,
+	})
+}
+
+// sourceHasher computes the SWHIDs of the source tarballs F-Droid built
+// releases from, newest first, so the archivers can check whether the exact
+// source tree is archived, whatever the tags say.
+func sourceHasher(ctx context.Context, wg *sync.WaitGroup, client *http.Client) {
+	defer wg.Done()
+	slog.Info("sourceHasher start")
+	defer slog.Info("sourceHasher exit")
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		default:
+		}
+		if !waitWhilePaused(ctx, "hasher") {
+			return
+		}
+
+		versions, err := dbWriteSqlc.GetVersionsToHash(ctx, db.GetVersionsToHashParams{
+			SrcHashedAt: time.Now().Add(-srcHashRetryInterval).UnixMilli(),
+			Limit:       srcHashBatchSize,
+		})
+		if err != nil && !errors.Is(err, context.Canceled) {
+			slog.Error("GetVersionsToHash", "err", err)
+		}
+		if len(versions) == 0 {
+			sleepCtx(ctx, time.Minute)
+			continue
+		}
+
+		work := make(chan db.Version)
+		var workers sync.WaitGroup
+		for range SRC_HASH_WORKERS {
+			workers.Add(1)
+			go func() {
+				defer workers.Done()
+				for v := range work {
+					if err := hashVersion(ctx, client, v); err != nil && !errors.Is(err, context.Canceled) {
+						slog.Error("hashVersion", "package", v.Package, "err", err)
+					}
+				}
+			}()
+		}
+		for _, v := range versions {
+			work <- v
+		}
+		close(work)
+		workers.Wait()
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"testing"
+)
+
+func Test_hashTar(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range []struct {
+		name, body, link string
+		typ              byte
+		mode             int64
+	}{
+		{name: "proj/", typ: tar.TypeDir, mode: 0o755},
+		{name: "proj/src/", typ: tar.TypeDir, mode: 0o755},
+		{name: "proj/src/sub/", typ: tar.TypeDir, mode: 0o755},
+		{name: "proj/src/sub/a.txt", body: "x", typ: tar.TypeReg, mode: 0o644},
+		{name: "proj/run.sh", body: "#!/bin/sh\n", typ: tar.TypeReg, mode: 0o755},
+		{name: "proj/src-b.txt", body: "y", typ: tar.TypeReg, mode: 0o644},
+		{name: "proj/link", link: "README", typ: tar.TypeSymlink, mode: 0o777},
+		{name: "proj/README", body: "hello\n", typ: tar.TypeReg, mode: 0o644},
+	} {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: e.mode, Size: int64(len(e.body)), Linkname: e.link}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(e.body))
+	}
+	tw.Close()
+
+	// git rev-parse HEAD^{tree} of the same files
+	want := "swh:1:dir:e037fa3863f96dbbaad8ed52ee977b6c62951cd5"
+	got, err := hashTar(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("hashTar() = %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// source tarballs are saved as their own origins, one per release
+const srcVisitType = "tarball-directory"
+
+// srcOriginURL is where F-Droid hosts a release's source tarball.
+func srcOriginURL(srcName string) string {
+	return repoFileURL(srcName)
+}
+
+// tarballSaveRequest is what the directory loader needs to check the
+// tarball it fetches; it is the checksum the index publishes.
+type tarballSaveRequest struct {
+	Checksums      map[string]string `json:"checksums"`
+	ChecksumLayout string            `json:"checksum_layout"`
+}
+
+// sourceSaveFailed schedules the next save of v's source tarball after a
+// failed or rejected one, or gives up on it, as saveFailed does for apps.
+func (a *swhArchiver) sourceSaveFailed(ctx context.Context, v db.Version, saveErr error, now time.Time) error {
+	failures := int64(1)
+	retry, err := dbWriteSqlc.GetVersionSaveRetry(ctx, db.GetVersionSaveRetryParams{
+		Package:     v.Package,
+		VersionCode: v.VersionCode,
+		Instance:    a.instance,
+	})
+	if err == nil {
+		failures = retry.Failures + 1
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	class, _ := classifyError(saveErr)
+	params := db.UpdateVersionSaveFailureParams{
+		Package:        v.Package,
+		VersionCode:    v.VersionCode,
+		Instance:       a.instance,
+		Failures:       failures,
+		LastErrorClass: sql.NullString{String: class, Valid: true},
+	}
+	if delay, ok := retryDelay(class, int(failures), rand.Float64()); ok {
+		params.NextAttemptAt = now.Add(delay).UnixMilli()
+		slog.Info("source save retry scheduled", "archiver", a.Name(), "package", v.Package, "versionCode", v.VersionCode, "class", class, "failures", failures, "in", delay.Round(time.Minute))
+	} else {
+		params.GaveUpAt = sql.NullInt64{Int64: now.UnixMilli(), Valid: true}
+		slog.Warn("source save gave up", "archiver", a.Name(), "package", v.Package, "versionCode", v.VersionCode, "class", class, "failures", failures)
+	}
+	return dbWriteSqlc.UpdateVersionSaveFailure(ctx, params)
+}
+
+// settleSourceSaves settles the retries of the source tarballs task saved,
+// once it is done: saveErr is why it failed, nil if it succeeded.
+func (a *swhArchiver) settleSourceSaves(ctx context.Context, taskID int64, saveErr error, now time.Time) error {
+	versions, err := dbWriteSqlc.GetTaskVersions(ctx, db.GetTaskVersionsParams{
+		Instance:   a.instance,
+		LastTaskID: taskID,
+	})
+	if err != nil {
+		return err
+	}
+	for _, v := range versions {
+		if saveErr != nil {
+			err = a.sourceSaveFailed(ctx, v, saveErr, now)
+		} else {
+			err = dbWriteSqlc.DeleteVersionSaveRetry(ctx, db.DeleteVersionSaveRetryParams{
+				Package:     v.Package,
+				VersionCode: v.VersionCode,
+				Instance:    a.instance,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SaveSources files save requests for source tarballs whose tree SWH does
+// not know and whose release is not covered by an archived revision either.
+// Requests that are rejected, fail to load or are abandoned are retried with
+// backoff until their retry policy gives up. Returns how many it submitted.
+func (a *swhArchiver) SaveSources(ctx context.Context, limit int) (int, error) {
+	versions, err := dbWriteSqlc.GetSourcesToSave(ctx, db.GetSourcesToSaveParams{
+		Instance:      a.instance,
+		Instance_2:    a.instance,
+		Instance_3:    a.instance,
+		Instance_4:    a.instance,
+		NextAttemptAt: time.Now().UnixMilli(),
+		Limit:         int64(limit),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	saved := 0
+	for _, v := range versions {
+		origin := srcOriginURL(v.SrcName.String)
+		started := time.Now()
+		taskResp, err := saveOriginSWH(ctx, a.swh, srcVisitType, origin, tarballSaveRequest{
+			Checksums:      map[string]string{"sha256": v.SrcSha256.String},
+			ChecksumLayout: "standard",
+		})
+		if err != nil {
+			recordAttempt(ctx, a, "submit", v.Package, origin, "", started, err)
+			if errors.Is(err, context.Canceled) {
+				return saved, err
+			}
+			slog.Warn("source save failed", "archiver", a.Name(), "package", v.Package, "versionCode", v.VersionCode, "err", err)
+			if err := a.sourceSaveFailed(ctx, v, err, time.Now()); err != nil {
+				return saved, err
+			}
+			continue
+		}
+		st := taskRespToStatus(taskResp)
+		recordAttempt(ctx, a, "submit", v.Package, origin, st.JobID, started, statusErr(a, st))
+		slog.Info("source saved", "archiver", a.Name(), "package", v.Package, "versionCode", v.VersionCode, "status", st.Status)
+
+		if err := saveTaskRespToDB(ctx, a.instance, taskResp); err != nil {
+			return saved, err
+		}
+		if err := dbWriteSqlc.UpdateVersionTask(ctx, db.UpdateVersionTaskParams{
+			Package:     v.Package,
+			VersionCode: v.VersionCode,
+			Instance:    a.instance,
+			LastTaskID:  int64(taskResp.ID),
+		}); err != nil {
+			return saved, err
+		}
+		// accepted saves are settled once the poller sees them done
+		if taskResp.SaveRequestStatus == "rejected" {
+			if err := a.sourceSaveFailed(ctx, v, statusErr(a, st), time.Now()); err != nil {
+				return saved, err
+			}
+		}
+		saved++
+	}
+	return saved, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// useTestDB points the package at a fresh database for the test.
+func useTestDB(t *testing.T) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "db.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetMaxOpenConns(1)
+	if err := migrate(context.Background(), conn); err != nil {
+		t.Fatal(err)
+	}
+	saved, savedSqlc := dbWrite, dbWriteSqlc
+	dbWrite, dbWriteSqlc = conn, db.New(conn)
+	t.Cleanup(func() {
+		dbWrite, dbWriteSqlc = saved, savedSqlc
+		conn.Close()
+	})
+}
+
+func Test_sourceSaveFailedAfterAccepted(t *testing.T) {
+	useTestDB(t)
+	ctx := context.Background()
+	a := &swhArchiver{instance: defaultSWHInstance}
+	dir := "swh:1:dir:1111111111111111111111111111111111111111"
+	for _, stmt := range []string{
+		`INSERT INTO apps (package, meta_added, meta_last_updated, meta_source_code) VALUES ('org.example', 1, 2, '')`,
+		`INSERT INTO versions (package, version_code, version_name, added, file_name, file_sha256, src_name, src_sha256, src_dir_swhid)
+			VALUES ('org.example', 1, '1.0', 1, 'a.apk', 'x', 'org.example_1_src.tar.gz', 'aa', '` + dir + `')`,
+		`INSERT INTO known_swhids (instance, swhid, known, checked_at) VALUES ('default', '` + dir + `', 0, 1)`,
+	} {
+		if _, err := dbWrite.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	due := func(at time.Time) bool {
+		versions, err := dbWriteSqlc.GetSourcesToSave(ctx, db.GetSourcesToSaveParams{
+			Instance:      a.instance,
+			Instance_2:    a.instance,
+			Instance_3:    a.instance,
+			Instance_4:    a.instance,
+			NextAttemptAt: at.UnixMilli(),
+			Limit:         10,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(versions) == 1
+	}
+	if !due(time.Now()) {
+		t.Fatal("unsaved source not due")
+	}
+
+	// SWH accepts the request, the loader fails later
+	accepted := TaskResp{ID: 5, OriginUrl: srcOriginURL("org.example_1_src.tar.gz"), VisitType: srcVisitType,
+		SaveRequestStatus: "accepted", SaveTaskStatus: "scheduled"}
+	if err := saveTaskRespToDB(ctx, a.instance, accepted); err != nil {
+		t.Fatal(err)
+	}
+	if err := dbWriteSqlc.UpdateVersionTask(ctx, db.UpdateVersionTaskParams{
+		Package: "org.example", VersionCode: 1, Instance: a.instance, LastTaskID: 5,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if due(time.Now()) {
+		t.Fatal("source due while its save is running")
+	}
+
+	failed := accepted
+	failed.SaveTaskStatus = "failed"
+	if err := a.Record(ctx, "", taskRespToStatus(failed)); err != nil {
+		t.Fatal(err)
+	}
+	retry, err := dbWriteSqlc.GetVersionSaveRetry(ctx, db.GetVersionSaveRetryParams{
+		Package: "org.example", VersionCode: 1, Instance: a.instance,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry.Failures != 1 || retry.LastErrorClass.String != "archive_failed" || retry.GaveUpAt.Valid {
+		t.Fatalf("%+v", retry)
+	}
+	if due(time.Now()) {
+		t.Fatal("failed source due before its retry")
+	}
+	if !due(time.UnixMilli(retry.NextAttemptAt)) {
+		t.Fatal("failed source not due at its retry")
+	}
+
+	// the policy gives up like it does for apps
+	for range rejectedRetry.attempts {
+		if err := a.Record(ctx, "", taskRespToStatus(failed)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if due(time.Now().Add(365 * 24 * time.Hour)) {
+		t.Fatal("source still due after giving up")
+	}
+}
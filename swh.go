@@ -0,0 +1,526 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+type TaskResp struct {
+	// The request ID
+	ID int32 `json:"id" validate:"required"`
+	// not created, pending, scheduled, running, succeeded or failed
+	SaveTaskStatus string `json:"save_task_status" validate:"oneof='not created' pending scheduled running succeeded failed"`
+	// accepted, rejected or pending
+	SaveRequestStatus string `json:"save_request_status" validate:"oneof=accepted rejected pending"`
+	// snapshot_swhid (null if it is missing or unknown)
+	SnapshotSwhid string `json:"snapshot_swhid"`
+	RequestUrl    string `json:"request_url" validate:"http_url"`
+	OriginUrl     string `json:"origin_url"`
+	// git, tarball-directory, ...
+	VisitType       string `json:"visit_type"`
+	SaveRequestDate string `json:"save_request_date"`
+	// null until the loader picked the request up
+	VisitDate string `json:"visit_date"`
+	// created, ongoing, full, partial, not_found or failed
+	VisitStatus   string `json:"visit_status"`
+	LoadingTaskID int64  `json:"loading_task_id"`
+	// free-form, e.g. why the request was rejected
+	Note string `json:"note"`
+
+	// the response body as returned by SWH
+	Raw json.RawMessage `json:"-"`
+}
+
+func (t *TaskResp) UnmarshalJSON(data []byte) error {
+	type taskResp TaskResp
+	if err := json.Unmarshal(data, (*taskResp)(t)); err != nil {
+		return err
+	}
+	t.Raw = append(json.RawMessage(nil), data...)
+	return nil
+}
+
+func (t TaskResp) finished() bool {
+	return t.SaveRequestStatus == "rejected" || slices.Contains([]string{"succeeded", "failed"}, t.SaveTaskStatus)
+}
+
+// swhClient is the only way to talk to the SWH API: every call (pushes,
+// status polls, lookups) picks a token from the pool, waits on that token's
+// rate limiter and feeds the response headers back into it.
+type swhClient struct {
+	http   *http.Client
+	api    string
+	tokens *tokenPool
+}
+
+func newSWHClient(client *http.Client, api string, tokens *tokenPool) *swhClient {
+	if !strings.HasSuffix(api, "/") {
+		api = api + "/"
+	}
+	return &swhClient{http: client, api: api, tokens: tokens}
+}
+
+var swhRequestFailed = errors.New("swh request failed")
+
+// swhNotFound is a 404: no such origin, visit or save request
+var swhNotFound = errors.New("not found")
+
+var noUsableToken = errors.New("no usable SWH token")
+
+// call makes one SWH API request and decodes the JSON response into v, or
+// copies the response into v if it is an io.Writer. A non-nil body is sent
+// as JSON.
+//
+// A token the API rejects (401) is revoked for a while and a throttled one
+// (429) is rotated out; either way the call is retried with the next token.
+// A 403 refuses the request itself, e.g. a blacklisted origin on
+// origin/save/, so no other token is tried.
+func (c *swhClient) call(ctx context.Context, method, url string, body, v any) error {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+	}
+
+	err := noUsableToken
+	for range len(c.tokens.tokens) {
+		tok := c.tokens.pick()
+		if tok == nil {
+			return noUsableToken
+		}
+
+		var status int
+		status, err = c.callWith(ctx, tok, method, url, payload, v)
+		switch {
+		case status == http.StatusUnauthorized:
+			slog.Error("SWH token rejected, taking it out of rotation", "token", tok.label, "status", status, "for", tokenRevokeCooldown)
+			c.tokens.revoke(tok)
+		case errors.Is(err, RateLimited):
+			slog.Warn("SWH token throttled, rotating", "token", tok.label)
+		default:
+			return err
+		}
+	}
+	return err
+}
+
+func (c *swhClient) callWith(ctx context.Context, tok *swhToken, method, url string, payload []byte, v any) (int, error) {
+	if err := tok.limiter.Wait(ctx); err != nil {
+		return 0, err
+	}
+
+	// downloads (v is an io.Writer) can take much longer than API calls
+	timeout := 15 * time.Second
+	if _, ok := v.(io.Writer); ok {
+		timeout = 30 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var body io.Reader
+	if payload != nil {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return 0, err
+	}
+
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Authorization", "Bearer "+tok.secret)
+	req.Header.Set("User-Agent", "fdroidswh-git")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	tok.limiter.Update(resp)
+	c.tokens.record(tok, method, url, resp.StatusCode)
+	slog.Debug("swh call", "method", method, "url", url, "token", tok.label, "status", resp.StatusCode, "X-RateLimit-Remaining", resp.Header.Get("X-RateLimit-Remaining"))
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return resp.StatusCode, RateLimited
+	}
+
+	httpErr := &HTTPError{Status: resp.StatusCode, Method: method, URL: url}
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, fmt.Errorf("%w: %w: %w", swhRequestFailed, swhNotFound, httpErr)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("%w: %w", swhRequestFailed, httpErr)
+	}
+
+	if w, ok := v.(io.Writer); ok {
+		_, err := io.Copy(w, resp.Body)
+		return resp.StatusCode, err
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// swhOriginURL is the origin URL we push sourceCode as.
+func swhOriginURL(sourceCode string) string {
+	if !strings.HasSuffix(sourceCode, "/") {
+		sourceCode = sourceCode + "/"
+	}
+	return sourceCode
+}
+
+func pushSWH(ctx context.Context, c *swhClient, sourceCode string) (TaskResp, error) {
+	return saveOriginSWH(ctx, c, "git", swhOriginURL(sourceCode), nil)
+}
+
+// saveOriginSWH files a save request for origin with the given visit type;
+// body carries what the visit type needs besides the URL, if anything.
+func saveOriginSWH(ctx context.Context, c *swhClient, visitType, origin string, body any) (TaskResp, error) {
+	var TaskResp TaskResp
+
+	pushURL := c.api + "origin/save/" + visitType + "/url/" + origin
+	err := c.call(ctx, "POST", pushURL, body, &TaskResp)
+	return TaskResp, err
+}
+
+func fetchTaskStatus(ctx context.Context, c *swhClient, requestUrl string) (TaskResp, error) {
+	var TaskResp TaskResp
+	err := c.call(ctx, "GET", requestUrl, nil, &TaskResp)
+	return TaskResp, err
+}
+
+func saveTaskRespToDB(ctx context.Context, instance string, taskResp TaskResp) error {
+	return dbWriteSqlc.CreateOrUpdateTask(ctx, taskRespParams(instance, taskResp, time.Now()))
+}
+
+func taskRespParams(instance string, taskResp TaskResp, at time.Time) db.CreateOrUpdateTaskParams {
+	now := at.UnixMilli()
+	return db.CreateOrUpdateTaskParams{
+		Instance:          instance,
+		ID:                int64(taskResp.ID),
+		SaveRequestStatus: taskResp.SaveRequestStatus,
+		SaveTaskStatus:    taskResp.SaveTaskStatus,
+		SnapshotSwhid:     sql.NullString{String: taskResp.SnapshotSwhid, Valid: taskResp.SnapshotSwhid != ""},
+		OriginUrl:         taskResp.OriginUrl,
+		VisitType:         taskResp.VisitType,
+		SaveRequestDate:   taskResp.SaveRequestDate,
+		VisitDate:         sql.NullString{String: taskResp.VisitDate, Valid: taskResp.VisitDate != ""},
+		VisitStatus:       sql.NullString{String: taskResp.VisitStatus, Valid: taskResp.VisitStatus != ""},
+		LoadingTaskID:     sql.NullInt64{Int64: taskResp.LoadingTaskID, Valid: taskResp.LoadingTaskID != 0},
+		Note:              sql.NullString{String: taskResp.Note, Valid: taskResp.Note != ""},
+		RequestUrl:        taskResp.RequestUrl,
+		Raw:               string(taskResp.Raw),
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		FinishedAt:        sql.NullInt64{Int64: now, Valid: taskResp.finished()},
+	}
+}
+
+const defaultSWHInstance = "default"
+
+// swhEnvSuffix is appended to per-instance environment variables: none for
+// the default instance, "_STAGING" for "staging".
+func swhEnvSuffix(instance string) string {
+	if instance == defaultSWHInstance {
+		return ""
+	}
+	return "_" + strings.ToUpper(strings.ReplaceAll(instance, "-", "_"))
+}
+
+// swhInstanceAPI returns the API base URL of the named SWH instance. The
+// default instance is at SWH_API (the public archive if unset); the others
+// are declared in SWH_INSTANCES as comma-separated name=url pairs, e.g.
+// "staging=https://webapp.staging.swh.network/api/1/".
+func swhInstanceAPI(instance string) (string, error) {
+	if instance == defaultSWHInstance {
+		if api := os.Getenv("SWH_API"); api != "" {
+			return api, nil
+		}
+		return "https://archive.softwareheritage.org/api/1/", nil
+	}
+
+	for _, profile := range strings.Split(os.Getenv("SWH_INSTANCES"), ",") {
+		name, api, ok := strings.Cut(strings.TrimSpace(profile), "=")
+		if ok && name == instance {
+			return api, nil
+		}
+	}
+	return "", fmt.Errorf("SWH instance %q not in SWH_INSTANCES", instance)
+}
+
+// swhArchiver saves git origins to one Software Heritage instance. Save
+// requests are kept in the tasks table and app_tasks points at each app's
+// latest one, both keyed by instance so histories never mix.
+type swhArchiver struct {
+	client   *http.Client
+	instance string
+	swh      *swhClient
+	// nil if the instance sends us no webhooks
+	webhookSecret []byte
+}
+
+// newSWHArchiver builds the archiver for the SWH instance named by profile
+// ("swh" alone is the default instance, "swh:staging" the staging one).
+func newSWHArchiver(client *http.Client, profile string) (Archiver, error) {
+	instance := profile
+	if instance == "" {
+		instance = defaultSWHInstance
+	}
+
+	api, err := swhInstanceAPI(instance)
+	if err != nil {
+		return nil, err
+	}
+
+	secrets, err := loadSWHTokens(instance)
+	if err != nil {
+		return nil, err
+	}
+	if len(secrets) == 0 {
+		return nil, archiverDisabled
+	}
+	slog.Info("loaded SWH tokens", "instance", instance, "api", api, "count", len(secrets))
+
+	webhookSecret, err := loadWebhookSecret(instance)
+	if err != nil {
+		return nil, fmt.Errorf("SWH_WEBHOOK_SECRET%s: %w", swhEnvSuffix(instance), err)
+	}
+	return &swhArchiver{
+		client:        forgeClient(client),
+		instance:      instance,
+		swh:           newSWHClient(client, api, newTokenPool(secrets)),
+		webhookSecret: webhookSecret,
+	}, nil
+}
+
+func (a *swhArchiver) Name() string {
+	if a.instance == defaultSWHInstance {
+		return "swh"
+	}
+	return "swh:" + a.instance
+}
+
+func (a *swhArchiver) Instance() string { return a.instance }
+
+func (a *swhArchiver) Target(app db.AppsOrdered) string { return app.MetaSourceCode }
+
+func (a *swhArchiver) Probe(ctx context.Context, target string) error {
+	var ok bool
+	var err error
+
+	for range 3 {
+		ok, err = validateGitUrl(ctx, a.client, target)
+		var hostErr *HostUnavailableError
+		if errors.As(err, &hostErr) {
+			// says nothing about the repository, try again once the host is back
+			return err
+		}
+		if err != nil {
+			slog.Warn("retrying validateGitUrl", "sourceCode", target, "err", err)
+			continue
+		}
+		break
+	}
+
+	if !ok {
+		if err != nil && !errors.Is(err, context.Canceled) {
+			return errors.Join(err, notValidGitUrl)
+		}
+
+		return notValidGitUrl
+	}
+
+	// kept to verify the snapshot against once the save succeeded, and
+	// compared with what we saw last time
+	refs, err := fetchUpstreamRefs(ctx, a.client, target)
+	if err != nil {
+		slog.Warn("fetchUpstreamRefs", "sourceCode", target, "err", err)
+		return nil
+	}
+	now := time.Now()
+	if _, err := observeRefs(ctx, target, refs, now); err != nil {
+		return err
+	}
+	return mapReleases(ctx, target, refs, now)
+}
+
+func (a *swhArchiver) Submit(ctx context.Context, target string) (ArchiveStatus, error) {
+	taskResp, err := pushSWH(ctx, a.swh, target)
+	if err != nil {
+		return ArchiveStatus{}, err
+	}
+	return taskRespToStatus(taskResp), nil
+}
+
+func (a *swhArchiver) Poll(ctx context.Context, st ArchiveStatus) (ArchiveStatus, error) {
+	taskResp, err := fetchTaskStatus(ctx, a.swh, st.StatusURL)
+	if err != nil {
+		return st, err
+	}
+	return taskRespToStatus(taskResp), nil
+}
+
+func (a *swhArchiver) Record(ctx context.Context, pkg string, st ArchiveStatus) error {
+	taskResp, ok := st.Detail.(TaskResp)
+	if !ok {
+		return errors.New("swh: status has no TaskResp")
+	}
+	// save task to db
+	if err := saveTaskRespToDB(ctx, a.instance, taskResp); err != nil {
+		return err
+	}
+	if pkg == "" {
+		if st.Done {
+			// source tarball saves are followed by the poller too
+			return a.settleSourceSaves(ctx, int64(taskResp.ID), statusErr(a, st), time.Now())
+		}
+		return nil
+	}
+	// the refs the probe saw just before, which the snapshot is verified
+	// against; later observations are not what was pushed
+	if err := dbWriteSqlc.CreateTaskRefs(ctx, db.CreateTaskRefsParams{
+		OriginUrl: swhOriginURL(taskResp.OriginUrl),
+		Instance:  a.instance,
+		ID:        int64(taskResp.ID),
+	}); err != nil {
+		return err
+	}
+	// update last task id
+	return dbWriteSqlc.UpdateLastTaskId(ctx, db.UpdateLastTaskIdParams{
+		Package:    pkg,
+		Instance:   a.instance,
+		LastTaskID: int64(taskResp.ID),
+	})
+}
+
+func (a *swhArchiver) Pending(ctx context.Context, now time.Time, limit int) ([]PendingJob, error) {
+	tasks, err := dbWriteSqlc.GetPendingTasks(ctx, db.GetPendingTasksParams{
+		Instance:   a.instance,
+		NextPollAt: now.UnixMilli(),
+		Limit:      int64(limit),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []PendingJob
+	for _, task := range tasks {
+		taskResp := taskRespFromRow(task)
+		if taskResp.RequestUrl == "" {
+			// recorded before we kept request_url
+			taskResp.RequestUrl = a.swh.api + "origin/save/" + strconv.FormatInt(task.ID, 10) + "/"
+		}
+		submitted := time.UnixMilli(task.CreatedAt)
+		if task.CreatedAt == 0 {
+			submitted = now
+		}
+		pkgs, err := dbWriteSqlc.GetTaskPackages(ctx, db.GetTaskPackagesParams{
+			Instance:   a.instance,
+			LastTaskID: task.ID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, PendingJob{
+			ArchiveStatus: taskRespToStatus(taskResp),
+			Submitted:     submitted,
+			Failures:      int(task.PollFailures),
+			Packages:      pkgs,
+		})
+	}
+	return jobs, nil
+}
+
+func (a *swhArchiver) Reschedule(ctx context.Context, job PendingJob, next time.Time, failures int) error {
+	id, err := strconv.ParseInt(job.JobID, 10, 64)
+	if err != nil {
+		return err
+	}
+	// the webhook tells us when the visit is done, polling is only a
+	// fallback; requests still awaiting review are not covered by it
+	if taskResp, ok := job.Detail.(TaskResp); ok && a.webhookSecret != nil && failures == 0 && taskResp.SaveRequestStatus == "accepted" {
+		if fallback := time.Now().Add(webhookPollInterval); next.Before(fallback) {
+			next = fallback
+		}
+	}
+	return dbWriteSqlc.UpdateTaskPollSchedule(ctx, db.UpdateTaskPollScheduleParams{
+		NextPollAt:   next.UnixMilli(),
+		PollFailures: int64(failures),
+		Instance:     a.instance,
+		ID:           id,
+	})
+}
+
+func (a *swhArchiver) Abandon(ctx context.Context, job PendingJob, failures int, pollErr error, now time.Time) error {
+	id, err := strconv.ParseInt(job.JobID, 10, 64)
+	if err != nil {
+		return err
+	}
+	if err := dbWriteSqlc.AbandonTask(ctx, db.AbandonTaskParams{
+		AbandonedAt:   sql.NullInt64{Int64: now.UnixMilli(), Valid: true},
+		AbandonReason: sql.NullString{String: pollErr.Error(), Valid: true},
+		PollFailures:  int64(failures),
+		Instance:      a.instance,
+		ID:            id,
+	}); err != nil {
+		return err
+	}
+	return a.settleSourceSaves(ctx, id, pollErr, now)
+}
+
+// Budget reports the SWH API rate-limit budget over all tokens.
+func (a *swhArchiver) Budget() RateBudget {
+	return a.swh.tokens.Budget()
+}
+
+func (a *swhArchiver) Tokens() *tokenPool {
+	return a.swh.tokens
+}
+
+func taskRespFromRow(task db.Task) TaskResp {
+	return TaskResp{
+		ID:                int32(task.ID),
+		SaveTaskStatus:    task.SaveTaskStatus,
+		SaveRequestStatus: task.SaveRequestStatus,
+		SnapshotSwhid:     task.SnapshotSwhid.String,
+		RequestUrl:        task.RequestUrl,
+		OriginUrl:         task.OriginUrl,
+		VisitType:         task.VisitType,
+		SaveRequestDate:   task.SaveRequestDate,
+		VisitDate:         task.VisitDate.String,
+		VisitStatus:       task.VisitStatus.String,
+		LoadingTaskID:     task.LoadingTaskID.Int64,
+		Note:              task.Note.String,
+		Raw:               json.RawMessage(task.Raw),
+	}
+}
+
+func taskRespToStatus(taskResp TaskResp) ArchiveStatus {
+	rejected := taskResp.SaveRequestStatus == "rejected"
+	return ArchiveStatus{
+		JobID:     strconv.Itoa(int(taskResp.ID)),
+		Target:    taskResp.OriginUrl,
+		Status:    taskResp.SaveRequestStatus + "/" + taskResp.SaveTaskStatus,
+		StatusURL: taskResp.RequestUrl,
+		ResultID:  taskResp.SnapshotSwhid,
+		Done:      taskResp.finished(),
+		Failed:    rejected || taskResp.SaveTaskStatus == "failed",
+		Detail:    taskResp,
+	}
+}
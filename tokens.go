@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// swhToken is one SWH API token with its own rate-limit state.
+type swhToken struct {
+	label   string
+	secret  string
+	limiter *rateLimiter
+
+	// guarded by tokenPool.mu
+	revokedUntil time.Time
+	requests     int
+	lastUsed     time.Time
+	lastStatus   int
+}
+
+// TokenStatus is a token as shown on the status page.
+type TokenStatus struct {
+	Label   string
+	Revoked bool
+	// when a revoked token is tried again
+	RevokedUntil time.Time
+	Requests     int
+	LastUsed     time.Time
+	LastStatus   int
+	Budget       RateBudget
+}
+
+// TokenUse records which token made one request.
+type TokenUse struct {
+	Time   time.Time
+	Token  string
+	Method string
+	URL    string
+	Status int
+}
+
+const (
+	tokenLogSize = 200
+	// how long a token the API rejected stays out of rotation before it is
+	// tried again
+	tokenRevokeCooldown = time.Hour
+)
+
+// tokenPool rotates SWH API calls over several tokens. The token whose
+// budget lets it go first is used; tokens the API rejected with 401 are
+// taken out of rotation for tokenRevokeCooldown.
+type tokenPool struct {
+	mu     sync.Mutex
+	tokens []*swhToken
+	log    []TokenUse
+}
+
+// loadSWHTokens reads the tokens of an SWH instance from SWH_TOKEN
+// (comma-separated) and from the file named by SWH_TOKENS_FILE (one per line,
+// # starts a comment). Instances other than the default one use
+// SWH_TOKEN_<INSTANCE> and SWH_TOKENS_FILE_<INSTANCE> instead.
+func loadSWHTokens(instance string) ([]string, error) {
+	suffix := swhEnvSuffix(instance)
+
+	var secrets []string
+	for _, t := range strings.Split(os.Getenv("SWH_TOKEN"+suffix), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			secrets = append(secrets, t)
+		}
+	}
+
+	if path := os.Getenv("SWH_TOKENS_FILE" + suffix); path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line, _, _ := strings.Cut(scanner.Text(), "#")
+			if line = strings.TrimSpace(line); line != "" {
+				secrets = append(secrets, line)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+func newTokenPool(secrets []string) *tokenPool {
+	p := &tokenPool{}
+	for i, secret := range secrets {
+		suffix := secret
+		if len(suffix) > 4 {
+			suffix = suffix[len(suffix)-4:]
+		}
+		p.tokens = append(p.tokens, &swhToken{
+			label:   fmt.Sprintf("#%d (…%s)", i+1, suffix),
+			secret:  secret,
+			limiter: newRateLimiter(),
+		})
+	}
+	return p
+}
+
+// pick returns the usable token that can make a call the soonest, or nil if
+// every token is revoked for now.
+func (p *tokenPool) pick() *swhToken {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var best *swhToken
+	var bestAt time.Time
+	for _, t := range p.tokens {
+		if now.Before(t.revokedUntil) {
+			continue
+		}
+		at := t.limiter.availableAt(now)
+		if best == nil || at.Before(bestAt) {
+			best, bestAt = t, at
+		}
+	}
+	return best
+}
+
+// record notes that t made a request that got status back.
+func (p *tokenPool) record(t *swhToken, method, url string, status int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	t.requests++
+	t.lastUsed = now
+	t.lastStatus = status
+	if len(p.log) >= tokenLogSize {
+		p.log = p.log[1:]
+	}
+	p.log = append(p.log, TokenUse{Time: now, Token: t.label, Method: method, URL: url, Status: status})
+}
+
+func (p *tokenPool) revoke(t *swhToken) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t.revokedUntil = time.Now().Add(tokenRevokeCooldown)
+}
+
+// Status returns every token, revoked ones included.
+func (p *tokenPool) Status() []TokenStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	var statuses []TokenStatus
+	for _, t := range p.tokens {
+		statuses = append(statuses, TokenStatus{
+			Label:        t.label,
+			Revoked:      now.Before(t.revokedUntil),
+			RevokedUntil: t.revokedUntil,
+			Requests:     t.requests,
+			LastUsed:     t.lastUsed,
+			LastStatus:   t.lastStatus,
+			Budget:       t.limiter.Budget(),
+		})
+	}
+	return statuses
+}
+
+// Log returns the most recent requests, newest first.
+func (p *tokenPool) Log() []TokenUse {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	log := make([]TokenUse, len(p.log))
+	for i, use := range p.log {
+		log[len(p.log)-1-i] = use
+	}
+	return log
+}
+
+// Budget sums the budgets of the usable tokens. The pool is blocked only
+// while every one of them is.
+func (p *tokenPool) Budget() RateBudget {
+	var total RateBudget
+	total.Remaining = -1
+	first := true
+	for _, t := range p.Status() {
+		if t.Revoked {
+			continue
+		}
+		b := t.Budget
+		total.Limit += b.Limit
+		if b.Remaining >= 0 {
+			total.Remaining = max(total.Remaining, 0) + b.Remaining
+		}
+		if total.Reset.IsZero() || (!b.Reset.IsZero() && b.Reset.Before(total.Reset)) {
+			total.Reset = b.Reset
+		}
+		if first || b.BlockedUntil.Before(total.BlockedUntil) {
+			total.BlockedUntil = b.BlockedUntil
+		}
+		first = false
+	}
+	return total
+}
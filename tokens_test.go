@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_loadSWHTokens(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	if err := os.WriteFile(path, []byte("# staging\nccc\n\nddd # spare\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SWH_TOKEN", "aaa, bbb")
+	t.Setenv("SWH_TOKENS_FILE", path)
+
+	secrets, err := loadSWHTokens(defaultSWHInstance)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 4 || secrets[0] != "aaa" || secrets[1] != "bbb" || secrets[2] != "ccc" || secrets[3] != "ddd" {
+		t.Fatal(secrets)
+	}
+
+	t.Setenv("SWH_TOKEN_SELF_HOSTED", "eee")
+	secrets, err = loadSWHTokens("self-hosted")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secrets) != 1 || secrets[0] != "eee" {
+		t.Fatal(secrets)
+	}
+}
+
+func Test_tokenPool_pick(t *testing.T) {
+	p := newTokenPool([]string{"first", "second"})
+	p.tokens[0].limiter.blockedUntil = time.Now().Add(time.Hour)
+
+	if got := p.pick(); got != p.tokens[1] {
+		t.Fatalf("expected the unthrottled token, got %s", got.label)
+	}
+
+	p.revoke(p.tokens[1])
+	if got := p.pick(); got != p.tokens[0] {
+		t.Fatalf("expected the only usable token, got %v", got)
+	}
+
+	p.revoke(p.tokens[0])
+	if got := p.pick(); got != nil {
+		t.Fatalf("expected no token, got %s", got.label)
+	}
+
+	// tried again once the cooldown is over
+	p.tokens[1].revokedUntil = time.Now().Add(-time.Second)
+	if got := p.pick(); got != p.tokens[1] {
+		t.Fatalf("expected the token whose cooldown is over, got %v", got)
+	}
+}
+
+func Test_swhClient_call(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("Authorization"))
+		switch {
+		case r.Header.Get("Authorization") == "Bearer expired":
+			w.WriteHeader(http.StatusUnauthorized)
+		case strings.HasPrefix(r.URL.Path, "/api/1/origin/save/"):
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.Write([]byte("{}"))
+		}
+	}))
+	defer srv.Close()
+
+	p := newTokenPool([]string{"expired", "good"})
+	c := newSWHClient(srv.Client(), srv.URL+"/api/1/", p)
+	var v map[string]any
+	if err := c.call(context.Background(), "GET", c.api+"stat/counters/", nil, &v); err != nil {
+		t.Fatal(err)
+	}
+	if status := p.Status(); !status[0].Revoked || status[1].Revoked {
+		t.Fatalf("expected only the expired token revoked: %+v", status)
+	}
+
+	// a forbidden origin is not the token's fault
+	requests = nil
+	err := c.call(context.Background(), "POST", c.api+"origin/save/git/url/https://example.org/", nil, &v)
+	if class, _ := classifyError(err); class != "client_error" {
+		t.Fatalf("forbidden save classified %s: %v", class, err)
+	}
+	if len(requests) != 1 || p.Status()[1].Revoked {
+		t.Fatalf("forbidden save retried or revoked the token: %v", requests)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// an origin whose snapshots keep coming out incomplete is only re-queued
+// this many times in a row
+const verifyMaxRequeues = 3
+
+// SnapshotBranch is a branch of an SWH snapshot. Aliases (HEAD) target
+// another branch by name.
+type SnapshotBranch struct {
+	Target     string `json:"target"`
+	TargetType string `json:"target_type"`
+}
+
+type snapshotResp struct {
+	ID         string                     `json:"id"`
+	Branches   map[string]*SnapshotBranch `json:"branches"`
+	NextBranch *string                    `json:"next_branch"`
+}
+
+// fetchSnapshot returns every branch of a snapshot, following next_branch
+// through the pages.
+func fetchSnapshot(ctx context.Context, c *swhClient, swhid string) (map[string]*SnapshotBranch, error) {
+	id, ok := strings.CutPrefix(swhid, "swh:1:snp:")
+	if !ok {
+		return nil, fmt.Errorf("not a snapshot SWHID: %s", swhid)
+	}
+
+	branches := map[string]*SnapshotBranch{}
+	from := ""
+	for {
+		u := c.api + "snapshot/" + id + "/?branches_count=1000"
+		if from != "" {
+			u += "&branches_from=" + url.QueryEscape(from)
+		}
+		var resp snapshotResp
+		if err := c.call(ctx, "GET", u, nil, &resp); err != nil {
+			return nil, err
+		}
+		for name, b := range resp.Branches {
+			branches[name] = b
+		}
+		if resp.NextBranch == nil || *resp.NextBranch == "" {
+			return branches, nil
+		}
+		from = *resp.NextBranch
+	}
+}
+
+// SnapshotCheck is the outcome of comparing a snapshot with upstream refs.
+type SnapshotCheck struct {
+	HeadSwhid string
+	Branches  int
+	Tags      int
+	Missing   []string
+}
+
+// resolveBranch follows aliases to the object a branch points at.
+func resolveBranch(branches map[string]*SnapshotBranch, name string) *SnapshotBranch {
+	for range 10 {
+		b := branches[name]
+		if b == nil || b.TargetType != "alias" {
+			return b
+		}
+		name = b.Target
+	}
+	return nil
+}
+
+// compareSnapshot checks that every branch, tag and HEAD advertised upstream
+// made it into the snapshot with the same target. Other refs (pull
+// requests, notes, ...) are not expected to be archived.
+func compareSnapshot(refs map[string]string, branches map[string]*SnapshotBranch) SnapshotCheck {
+	var check SnapshotCheck
+	for name := range branches {
+		switch {
+		case strings.HasPrefix(name, "refs/heads/"):
+			check.Branches++
+		case strings.HasPrefix(name, "refs/tags/"):
+			check.Tags++
+		}
+	}
+	if head := resolveBranch(branches, "HEAD"); head != nil && head.TargetType == "revision" {
+		check.HeadSwhid = "swh:1:rev:" + head.Target
+	}
+
+	for name, oid := range refs {
+		if name != "HEAD" && !strings.HasPrefix(name, "refs/heads/") && !strings.HasPrefix(name, "refs/tags/") {
+			continue
+		}
+		if strings.HasSuffix(name, "^{}") {
+			// peeled tag, the snapshot has the tag itself
+			continue
+		}
+		b := resolveBranch(branches, name)
+		switch {
+		case b == nil:
+			check.Missing = append(check.Missing, name)
+		case b.Target != oid:
+			check.Missing = append(check.Missing, fmt.Sprintf("%s (archived %.12s, upstream %.12s)", name, b.Target, oid))
+		}
+	}
+	slices.Sort(check.Missing)
+	return check
+}
+
+// Verify compares the snapshots of succeeded tasks with the refs seen when
+// they were submitted. Apps whose snapshot is incomplete are re-queued.
+// Returns how many tasks it checked; tasks that could not be checked are
+// tried again next time.
+func (a *swhArchiver) Verify(ctx context.Context, limit int) (int, error) {
+	// refs kept for tasks that will never have a snapshot
+	if _, err := dbWriteSqlc.DeleteUnverifiableTaskRefs(ctx, a.instance); err != nil {
+		return 0, err
+	}
+	tasks, err := dbWriteSqlc.GetUnverifiedTasks(ctx, db.GetUnverifiedTasksParams{
+		Instance: a.instance,
+		Limit:    int64(limit),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	checked := 0
+	for _, task := range tasks {
+		if err := a.verifyTask(ctx, task); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return checked, err
+			}
+			slog.Warn("verify failed", "archiver", a.Name(), "task", task.ID, "err", err)
+			continue
+		}
+		checked++
+	}
+	return checked, nil
+}
+
+func (a *swhArchiver) verifyTask(ctx context.Context, task db.Task) error {
+	rows, err := dbWriteSqlc.GetTaskRefs(ctx, db.GetTaskRefsParams{
+		Instance: a.instance,
+		TaskID:   task.ID,
+	})
+	if err != nil {
+		return err
+	}
+	refs := map[string]string{}
+	for _, row := range rows {
+		refs[row.Name] = row.Target
+	}
+
+	branches, err := fetchSnapshot(ctx, a.swh, task.SnapshotSwhid.String)
+	if err != nil {
+		return err
+	}
+	check := compareSnapshot(refs, branches)
+	complete := len(check.Missing) == 0
+
+	requeues := 0
+	if !complete {
+		prev, err := dbWriteSqlc.GetLatestSnapshotCheck(ctx, db.GetLatestSnapshotCheckParams{
+			Instance:  a.instance,
+			OriginUrl: task.OriginUrl,
+		})
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		requeues = 1
+		if err == nil && !prev.Complete {
+			requeues = int(prev.Requeues) + 1
+		}
+	}
+
+	now := time.Now()
+	if err := dbWriteSqlc.CreateSnapshotCheck(ctx, db.CreateSnapshotCheckParams{
+		Instance:      a.instance,
+		TaskID:        task.ID,
+		OriginUrl:     task.OriginUrl,
+		SnapshotSwhid: task.SnapshotSwhid.String,
+		HeadSwhid:     sql.NullString{String: check.HeadSwhid, Valid: check.HeadSwhid != ""},
+		Branches:      int64(check.Branches),
+		Tags:          int64(check.Tags),
+		Missing:       strings.Join(check.Missing, "\n"),
+		Complete:      complete,
+		Requeues:      int64(requeues),
+		CheckedAt:     now.UnixMilli(),
+	}); err != nil {
+		return err
+	}
+	if err := dbWriteSqlc.DeleteTaskRefs(ctx, db.DeleteTaskRefsParams{
+		Instance: a.instance,
+		TaskID:   task.ID,
+	}); err != nil {
+		return err
+	}
+
+	if err := a.checkRefMoves(ctx, task.OriginUrl, branches); err != nil {
+		if errors.Is(err, context.Canceled) {
+			return err
+		}
+		slog.Warn("checkRefMoves", "archiver", a.Name(), "task", task.ID, "err", err)
+	}
+
+	if complete {
+		slog.Info("snapshot complete", "archiver", a.Name(), "task", task.ID, "branches", check.Branches, "tags", check.Tags)
+		return nil
+	}
+	if requeues > verifyMaxRequeues {
+		slog.Warn("snapshot incomplete, giving up", "archiver", a.Name(), "task", task.ID, "missing", len(check.Missing), "requeues", requeues)
+		return nil
+	}
+	slog.Warn("snapshot incomplete, re-queueing", "archiver", a.Name(), "task", task.ID, "missing", len(check.Missing), "requeues", requeues)
+
+	pkgs, err := dbWriteSqlc.GetTaskPackages(ctx, db.GetTaskPackagesParams{
+		Instance:   a.instance,
+		LastTaskID: task.ID,
+	})
+	if err != nil {
+		return err
+	}
+	// apps sharing the origin share the save too
+	origins := map[string]bool{}
+	for _, pkg := range pkgs {
+		app, err := dbWriteSqlc.GetApp(ctx, pkg)
+		if err != nil {
+			return err
+		}
+		if app.OriginUrl.Valid {
+			if origins[app.OriginUrl.String] {
+				continue
+			}
+			origins[app.OriginUrl.String] = true
+		}
+		enqueueSave(ctx, []Archiver{a}, db.AppsOrdered(app), now)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func Test_compareSnapshot(t *testing.T) {
+	refs := map[string]string{
+		"HEAD":                "aaaa",
+		"refs/heads/main":     "aaaa",
+		"refs/heads/dev":      "bbbb",
+		"refs/tags/v1":        "cccc",
+		"refs/tags/v1^{}":     "1234",
+		"refs/tags/v2":        "dddd",
+		"refs/pull/1/head":    "eeee",
+		"refs/merge-requests": "ffff",
+	}
+	branches := map[string]*SnapshotBranch{
+		"HEAD":            {Target: "refs/heads/main", TargetType: "alias"},
+		"refs/heads/main": {Target: "aaaa", TargetType: "revision"},
+		"refs/heads/dev":  {Target: "9999", TargetType: "revision"},
+		"refs/tags/v1":    {Target: "cccc", TargetType: "release"},
+		"refs/heads/old":  nil,
+	}
+
+	check := compareSnapshot(refs, branches)
+	if check.HeadSwhid != "swh:1:rev:aaaa" {
+		t.Errorf("HeadSwhid = %q", check.HeadSwhid)
+	}
+	if check.Branches != 3 || check.Tags != 1 {
+		t.Errorf("Branches, Tags = %d, %d, want 3, 1", check.Branches, check.Tags)
+	}
+	want := []string{"refs/heads/dev (archived 9999, upstream bbbb)", "refs/tags/v2"}
+	if !slices.Equal(check.Missing, want) {
+		t.Errorf("Missing = %q, want %q", check.Missing, want)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// SWH_VISITS_HOURS is how often the visit history of each origin is
+// refreshed.
+var SWH_VISITS_HOURS = 24
+
+const (
+	visitsPerPage = 100
+	// the first fetch of an origin keeps at most this many recent visits
+	visitsMaxPages = 10
+)
+
+func init() {
+	if n, err := strconv.Atoi(os.Getenv("SWH_VISITS_HOURS")); err == nil && n > 0 {
+		SWH_VISITS_HOURS = n
+	}
+}
+
+type originVisitResp struct {
+	Visit    int64   `json:"visit"`
+	Date     string  `json:"date"`
+	Type     string  `json:"type"`
+	Status   string  `json:"status"`
+	Snapshot *string `json:"snapshot"`
+}
+
+// FetchVisits refreshes the visit history of up to limit origins, those
+// fetched longest ago first. Returns how many origins it fetched.
+func (a *swhArchiver) FetchVisits(ctx context.Context, limit int) (int, error) {
+	now := time.Now()
+	origins, err := dbWriteSqlc.GetOriginsToFetchVisits(ctx, db.GetOriginsToFetchVisitsParams{
+		Instance:  a.instance,
+		FetchedAt: now.Add(-time.Duration(SWH_VISITS_HOURS) * time.Hour).UnixMilli(),
+		Limit:     int64(limit),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	fetched := 0
+	for _, origin := range origins {
+		n, err := a.fetchOriginVisits(ctx, origin)
+		if errors.Is(err, context.Canceled) {
+			return fetched, err
+		}
+		if err != nil {
+			// tried again next round
+			slog.Warn("fetchOriginVisits", "archiver", a.Name(), "origin", origin, "err", err)
+			continue
+		}
+		if n > 0 {
+			slog.Info("visits fetched", "archiver", a.Name(), "origin", origin, "visits", n)
+		}
+		if err := dbWriteSqlc.UpdateVisitFetch(ctx, db.UpdateVisitFetchParams{
+			Instance:  a.instance,
+			OriginUrl: origin,
+			FetchedAt: now.UnixMilli(),
+		}); err != nil {
+			return fetched, err
+		}
+		fetched++
+	}
+	return fetched, nil
+}
+
+// fetchOriginVisits stores the visits of origin newer than the last finished
+// one we have. SWH lists visits newest first, so paging stops as soon as it
+// reaches visits we already know; unfinished ones are fetched again until
+// they finish. An origin SWH never visited has no visits.
+func (a *swhArchiver) fetchOriginVisits(ctx context.Context, origin string) (int, error) {
+	known, err := dbWriteSqlc.GetLastFinishedVisit(ctx, db.GetLastFinishedVisitParams{
+		Instance:  a.instance,
+		OriginUrl: origin,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	stored := 0
+	var lastVisit int64
+	for range visitsMaxPages {
+		u := a.swh.api + "origin/" + origin + "/visits/?per_page=" + strconv.Itoa(visitsPerPage)
+		if lastVisit > 0 {
+			u += "&last_visit=" + strconv.FormatInt(lastVisit, 10)
+		}
+		var visits []originVisitResp
+		if err := a.swh.call(ctx, "GET", u, nil, &visits); errors.Is(err, swhNotFound) {
+			return stored, nil
+		} else if err != nil {
+			return stored, err
+		}
+
+		oldest := int64(0)
+		for _, visit := range visits {
+			if oldest == 0 || visit.Visit < oldest {
+				oldest = visit.Visit
+			}
+			if visit.Visit <= known {
+				continue
+			}
+			var snapshot sql.NullString
+			if visit.Snapshot != nil && *visit.Snapshot != "" {
+				snapshot = sql.NullString{String: "swh:1:snp:" + *visit.Snapshot, Valid: true}
+			}
+			if err := dbWriteSqlc.CreateOrUpdateOriginVisit(ctx, db.CreateOrUpdateOriginVisitParams{
+				Instance:      a.instance,
+				OriginUrl:     origin,
+				Visit:         visit.Visit,
+				Date:          visit.Date,
+				Type:          visit.Type,
+				Status:        visit.Status,
+				SnapshotSwhid: snapshot,
+			}); err != nil {
+				return stored, err
+			}
+			stored++
+		}
+
+		if len(visits) < visitsPerPage || oldest <= known+1 {
+			return stored, nil
+		}
+		lastVisit = oldest
+	}
+	return stored, nil
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
 	"html/template"
 	"log/slog"
 	"net/http"
 	"os"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -23,6 +27,245 @@ type App struct {
 	SaveRequestStatus string
 	SaveTaskStatus    string
 	SnapshotSwhid     string
+	// latest snapshot verification found refs missing
+	Incomplete bool
+	// releases in the index and how many of them have their revision archived
+	Releases         int64
+	ArchivedReleases int64
+	// upstream ref alerts raised on the app
+	Alerts int64
+	// latest probe or submit, nil if none yet
+	LastAttempt *AttemptView
+	// failed saves in a row, and when the next one is due or that we gave up
+	SaveFailures int64
+	NextAttempt  string
+	GaveUp       bool
+	// how urgently the scheduler saves the app, and why
+	Priority Priority
+}
+
+// retryStateOf describes when a failing app is saved again.
+func retryStateOf(app db.App) (next string, gaveUp bool) {
+	if app.GaveUpAt.Valid {
+		return "", true
+	}
+	if app.NextAttemptAt > 0 {
+		return formatTime(time.UnixMilli(app.NextAttemptAt)), false
+	}
+	return "", false
+}
+
+// AlertView is a ref alert as shown on the app page.
+type AlertView struct {
+	db.RefAlert
+	DetectedAt string
+}
+
+// AttemptView is a probe, submit or poll as shown in the UI.
+type AttemptView struct {
+	db.Attempt
+	StartedAt string
+	Took      string
+}
+
+func attemptViewOf(attempt db.Attempt) AttemptView {
+	return AttemptView{
+		Attempt:   attempt,
+		StartedAt: formatTime(time.UnixMilli(attempt.StartedAt)),
+		Took:      (time.Duration(attempt.FinishedAt-attempt.StartedAt) * time.Millisecond).String(),
+	}
+}
+
+// ReleaseView is one release as shown on the app page.
+type ReleaseView struct {
+	db.GetAppReleasesRow
+	// whether SWH knows the source tarball's tree, invalid if not checked
+	SourceKnown sql.NullBool
+	// last save request for the source tarball, nil if none
+	SourceTask *db.Task
+	// retry schedule of the source tarball's failed saves, nil if none
+	SourceRetry *db.VersionSaveRetry
+}
+
+// Coverage is the share of releases whose source revision is archived.
+type Coverage struct {
+	Archived int64
+	Total    int64
+}
+
+func (c Coverage) Percent() string {
+	if c.Total == 0 {
+		return "-"
+	}
+	return strconv.FormatFloat(float64(c.Archived)*100/float64(c.Total), 'f', 1, 64) + "%"
+}
+
+// RestoreStats counts recent restore tests by outcome.
+type RestoreStats struct {
+	Passed, Failed, Pending int64
+}
+
+func (r RestoreStats) PassRate() string {
+	return Coverage{Archived: r.Passed, Total: r.Passed + r.Failed}.Percent()
+}
+
+// TaskView is one SWH save request as shown on the app page.
+type TaskView struct {
+	db.Task
+	// from save request to the loader picking it up
+	Queued string
+	// from the loader picking it up to us seeing it finish
+	Loading string
+}
+
+func taskViewOf(task db.Task) TaskView {
+	v := TaskView{Task: task, Queued: "-", Loading: "-"}
+	requested, err1 := time.Parse(time.RFC3339Nano, task.SaveRequestDate)
+	visited, err2 := time.Parse(time.RFC3339Nano, task.VisitDate.String)
+	if err1 == nil && err2 == nil {
+		v.Queued = visited.Sub(requested).Round(time.Second).String()
+	}
+	if err2 == nil && task.FinishedAt.Valid {
+		v.Loading = time.UnixMilli(task.FinishedAt.Int64).Sub(visited).Round(time.Second).String()
+	}
+	return v
+}
+
+// Budget is an archiver's rate-limit budget as shown on the dashboard.
+type Budget struct {
+	Archiver     string
+	Limit        int
+	Remaining    int
+	Reset        string
+	BlockedUntil string
+}
+
+func budgetsOf(archivers []Archiver) []Budget {
+	var budgets []Budget
+	for _, a := range archivers {
+		b, ok := a.(budgeter)
+		if !ok {
+			continue
+		}
+		budget := b.Budget()
+		if budget.BlockedUntil.Before(time.Now()) {
+			budget.BlockedUntil = time.Time{}
+		}
+		budgets = append(budgets, Budget{
+			Archiver:     a.Name(),
+			Limit:        budget.Limit,
+			Remaining:    budget.Remaining,
+			Reset:        formatTime(budget.Reset),
+			BlockedUntil: formatTime(budget.BlockedUntil),
+		})
+	}
+	return budgets
+}
+
+// instancesOf lists the SWH instances the archivers push to.
+func instancesOf(archivers []Archiver) []string {
+	var instances []string
+	for _, a := range archivers {
+		if i, ok := a.(interface{ Instance() string }); ok {
+			instances = append(instances, i.Instance())
+		}
+	}
+	return instances
+}
+
+// primaryInstance is the SWH instance apps are scored against: the first
+// one configured, or the default instance.
+func primaryInstance(archivers []Archiver) string {
+	if instances := instancesOf(archivers); len(instances) > 0 {
+		return instances[0]
+	}
+	return defaultSWHInstance
+}
+
+// TimelineEvent is something that happened to an app or its origin.
+type TimelineEvent struct {
+	When time.Time
+	// F-Droid, save request or SWH visit
+	Source string
+	Detail string
+	Link   string
+}
+
+func (e TimelineEvent) At() string { return formatTime(e.When) }
+
+// Freshness tells how current the archive of an origin is.
+type Freshness struct {
+	// latest visit that produced a snapshot, "" if none
+	LastVisit string
+	Age       string
+	// releases F-Droid added since
+	NewerReleases int
+}
+
+// appTimeline merges F-Droid releases, our save requests and all SWH visits
+// of the app's origin, newest first.
+func appTimeline(app db.App, versions []db.Version, tasks []TaskView, visits []db.OriginVisit) ([]TimelineEvent, Freshness) {
+	var events []TimelineEvent
+	events = append(events, TimelineEvent{When: time.UnixMilli(app.MetaAdded), Source: "F-Droid", Detail: "added"})
+	for _, v := range versions {
+		events = append(events, TimelineEvent{
+			When:   time.UnixMilli(v.Added),
+			Source: "F-Droid",
+			Detail: fmt.Sprintf("release %s (%d)", v.VersionName, v.VersionCode),
+		})
+	}
+	for _, task := range tasks {
+		requested, err := time.Parse(time.RFC3339Nano, task.SaveRequestDate)
+		if err != nil {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			When:   requested,
+			Source: "save request",
+			Detail: task.SaveRequestStatus + "/" + task.SaveTaskStatus,
+			Link:   task.RequestUrl,
+		})
+	}
+
+	var freshness Freshness
+	var lastVisit time.Time
+	for _, visit := range visits {
+		date, err := time.Parse(time.RFC3339Nano, visit.Date)
+		if err != nil {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			When:   date,
+			Source: "SWH visit",
+			Detail: visit.Type + " " + visit.Status + " " + visit.SnapshotSwhid.String,
+		})
+		if visit.SnapshotSwhid.Valid && date.After(lastVisit) {
+			lastVisit = date
+		}
+	}
+	if !lastVisit.IsZero() {
+		freshness.LastVisit = formatTime(lastVisit)
+		if age := time.Since(lastVisit); age >= 48*time.Hour {
+			freshness.Age = fmt.Sprintf("%d days", int(age.Hours()/24))
+		} else {
+			freshness.Age = age.Round(time.Minute).String()
+		}
+		for _, v := range versions {
+			if time.UnixMilli(v.Added).After(lastVisit) {
+				freshness.NewerReleases++
+			}
+		}
+	}
+
+	slices.SortStableFunc(events, func(a, b TimelineEvent) int { return b.When.Compare(a.When) })
+	return events, freshness
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.DateTime)
 }
 
 var BIND = ":8080"
@@ -34,7 +277,7 @@ func init() {
 	}
 }
 
-func webui(ctx context.Context, wg *sync.WaitGroup) {
+func webui(ctx context.Context, wg *sync.WaitGroup, archivers []Archiver) {
 	defer wg.Done()
 
 	started := time.Now()
@@ -59,6 +302,11 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
 
 		offset := (page - 1) * pageSize
 
+		instance := r.URL.Query().Get("instance")
+		if instance == "" {
+			instance = defaultSWHInstance
+		}
+
 		apps, err := dbWriteSqlc.GetAllApps(ctx, db.GetAllAppsParams{
 			Package: "%",
 			Limit:   int64(pageSize),
@@ -69,17 +317,151 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
 			return
 		}
 
+		queue, err := dbWriteSqlc.CountQueue(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		coverageRows, err := dbWriteSqlc.GetReleaseCoverage(ctx, instance)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var coverage Coverage
+		appCoverage := map[string]db.GetReleaseCoverageRow{}
+		for _, row := range coverageRows {
+			coverage.Archived += row.Archived
+			coverage.Total += row.Total
+			appCoverage[row.Package] = row
+		}
+
+		restoreRows, err := dbWriteSqlc.GetRestoreTestStats(ctx, db.GetRestoreTestStatsParams{
+			Instance:    instance,
+			RequestedAt: time.Now().Add(-restoreRetestInterval).UnixMilli(),
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var restores RestoreStats
+		for _, row := range restoreRows {
+			switch row.Status {
+			case "passed":
+				restores.Passed = row.Count
+			case "failed":
+				restores.Failed = row.Count
+			case "pending":
+				restores.Pending = row.Count
+			}
+		}
+
+		alertRows, err := dbWriteSqlc.CountRefAlerts(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		alerts := map[string]int64{}
+		for _, row := range alertRows {
+			alerts[row.Package] = row.Alerts
+		}
+
+		lastAttempts, err := dbWriteSqlc.GetLastAttempts(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		appAttempts := map[string]AttemptView{}
+		for _, attempt := range lastAttempts {
+			appAttempts[attempt.Package.String] = attemptViewOf(attempt)
+		}
+
+		pauses, err := pausesOf(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		origins, err := dbWriteSqlc.GetOriginStats(ctx)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		failures, err := dbWriteSqlc.CountFailedAttempts(ctx, time.Now().Add(-24*time.Hour).UnixMilli())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		priorityRows, err := dbWriteSqlc.GetAppPriorities(ctx, db.GetAppPrioritiesParams{
+			Instance:   instance,
+			Instance_2: instance,
+			DetectedAt: time.Now().Add(-priorityRecent).UnixMilli(),
+			Package:    "%",
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		priorities := map[string]Priority{}
+		for _, row := range priorityRows {
+			priorities[row.Package] = scorePriority(row, PRIORITY_WEIGHTS, time.Now())
+		}
+
+		var mirror *db.GetMirrorUsageRow
+		if MIRROR_DIR != "" {
+			usage, err := dbWriteSqlc.GetMirrorUsage(ctx)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			mirror = &usage
+		}
+
+		var troubled []HostStatus
+		healthy := 0
+		for _, host := range forgeHosts.Status() {
+			if host.State == "ok" {
+				healthy++
+			} else {
+				troubled = append(troubled, host)
+			}
+		}
+
+		taskRows, err := dbWriteSqlc.GetAppTasks(ctx, instance)
+		if err != nil {
+			slog.Error("get tasks", "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		appTasks := map[string]db.GetAppTasksRow{}
+		for _, task := range taskRows {
+			appTasks[task.Package] = task
+		}
+
+		checkRows, err := dbWriteSqlc.GetLatestSnapshotChecks(ctx, db.GetLatestSnapshotChecksParams{
+			Instance:   instance,
+			Instance_2: instance,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		originChecks := map[string]db.SnapshotCheck{}
+		for _, check := range checkRows {
+			originChecks[check.OriginUrl] = check
+		}
+
 		var appList []App
 		for _, app := range apps {
-			var task db.Task
-			if app.LastTaskID.Valid {
-				task, err = dbWriteSqlc.GetTask(ctx, app.LastTaskID.Int64)
-				if err != nil {
-					slog.Error("get task", "err", err)
-					http.Error(w, err.Error(), http.StatusInternalServerError)
-					return
-				}
+			task := appTasks[app.Package]
+			check, checked := originChecks[swhOriginURL(app.MetaSourceCode)]
+			var lastAttempt *AttemptView
+			if attempt, ok := appAttempts[app.Package]; ok {
+				lastAttempt = &attempt
 			}
+			nextAttempt, gaveUp := retryStateOf(db.App(app))
 
 			appList = append(appList, App{
 				Package:           app.Package,
@@ -87,10 +469,19 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
 				MetaLastUpdated:   app.MetaLastUpdated,
 				MetaSourceCode:    app.MetaSourceCode,
 				LastSaveTriggered: app.LastSaveTriggered,
-				LastTaskID:        app.LastTaskID.Int64,
+				LastTaskID:        task.ID,
 				SaveRequestStatus: task.SaveRequestStatus,
 				SaveTaskStatus:    task.SaveTaskStatus,
 				SnapshotSwhid:     task.SnapshotSwhid.String,
+				Incomplete:        checked && !check.Complete,
+				Releases:          appCoverage[app.Package].Total,
+				ArchivedReleases:  appCoverage[app.Package].Archived,
+				Alerts:            alerts[app.Package],
+				LastAttempt:       lastAttempt,
+				SaveFailures:      app.SaveFailures,
+				NextAttempt:       nextAttempt,
+				GaveUp:            gaveUp,
+				Priority:          priorities[app.Package],
 			})
 		}
 
@@ -105,7 +496,82 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
         <body>
             <div class="container">
                 <h1>F-Droid Archive Status</h1>
-				<p> Uptime: {{.Uptime}}</p>
+				{{range .Pauses}}
+				<div class="alert alert-warning">
+					The {{.Component}} is paused since {{.Since}} by {{.Actor}}{{if .Reason}}: {{.Reason}}{{end}}
+				</div>
+				{{end}}
+				<p> Uptime: {{.Uptime}}{{if .Admin}} | <a href="/tokens">API tokens</a> | <a href="/admin">Admin</a>{{end}}</p>
+                <p>
+                    Releases archived: {{.Coverage.Archived}}/{{.Coverage.Total}} ({{.Coverage.Percent}})
+                </p>
+                <p>
+                    Origins: {{.Origins.Origins}}{{if .Origins.Shared}}, {{.Origins.Shared}} shared by {{.Origins.SharingApps}} apps{{end}}
+                </p>
+                {{with .Restores}}{{if or .Passed .Failed .Pending}}
+                <p>
+                    Restore tests (30 days): {{.Passed}} passed, {{.Failed}} failed ({{.PassRate}}){{if .Pending}}, {{.Pending}} cooking{{end}}
+                </p>
+                {{end}}{{end}}
+                {{with .Mirror}}
+                <p>
+                    Mirror: {{.Blobs}} files, {{.Size}} bytes
+                </p>
+                {{end}}
+                <p>
+                    Queue:
+                    {{range .Queue}}{{.Stage}} {{.Count}} {{else}}empty{{end}}
+                </p>
+                {{if .Failures}}
+                <p>
+                    Failed attempts (24h):
+                    {{range .Failures}}{{.Stage}} {{.ErrorClass.String}} {{.Count}}; {{end}}
+                </p>
+                {{end}}
+                {{if or .Hosts .HealthyHosts}}
+                <p>
+                    Forge hosts: {{.HealthyHosts}} healthy{{if .Hosts}}, {{len .Hosts}} in trouble{{end}}
+                </p>
+                {{end}}
+                {{if .Hosts}}
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>Host</th>
+                            <th>State</th>
+                            <th>Failures</th>
+                            <th>Paused Until</th>
+                            <th>Last Error</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Hosts}}
+                        <tr>
+                            <td>{{.Host}}</td>
+                            <td><span class="badge {{if eq .State "open"}}bg-danger{{else}}bg-warning text-dark{{end}}">{{.State}}</span></td>
+                            <td>{{.Failures}}</td>
+                            <td>{{.HeldUntil}}</td>
+                            <td>{{.LastError}} ({{.LastErrAt}})</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+                {{if gt (len .Instances) 1}}
+                <ul class="nav nav-tabs">
+                    {{range .Instances}}
+                    <li class="nav-item"><a class="nav-link {{if eq . $.Instance}}active{{end}}" href="/?instance={{.}}">{{.}}</a></li>
+                    {{end}}
+                </ul>
+                {{end}}
+                {{range .Budgets}}
+                <p>
+                    {{.Archiver}} API budget:
+                    {{if lt .Remaining 0}}unknown{{else}}{{.Remaining}}/{{.Limit}}{{end}},
+                    resets at {{.Reset}}
+                    {{if ne .BlockedUntil "-"}}<span class="badge bg-warning">throttled until {{.BlockedUntil}}</span>{{end}}
+                </p>
+                {{end}}
                 <table class="table">
                     <thead>
                         <tr>
@@ -115,25 +581,33 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
                             <th>Save Request Status</th>
                             <th>Save Task Status</th>
                             <th>Snapshot SWHID</th>
+                            <th>Releases</th>
+                            <th>Last Attempt</th>
+                            <th>Priority</th>
                         </tr>
                     </thead>
                     <tbody>
                         {{range .Apps}}
                         <tr>
-                            <td>{{.Package}}</td>
+                            <td><a href="/app/{{.Package}}">{{.Package}}</a>{{if .Alerts}} <span class="badge bg-warning text-dark">{{.Alerts}} alerts</span>{{end}}</td>
                             <td><a href="{{.MetaSourceCode}}">{{.MetaSourceCode}}</a></td>
                             <td>{{.LastSaveTriggered}}</td>
                             <td>{{.SaveRequestStatus}}</td>
                             <td>{{.SaveTaskStatus}}</td>
-                            <td>{{.SnapshotSwhid}}</td>
+                            <td>{{.SnapshotSwhid}}{{if .Incomplete}} <span class="badge bg-danger">incomplete</span>{{end}}</td>
+                            <td>{{if .Releases}}{{.ArchivedReleases}}/{{.Releases}}{{end}}</td>
+                            <td>{{with .LastAttempt}}<span{{if .Message.Valid}} title="{{.Message.String}}"{{end}}>{{.Stage}} {{if eq .Outcome "ok"}}ok{{else}}<span class="badge bg-danger">{{.ErrorClass.String}}</span>{{end}}</span>{{end}}
+                                {{if .GaveUp}}<span class="badge bg-dark">gave up after {{.SaveFailures}}</span>{{else if .NextAttempt}}<br>retry {{.SaveFailures}} at {{.NextAttempt}}{{end}}
+                            </td>
+                            <td>{{with .Priority}}<span title="{{range $i, $r := .Reasons}}{{if $i}}, {{end}}{{$r}}{{end}}">{{.}}</span>{{end}}</td>
                         </tr>
                         {{end}}
                     </tbody>
                 </table>
                 <nav aria-label="Page navigation">
                     <ul class="pagination">
-                        <li class="page-item"><a class="page-link" href="/?page={{.PrevPage}}">Previous</a></li>
-                        <li class="page-item"><a class="page-link" href="/?page={{.NextPage}}">Next</a></li>
+                        <li class="page-item"><a class="page-link" href="/?page={{.PrevPage}}&instance={{.Instance}}">Previous</a></li>
+                        <li class="page-item"><a class="page-link" href="/?page={{.NextPage}}&instance={{.Instance}}">Next</a></li>
                     </ul>
                 </nav>
             </div>
@@ -142,15 +616,41 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
         `
 
 		data := struct {
-			Uptime   string
-			Apps     []App
-			PrevPage int
-			NextPage int
+			Uptime       string
+			Admin        bool
+			Pauses       []PauseView
+			Queue        []db.CountQueueRow
+			Failures     []db.CountFailedAttemptsRow
+			Coverage     Coverage
+			Budgets      []Budget
+			Mirror       *db.GetMirrorUsageRow
+			Restores     RestoreStats
+			Origins      db.GetOriginStatsRow
+			Hosts        []HostStatus
+			HealthyHosts int
+			Instance     string
+			Instances    []string
+			Apps         []App
+			PrevPage     int
+			NextPage     int
 		}{
-			Uptime:   time.Since(started).String(),
-			Apps:     appList,
-			PrevPage: page - 1,
-			NextPage: page + 1,
+			Uptime:       time.Since(started).String(),
+			Admin:        len(ADMIN_USERS) > 0,
+			Pauses:       pauses,
+			Queue:        queue,
+			Failures:     failures,
+			Coverage:     coverage,
+			Budgets:      budgetsOf(archivers),
+			Mirror:       mirror,
+			Restores:     restores,
+			Origins:      origins,
+			Hosts:        troubled,
+			HealthyHosts: healthy,
+			Instance:     instance,
+			Instances:    instancesOf(archivers),
+			Apps:         appList,
+			PrevPage:     page - 1,
+			NextPage:     page + 1,
 		}
 
 		t, err := template.New("webpage").Parse(tmpl)
@@ -166,6 +666,572 @@ func webui(ctx context.Context, wg *sync.WaitGroup) {
 		}
 	})
 
+	mux.HandleFunc("/app/{package}", func(w http.ResponseWriter, r *http.Request) {
+		app, err := dbWriteSqlc.GetApp(ctx, r.PathValue("package"))
+		if errors.Is(err, sql.ErrNoRows) {
+			http.NotFound(w, r)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		type instanceTasks struct {
+			Instance string
+			Tasks    []TaskView
+			// latest snapshot verification, nil if none yet
+			Check     *db.SnapshotCheck
+			CheckedAt string
+			Releases  []ReleaseView
+			Coverage  Coverage
+			// releases whose source tree is archived, of those hashed
+			Sources   Coverage
+			Timeline  []TimelineEvent
+			Freshness Freshness
+		}
+		instances := instancesOf(archivers)
+		if len(instances) == 0 {
+			instances = []string{defaultSWHInstance}
+		}
+		versions, err := dbWriteSqlc.GetAppVersions(ctx, db.GetAppVersionsParams{
+			Package: app.Package,
+			Limit:   50,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		var history []instanceTasks
+		for _, instance := range instances {
+			tasks, err := dbWriteSqlc.GetTasksByOrigin(ctx, db.GetTasksByOriginParams{
+				Instance:  instance,
+				OriginUrl: swhOriginURL(app.MetaSourceCode),
+				Limit:     50,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			var views []TaskView
+			for _, task := range tasks {
+				views = append(views, taskViewOf(task))
+			}
+			entry := instanceTasks{Instance: instance, Tasks: views}
+
+			visits, err := dbWriteSqlc.GetOriginVisits(ctx, db.GetOriginVisitsParams{
+				Instance:  instance,
+				OriginUrl: swhOriginURL(app.MetaSourceCode),
+				Limit:     100,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entry.Timeline, entry.Freshness = appTimeline(app, versions, views, visits)
+
+			check, err := dbWriteSqlc.GetLatestSnapshotCheck(ctx, db.GetLatestSnapshotCheckParams{
+				Instance:  instance,
+				OriginUrl: swhOriginURL(app.MetaSourceCode),
+			})
+			if err == nil {
+				entry.Check = &check
+				entry.CheckedAt = formatTime(time.UnixMilli(check.CheckedAt))
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			releases, err := dbWriteSqlc.GetAppReleases(ctx, db.GetAppReleasesParams{
+				Instance: instance,
+				Package:  app.Package,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			entry.Coverage.Total = int64(len(releases))
+			for _, release := range releases {
+				if release.Known.Bool {
+					entry.Coverage.Archived++
+				}
+				view := ReleaseView{GetAppReleasesRow: release}
+				if release.SrcDirSwhid.Valid {
+					entry.Sources.Total++
+					known, err := dbWriteSqlc.GetKnownSwhid(ctx, db.GetKnownSwhidParams{
+						Instance: instance,
+						Swhid:    release.SrcDirSwhid.String,
+					})
+					if err == nil {
+						view.SourceKnown = sql.NullBool{Bool: known.Known, Valid: true}
+						if known.Known {
+							entry.Sources.Archived++
+						}
+					} else if !errors.Is(err, sql.ErrNoRows) {
+						http.Error(w, err.Error(), http.StatusInternalServerError)
+						return
+					}
+				}
+				task, err := dbWriteSqlc.GetVersionTask(ctx, db.GetVersionTaskParams{
+					Package:     release.Package,
+					VersionCode: release.VersionCode,
+					Instance:    instance,
+				})
+				if err == nil {
+					view.SourceTask = &task
+				} else if !errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				retry, err := dbWriteSqlc.GetVersionSaveRetry(ctx, db.GetVersionSaveRetryParams{
+					Package:     release.Package,
+					VersionCode: release.VersionCode,
+					Instance:    instance,
+				})
+				if err == nil {
+					view.SourceRetry = &retry
+				} else if !errors.Is(err, sql.ErrNoRows) {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+				entry.Releases = append(entry.Releases, view)
+			}
+			history = append(history, entry)
+		}
+
+		tmpl := `
+        <!DOCTYPE html>
+        <html>
+        <head>
+            <title>F-Droid Archive Status - {{.App.Package}}</title>
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-QWTKZyjpPEjISv5WaRU9O52fxxpTacIQykVvG9vrhcFDFCmGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
+        </head>
+        <body>
+            <div class="container">
+                <h1>{{.App.Package}}</h1>
+                <p><a href="/">Back to all apps</a></p>
+                {{if .Admin}}
+                <div class="mb-3">
+                    <form method="post" action="/admin/apps/{{.App.Package}}/save" class="d-inline"><button class="btn btn-sm btn-primary" type="submit">Save now</button></form>
+                    <form method="post" action="/admin/apps/{{.App.Package}}/cancel" class="d-inline"><button class="btn btn-sm btn-secondary" type="submit">Cancel queued work</button></form>
+                    <form method="post" action="/admin/apps/{{.App.Package}}/reset" class="d-inline"><button class="btn btn-sm btn-danger" type="submit">Reset state</button></form>
+                </div>
+                {{end}}
+                <dl class="row">
+                    <dt class="col-sm-3">Source Code</dt>
+                    <dd class="col-sm-9"><a href="{{.App.MetaSourceCode}}">{{.App.MetaSourceCode}}</a></dd>
+                    {{if .Siblings}}
+                    <dt class="col-sm-3">Saved Together With</dt>
+                    <dd class="col-sm-9">{{range $i, $p := .Siblings}}{{if $i}}, {{end}}<a href="/app/{{$p}}">{{$p}}</a>{{end}}</dd>
+                    {{end}}
+                    <dt class="col-sm-3">Added</dt>
+                    <dd class="col-sm-9">{{.App.MetaAdded}}</dd>
+                    <dt class="col-sm-3">Last Updated</dt>
+                    <dd class="col-sm-9">{{.App.MetaLastUpdated}}</dd>
+                    <dt class="col-sm-3">Last Save Triggered</dt>
+                    <dd class="col-sm-9">{{.App.LastSaveTriggered}}</dd>
+                    <dt class="col-sm-3">Categories</dt>
+                    <dd class="col-sm-9">{{.App.Categories}}</dd>
+                    <dt class="col-sm-3">Re-archiving</dt>
+                    <dd class="col-sm-9">{{if .CadenceDays}}every {{.CadenceDays}} days{{else}}off{{end}} ({{.CadenceFrom}})</dd>
+                    <dt class="col-sm-3">Priority</dt>
+                    <dd class="col-sm-9">{{.Priority}}{{range $i, $r := .Priority.Reasons}}{{if $i}},{{else}}:{{end}} {{$r}}{{end}}</dd>
+                    {{if .App.SaveFailures}}
+                    <dt class="col-sm-3">Failed Saves</dt>
+                    <dd class="col-sm-9">
+                        {{.App.SaveFailures}} in a row, last {{.App.LastErrorClass.String}};
+                        {{if .GaveUp}}gave up, retried on the next index update{{else if .NextAttempt}}next attempt at {{.NextAttempt}}{{else}}retrying now{{end}}
+                    </dd>
+                    {{end}}
+                </dl>
+                {{if .Alerts}}
+                <h2>Upstream alerts</h2>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>Detected</th>
+                            <th>Kind</th>
+                            <th>Ref</th>
+                            <th>Release</th>
+                            <th>Before</th>
+                            <th>After</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Alerts}}
+                        <tr>
+                            <td>{{.DetectedAt}}</td>
+                            <td>{{.Kind}}</td>
+                            <td>{{.Ref}}</td>
+                            <td>{{.Release.String}}</td>
+                            <td>{{.Before}}</td>
+                            <td>{{if .After.Valid}}{{.After.String}}{{else}}gone{{end}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+                {{if .Attempts}}
+                <h2>Attempts</h2>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>Started</th>
+                            <th>Took</th>
+                            <th>Archiver</th>
+                            <th>Stage</th>
+                            <th>Target</th>
+                            <th>Job</th>
+                            <th>Outcome</th>
+                            <th>Message</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Attempts}}
+                        <tr>
+                            <td>{{.StartedAt}}</td>
+                            <td>{{.Took}}</td>
+                            <td>{{.Archiver}}</td>
+                            <td>{{.Stage}}</td>
+                            <td>{{.Target}}</td>
+                            <td>{{.JobID.String}}</td>
+                            <td>{{if eq .Outcome "ok"}}ok{{else}}<span class="badge bg-danger">{{.ErrorClass.String}}</span>{{if .HttpStatus.Valid}} {{.HttpStatus.Int64}}{{end}}{{end}}</td>
+                            <td>{{.Message.String}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+                {{range .History}}
+                {{$checkedAt := .CheckedAt}}
+                {{with .Check}}
+                <h2>Snapshot verification ({{.Instance}})</h2>
+                <dl class="row">
+                    <dt class="col-sm-3">Result</dt>
+                    <dd class="col-sm-9">
+                        {{if .Complete}}<span class="badge bg-success">complete</span>{{else}}<span class="badge bg-danger">incomplete</span>{{end}}
+                        ({{$checkedAt}}, save request {{.TaskID}})
+                    </dd>
+                    <dt class="col-sm-3">Snapshot</dt>
+                    <dd class="col-sm-9">{{.SnapshotSwhid}}</dd>
+                    <dt class="col-sm-3">HEAD</dt>
+                    <dd class="col-sm-9">{{if .HeadSwhid.Valid}}{{.HeadSwhid.String}}{{else}}-{{end}}</dd>
+                    <dt class="col-sm-3">Branches / Tags</dt>
+                    <dd class="col-sm-9">{{.Branches}} / {{.Tags}}</dd>
+                    {{if .Missing}}
+                    <dt class="col-sm-3">Missing refs</dt>
+                    <dd class="col-sm-9"><pre>{{.Missing}}</pre>{{if gt .Requeues 1}}re-queued {{.Requeues}} times in a row{{end}}</dd>
+                    {{end}}
+                </dl>
+                {{end}}
+                {{if .Releases}}
+                <h2>Releases ({{.Instance}})</h2>
+                <p>
+                    {{.Coverage.Archived}}/{{.Coverage.Total}} releases archived
+                    {{if .Sources.Total}}and {{.Sources.Archived}}/{{.Sources.Total}} source trees{{end}}
+                </p>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>Version</th>
+                            <th>Code</th>
+                            <th>Tag</th>
+                            <th>Revision SWHID</th>
+                            <th>Archived</th>
+                            <th>Source Tree SWHID</th>
+                            <th>Archived</th>
+                            <th>Tarball Save</th>
+                            <th>Mirror</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Releases}}
+                        <tr>
+                            <td>{{.VersionName}}</td>
+                            <td>{{.VersionCode}}</td>
+                            <td>{{if .Tag.Valid}}{{.Tag.String}}{{else}}no matching tag{{end}}</td>
+                            <td>{{.RevisionSwhid.String}}</td>
+                            <td>{{if not .RevisionSwhid.Valid}}-{{else if not .Known.Valid}}not checked yet{{else if .Known.Bool}}yes{{else}}no{{end}}</td>
+                            <td>{{if .SrcDirSwhid.Valid}}{{.SrcDirSwhid.String}}{{else if .SrcError.Valid}}<span title="{{.SrcError.String}}">hashing failed</span>{{else if .SrcName.Valid}}not hashed yet{{else}}no source tarball{{end}}</td>
+                            <td>{{if not .SrcDirSwhid.Valid}}-{{else if not .SourceKnown.Valid}}not checked yet{{else if .SourceKnown.Bool}}yes{{else}}no{{end}}</td>
+                            <td>{{with .SourceTask}}<a href="{{.RequestUrl}}">{{.SaveRequestStatus}}/{{.SaveTaskStatus}}</a>{{else}}-{{end}}{{with .SourceRetry}}<br><span class="text-danger">{{.LastErrorClass.String}}, {{.Failures}} failed{{if .GaveUpAt.Valid}}, gave up{{end}}</span>{{end}}</td>
+                            <td{{if .MirrorError.Valid}} title="{{.MirrorError.String}}"{{end}}>{{if .ApkMirrored}}APK{{end}}{{if .SrcMirrored}} source{{end}}{{if not (or .ApkMirrored .SrcMirrored)}}{{if .MirrorError.Valid}}failed{{else}}-{{end}}{{end}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+                <h2>Timeline ({{.Instance}})</h2>
+                <p>
+                    {{with .Freshness}}{{if .LastVisit}}Last archived visit: {{.LastVisit}} ({{.Age}} ago), {{.NewerReleases}} releases since{{else}}No archived visit known{{end}}{{end}}
+                </p>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>When</th>
+                            <th>Source</th>
+                            <th>Event</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Timeline}}
+                        <tr>
+                            <td>{{.At}}</td>
+                            <td>{{.Source}}</td>
+                            <td>{{if .Link}}<a href="{{.Link}}">{{.Detail}}</a>{{else}}{{.Detail}}{{end}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                <h2>Save requests ({{.Instance}})</h2>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>ID</th>
+                            <th>Requested</th>
+                            <th>Request</th>
+                            <th>Task</th>
+                            <th>Visit</th>
+                            <th>Queued</th>
+                            <th>Loading</th>
+                            <th>Snapshot SWHID</th>
+                            <th>Note</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Tasks}}
+                        <tr>
+                            <td><a href="{{.RequestUrl}}">{{.ID}}</a></td>
+                            <td>{{.SaveRequestDate}}</td>
+                            <td>{{.SaveRequestStatus}}</td>
+                            <td>{{.SaveTaskStatus}}{{if .LoadingTaskID.Valid}} (#{{.LoadingTaskID.Int64}}){{end}}</td>
+                            <td>{{.VisitType}} {{.VisitStatus.String}}<br>{{.VisitDate.String}}</td>
+                            <td>{{.Queued}}</td>
+                            <td>{{.Loading}}</td>
+                            <td>{{.SnapshotSwhid.String}}</td>
+                            <td>
+                                {{.Note.String}}
+                                {{if .AbandonReason.Valid}}<span class="text-danger">not polled any more: {{.AbandonReason.String}}</span>{{end}}
+                                {{if .Raw}}<details><summary>raw</summary><pre>{{.Raw}}</pre></details>{{end}}
+                            </td>
+                        </tr>
+                        {{else}}
+                        <tr><td colspan="9">No save request yet.</td></tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+            </div>
+        </body>
+        </html>
+        `
+
+		alertRows, err := dbWriteSqlc.GetAppRefAlerts(ctx, db.GetAppRefAlertsParams{
+			Package: app.Package,
+			Limit:   50,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var alerts []AlertView
+		for _, alert := range alertRows {
+			alerts = append(alerts, AlertView{RefAlert: alert, DetectedAt: formatTime(time.UnixMilli(alert.DetectedAt))})
+		}
+
+		attemptRows, err := dbWriteSqlc.GetAppAttempts(ctx, db.GetAppAttemptsParams{
+			Package:  sql.NullString{String: app.Package, Valid: true},
+			Target:   app.MetaSourceCode,
+			Target_2: swhOriginURL(app.MetaSourceCode),
+			Limit:    50,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var attempts []AttemptView
+		for _, attempt := range attemptRows {
+			attempts = append(attempts, attemptViewOf(attempt))
+		}
+
+		var priority Priority
+		priorityRows, err := dbWriteSqlc.GetAppPriorities(ctx, db.GetAppPrioritiesParams{
+			Instance:   instances[0],
+			Instance_2: instances[0],
+			DetectedAt: time.Now().Add(-priorityRecent).UnixMilli(),
+			Package:    app.Package,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, row := range priorityRows {
+			priority = scorePriority(row, PRIORITY_WEIGHTS, time.Now())
+		}
+
+		var siblings []string
+		if app.OriginUrl.Valid {
+			pkgs, err := dbWriteSqlc.GetOriginPackages(ctx, app.Package)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			for _, pkg := range pkgs {
+				if pkg != app.Package {
+					siblings = append(siblings, pkg)
+				}
+			}
+		}
+
+		cadence, cadenceFrom := cadenceOf(app.Package, app.MetaSourceCode, app.Categories)
+		nextAttempt, gaveUp := retryStateOf(app)
+		data := struct {
+			App         db.App
+			Siblings    []string
+			CadenceDays int
+			CadenceFrom string
+			Priority    Priority
+			NextAttempt string
+			GaveUp      bool
+			Alerts      []AlertView
+			Attempts    []AttemptView
+			History     []instanceTasks
+			Admin       bool
+		}{
+			App:         app,
+			Siblings:    siblings,
+			CadenceDays: int(cadence.Hours() / 24),
+			CadenceFrom: cadenceFrom,
+			Priority:    priority,
+			NextAttempt: nextAttempt,
+			GaveUp:      gaveUp,
+			Alerts:      alerts,
+			Attempts:    attempts,
+			History:     history,
+			Admin:       len(ADMIN_USERS) > 0,
+		}
+
+		t, err := template.New("app").Parse(tmpl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = t.Execute(w, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	})
+
+	// token labels and the URLs they were used on are for admins only
+	mux.HandleFunc("/tokens", requireAdmin(func(w http.ResponseWriter, r *http.Request, _ string) {
+		type poolView struct {
+			Archiver string
+			Tokens   []TokenStatus
+			Log      []TokenUse
+		}
+		var pools []poolView
+		for _, a := range archivers {
+			t, ok := a.(interface{ Tokens() *tokenPool })
+			if !ok {
+				continue
+			}
+			pools = append(pools, poolView{
+				Archiver: a.Name(),
+				Tokens:   t.Tokens().Status(),
+				Log:      t.Tokens().Log(),
+			})
+		}
+
+		tmpl := `
+        <!DOCTYPE html>
+        <html>
+        <head>
+            <title>F-Droid Archive Status - API tokens</title>
+            <link href="https://cdn.jsdelivr.net/npm/bootstrap@5.3.3/dist/css/bootstrap.min.css" rel="stylesheet" integrity="sha384-QWTKZyjpPEjISv5WaRU9O52fxxpTacIQykVvG9vrhcFDFCmGmJRAkycuHAHRg32OmUcww7on3RYdg4Va+PmSTsz/K68vbdEjh4u" crossorigin="anonymous">
+        </head>
+        <body>
+            <div class="container">
+                <h1>API tokens</h1>
+                {{if not .}}<p>No token configured, running web-only.</p>{{end}}
+                {{range .}}
+                <h2>{{.Archiver}}</h2>
+                <table class="table">
+                    <thead>
+                        <tr>
+                            <th>Token</th>
+                            <th>State</th>
+                            <th>Budget</th>
+                            <th>Requests</th>
+                            <th>Last Used</th>
+                            <th>Last Status</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Tokens}}
+                        <tr>
+                            <td>{{.Label}}</td>
+                            <td>{{if .Revoked}}revoked until {{.RevokedUntil.Format "2006-01-02 15:04:05"}}{{else}}active{{end}}</td>
+                            <td>{{if lt .Budget.Remaining 0}}unknown{{else}}{{.Budget.Remaining}}/{{.Budget.Limit}}{{end}}</td>
+                            <td>{{.Requests}}</td>
+                            <td>{{.LastUsed.Format "2006-01-02 15:04:05"}}</td>
+                            <td>{{.LastStatus}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                <h3>Recent requests</h3>
+                <table class="table table-sm">
+                    <thead>
+                        <tr>
+                            <th>Time</th>
+                            <th>Token</th>
+                            <th>Request</th>
+                            <th>Status</th>
+                        </tr>
+                    </thead>
+                    <tbody>
+                        {{range .Log}}
+                        <tr>
+                            <td>{{.Time.Format "2006-01-02 15:04:05"}}</td>
+                            <td>{{.Token}}</td>
+                            <td>{{.Method}} {{.URL}}</td>
+                            <td>{{.Status}}</td>
+                        </tr>
+                        {{end}}
+                    </tbody>
+                </table>
+                {{end}}
+            </div>
+        </body>
+        </html>
+        `
+
+		t, err := template.New("tokens").Parse(tmpl)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		err = t.Execute(w, pools)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}))
+
+	adminRoutes(ctx, mux, archivers)
+
+	for _, a := range archivers {
+		h, ok := a.(interface{ Webhook() http.Handler })
+		if !ok || h.Webhook() == nil {
+			continue
+		}
+		mux.Handle("POST /webhook/"+a.Name(), h.Webhook())
+		slog.Info("webhook endpoint", "archiver", a.Name(), "path", "/webhook/"+a.Name())
+	}
+
 	server := &http.Server{
 		Addr:    BIND,
 		Handler: mux,
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/saveweb/fdroidswh/db"
+)
+
+// SWH delivers webhooks through Svix: every message carries an id, a
+// timestamp and one or more "v1,<base64 HMAC-SHA256>" signatures of
+// "<id>.<timestamp>.<body>", keyed with the endpoint's "whsec_" secret.
+const (
+	webhookMaxBody   = 1 << 20
+	webhookTolerance = 5 * time.Minute
+	// with webhooks, accepted save requests are only polled this often, in
+	// case a delivery gets lost
+	webhookPollInterval = time.Hour
+)
+
+var badWebhookSignature = errors.New("bad webhook signature")
+
+// loadWebhookSecret reads the signing secret of an SWH instance's webhook
+// endpoint from SWH_WEBHOOK_SECRET (SWH_WEBHOOK_SECRET_<INSTANCE> for other
+// instances). Returns nil if webhooks are not set up.
+func loadWebhookSecret(instance string) ([]byte, error) {
+	secret := os.Getenv("SWH_WEBHOOK_SECRET" + swhEnvSuffix(instance))
+	if secret == "" {
+		return nil, nil
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimPrefix(secret, "whsec_"))
+}
+
+// verifyWebhook checks the Svix signature headers of a delivery.
+func verifyWebhook(secret []byte, header http.Header, body []byte, now time.Time) error {
+	// older Svix deployments use the unbranded webhook-* headers
+	get := func(name string) string {
+		if v := header.Get("Svix-" + name); v != "" {
+			return v
+		}
+		return header.Get("Webhook-" + name)
+	}
+	id, ts, sigs := get("Id"), get("Timestamp"), get("Signature")
+	if id == "" || ts == "" || sigs == "" {
+		return badWebhookSignature
+	}
+
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return badWebhookSignature
+	}
+	if sent := time.Unix(secs, 0); sent.Before(now.Add(-webhookTolerance)) || sent.After(now.Add(webhookTolerance)) {
+		return errors.Join(badWebhookSignature, errors.New("timestamp out of tolerance"))
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(id + "." + ts + "."))
+	mac.Write(body)
+	want := mac.Sum(nil)
+
+	for _, sig := range strings.Fields(sigs) {
+		version, value, ok := strings.Cut(sig, ",")
+		if !ok || version != "v1" {
+			continue
+		}
+		got, err := base64.StdEncoding.DecodeString(value)
+		if err == nil && hmac.Equal(got, want) {
+			return nil
+		}
+	}
+	return badWebhookSignature
+}
+
+// VisitEvent is the payload of an origin.visit webhook.
+type VisitEvent struct {
+	Origin string `json:"origin"`
+	// some senders use the save request's field name
+	OriginUrl     string `json:"origin_url"`
+	VisitType     string `json:"visit_type"`
+	VisitDate     string `json:"visit_date"`
+	VisitStatus   string `json:"visit_status"`
+	SnapshotSwhid string `json:"snapshot_swhid"`
+}
+
+// saveTaskStatusOf maps a visit status to the save task status SWH reports
+// once it has seen that visit.
+func saveTaskStatusOf(visitStatus string) string {
+	switch visitStatus {
+	case "full", "partial":
+		return "succeeded"
+	case "failed", "not_found":
+		return "failed"
+	default: // created, ongoing
+		return "running"
+	}
+}
+
+// applyVisit updates the unfinished save requests for the visited origin.
+// Requests made after the visit started are left alone, their own visit is
+// still to come. Returns how many tasks were updated.
+func (a *swhArchiver) applyVisit(ctx context.Context, ev VisitEvent) (int, error) {
+	origin := ev.Origin
+	if origin == "" {
+		origin = ev.OriginUrl
+	}
+	tasks, err := dbWriteSqlc.GetTasksByOrigin(ctx, db.GetTasksByOriginParams{
+		Instance:  a.instance,
+		OriginUrl: swhOriginURL(origin),
+		Limit:     50,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	visitDate, visitErr := time.Parse(time.RFC3339, ev.VisitDate)
+	updated := 0
+	for _, task := range tasks {
+		taskResp := taskRespFromRow(task)
+		if taskResp.finished() || taskResp.SaveRequestStatus != "accepted" {
+			continue
+		}
+		if requested, err := time.Parse(time.RFC3339, taskResp.SaveRequestDate); err == nil && visitErr == nil && visitDate.Before(requested) {
+			continue
+		}
+
+		taskResp.SaveTaskStatus = saveTaskStatusOf(ev.VisitStatus)
+		taskResp.VisitStatus = ev.VisitStatus
+		taskResp.VisitDate = ev.VisitDate
+		if ev.SnapshotSwhid != "" {
+			taskResp.SnapshotSwhid = ev.SnapshotSwhid
+		}
+		if err := saveTaskRespToDB(ctx, a.instance, taskResp); err != nil {
+			return updated, err
+		}
+		if taskResp.finished() {
+			pkgs, err := dbWriteSqlc.GetTaskPackages(ctx, db.GetTaskPackagesParams{
+				Instance:   a.instance,
+				LastTaskID: task.ID,
+			})
+			if err != nil {
+				return updated, err
+			}
+			st := taskRespToStatus(taskResp)
+			settleSaves(ctx, a, pkgs, st)
+			if err := a.settleSourceSaves(ctx, task.ID, statusErr(a, st), time.Now()); err != nil {
+				return updated, err
+			}
+		}
+		updated++
+	}
+	return updated, nil
+}
+
+// Webhook returns the handler for the instance's webhook endpoint, or nil
+// if no signing secret is configured.
+func (a *swhArchiver) Webhook() http.Handler {
+	if a.webhookSecret == nil {
+		return nil
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(io.LimitReader(r.Body, webhookMaxBody))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := verifyWebhook(a.webhookSecret, r.Header, body, time.Now()); err != nil {
+			slog.Warn("webhook rejected", "archiver", a.Name(), "remote", r.RemoteAddr, "err", err)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		var ev VisitEvent
+		if err := json.Unmarshal(body, &ev); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if (ev.Origin == "" && ev.OriginUrl == "") || ev.VisitStatus == "" {
+			// not a visit event, nothing to do
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		updated, err := a.applyVisit(r.Context(), ev)
+		if err != nil {
+			slog.Error("webhook", "archiver", a.Name(), "err", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		slog.Info("webhook ok", "archiver", a.Name(), "origin", ev.Origin+ev.OriginUrl, "visit_status", ev.VisitStatus, "updated", updated)
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func Test_verifyWebhook(t *testing.T) {
+	secret := []byte("test secret")
+	body := []byte(`{"origin":"https://example.org/repo/","visit_status":"full"}`)
+	now := time.Unix(1700000000, 0)
+
+	sign := func(id string, ts time.Time, body []byte) http.Header {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(id + "." + strconv.FormatInt(ts.Unix(), 10) + "."))
+		mac.Write(body)
+		h := http.Header{}
+		h.Set("Svix-Id", id)
+		h.Set("Svix-Timestamp", strconv.FormatInt(ts.Unix(), 10))
+		h.Set("Svix-Signature", "v1,bm90IGl0 v1,"+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+		return h
+	}
+
+	if err := verifyWebhook(secret, sign("msg_1", now, body), body, now); err != nil {
+		t.Errorf("valid delivery rejected: %v", err)
+	}
+
+	for name, header := range map[string]http.Header{
+		"tampered": sign("msg_1", now, []byte(`{}`)),
+		"stale":    sign("msg_1", now.Add(-time.Hour), body),
+		"unsigned": {},
+	} {
+		if err := verifyWebhook(secret, header, body, now); !errors.Is(err, badWebhookSignature) {
+			t.Errorf("%s: got %v, want badWebhookSignature", name, err)
+		}
+	}
+}